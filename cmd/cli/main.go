@@ -74,6 +74,14 @@ var commandList = [][]string{
 	{"ZREVGETBYRANK", "key rank", "ZSET"},
 	{"ZSCORERANGE", "key min max", "ZSET"},
 	{"ZREVSCORERANGE", "key max min", "ZSET"},
+
+	{"WATCH", "key [key...]", "TRANSACTION"},
+	{"UNWATCH", "", "TRANSACTION"},
+	{"MULTI", "", "TRANSACTION"},
+	{"EXEC", "", "TRANSACTION"},
+	{"DISCARD", "", "TRANSACTION"},
+
+	{"BACKUP", "dir", "SERVER"},
 }
 
 var host = flag.String("h", "127.0.0.1", "the zerokv server host, default 127.0.0.1")
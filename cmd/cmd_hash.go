@@ -1,16 +1,10 @@
 package cmd
 
 import (
-	"zeroDB/db"
-
 	"github.com/tidwall/redcon"
 )
 
-func hSet(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 3 {
-		err = newWrongNumOfArgsError("hset")
-		return
-	}
+func hSet(db kvStore, args []string) (res interface{}, err error) {
 	var count int
 	if count, err = db.HSet([]byte(args[0]), []byte(args[1]), []byte(args[2])); err == nil {
 		res = redcon.SimpleInt(count)
@@ -18,11 +12,7 @@ func hSet(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func hSetNx(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 3 {
-		err = newWrongNumOfArgsError("hsetnx")
-		return
-	}
+func hSetNx(db kvStore, args []string) (res interface{}, err error) {
 	var ok int
 	if ok, err = db.HSetNx([]byte(args[0]), []byte(args[1]), []byte(args[2])); err == nil {
 		if ok == 1 {
@@ -34,11 +24,7 @@ func hSetNx(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func hGet(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = ErrSyntaxIncorrect
-		return
-	}
+func hGet(db kvStore, args []string) (res interface{}, err error) {
 	val := db.HGet([]byte(args[0]), []byte(args[1]))
 	if len(val) == 0 {
 		res = nil
@@ -48,21 +34,12 @@ func hGet(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func hGetAll(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("hgetall")
-		return
-	}
+func hGetAll(db kvStore, args []string) (res interface{}, err error) {
 	res = db.HGetAll([]byte(args[0]))
 	return
 }
 
-func hDel(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) <= 1 {
-		err = newWrongNumOfArgsError("hdel")
-		return
-	}
-
+func hDel(db kvStore, args []string) (res interface{}, err error) {
 	var fields [][]byte
 	for _, f := range args[1:] {
 		fields = append(fields, []byte(f))
@@ -74,11 +51,7 @@ func hDel(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func hExists(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = newWrongNumOfArgsError("hexists")
-		return
-	}
+func hExists(db kvStore, args []string) (res interface{}, err error) {
 	exists := db.HExists([]byte(args[0]), []byte(args[1]))
 	if exists {
 		res = redcon.SimpleInt(1)
@@ -88,42 +61,30 @@ func hExists(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func hLen(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("hlen")
-		return
-	}
+func hLen(db kvStore, args []string) (res interface{}, err error) {
 	count := db.HLen([]byte(args[0]))
 	res = redcon.SimpleInt(count)
 	return
 }
 
-func hKeys(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = ErrSyntaxIncorrect
-		return
-	}
+func hKeys(db kvStore, args []string) (res interface{}, err error) {
 	res = db.HKeys([]byte(args[0]))
 	return
 }
 
-func hVals(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("hvals")
-		return
-	}
+func hVals(db kvStore, args []string) (res interface{}, err error) {
 	res = db.HVals([]byte(args[0]))
 	return
 }
 
 func init() {
-	addExecCommand("hset", hSet)
-	addExecCommand("hsetnx", hSetNx)
-	addExecCommand("hget", hGet)
-	addExecCommand("hgetall", hGetAll)
-	addExecCommand("hdel", hDel)
-	addExecCommand("hexists", hExists)
-	addExecCommand("hlen", hLen)
-	addExecCommand("hkeys", hKeys)
-	addExecCommand("hvals", hVals)
+	addKVCommand("hset", 3, 3, hSet)
+	addKVCommand("hsetnx", 3, 3, hSetNx)
+	addKVCommand("hget", 2, 2, hGet)
+	addKVCommand("hgetall", 1, 1, hGetAll)
+	addKVCommand("hdel", 2, -1, hDel)
+	addKVCommand("hexists", 2, 2, hExists)
+	addKVCommand("hlen", 1, 1, hLen)
+	addKVCommand("hkeys", 1, 1, hKeys)
+	addKVCommand("hvals", 1, 1, hVals)
 }
@@ -3,17 +3,11 @@ package cmd
 import (
 	"strconv"
 	"zeroDB/datastructure/list"
-	"zeroDB/db"
 
 	"github.com/tidwall/redcon"
 )
 
-func lPush(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) < 2 {
-		err = newWrongNumOfArgsError("lpush")
-		return
-	}
-
+func lPush(db kvStore, args []string) (res interface{}, err error) {
 	var values [][]byte
 	for i := 1; i < len(args); i++ {
 		values = append(values, []byte(args[i]))
@@ -26,12 +20,7 @@ func lPush(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func rPush(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) < 2 {
-		err = newWrongNumOfArgsError("rpush")
-		return
-	}
-
+func rPush(db kvStore, args []string) (res interface{}, err error) {
 	var values [][]byte
 	for i := 1; i < len(args); i++ {
 		values = append(values, []byte(args[i]))
@@ -44,12 +33,7 @@ func rPush(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func lPop(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("lpop")
-		return
-	}
-
+func lPop(db kvStore, args []string) (res interface{}, err error) {
 	var val []byte
 	if val, err = db.LPop([]byte(args[0])); err == nil {
 		res = string(val)
@@ -57,12 +41,7 @@ func lPop(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func rPop(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("rpop")
-		return
-	}
-
+func rPop(db kvStore, args []string) (res interface{}, err error) {
 	var val []byte
 	if val, err = db.RPop([]byte(args[0])); err == nil {
 		res = string(val)
@@ -70,11 +49,7 @@ func rPop(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func lIndex(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 0 {
-		err = newWrongNumOfArgsError("lindex")
-		return
-	}
+func lIndex(db kvStore, args []string) (res interface{}, err error) {
 	index, err := strconv.Atoi(args[1])
 	if err != nil {
 		err = ErrSyntaxIncorrect
@@ -86,11 +61,7 @@ func lIndex(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func lRem(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 3 {
-		err = newWrongNumOfArgsError("lrem")
-		return
-	}
+func lRem(db kvStore, args []string) (res interface{}, err error) {
 	count, err := strconv.Atoi(args[2])
 	if err != nil {
 		err = ErrSyntaxIncorrect
@@ -104,11 +75,7 @@ func lRem(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func lInsert(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 4 {
-		err = newWrongNumOfArgsError("linsert")
-		return
-	}
+func lInsert(db kvStore, args []string) (res interface{}, err error) {
 	var flag int
 	if args[1] == "BEFORE" {
 		flag = 0
@@ -123,11 +90,7 @@ func lInsert(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func lSet(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 3 {
-		err = newWrongNumOfArgsError("lset")
-		return
-	}
+func lSet(db kvStore, args []string) (res interface{}, err error) {
 	index, err := strconv.Atoi(args[1])
 	if err != nil {
 		err = ErrSyntaxIncorrect
@@ -144,11 +107,7 @@ func lSet(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func lTrim(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 3 {
-		err = newWrongNumOfArgsError("ltrim")
-		return
-	}
+func lTrim(db kvStore, args []string) (res interface{}, err error) {
 	start, err := strconv.Atoi(args[1])
 	if err != nil {
 		err = ErrSyntaxIncorrect
@@ -166,11 +125,7 @@ func lTrim(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func lRange(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 3 {
-		err = newWrongNumOfArgsError("lrange")
-		return
-	}
+func lRange(db kvStore, args []string) (res interface{}, err error) {
 	start, err := strconv.Atoi(args[1])
 	if err != nil {
 		err = ErrSyntaxIncorrect
@@ -186,23 +141,13 @@ func lRange(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func lLen(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("llen")
-		return
-	}
-
+func lLen(db kvStore, args []string) (res interface{}, err error) {
 	length := db.LLen([]byte(args[0]))
 	res = redcon.SimpleInt(length)
 	return
 }
 
-func LKeyExists(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("lkeyexists")
-		return
-	}
-
+func LKeyExists(db kvStore, args []string) (res interface{}, err error) {
 	if ok := db.LKeyExists([]byte(args[0])); ok {
 		res = redcon.SimpleInt(1)
 
@@ -212,12 +157,7 @@ func LKeyExists(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func LValExists(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = newWrongNumOfArgsError("lvalexists")
-		return
-	}
-
+func LValExists(db kvStore, args []string) (res interface{}, err error) {
 	if ok := db.LValExists([]byte(args[0]), []byte(args[1])); ok {
 		res = redcon.SimpleInt(1)
 	} else {
@@ -227,17 +167,17 @@ func LValExists(db *db.DB, args []string) (res interface{}, err error) {
 }
 
 func init() {
-	addExecCommand("lpush", lPush)
-	addExecCommand("rpush", rPush)
-	addExecCommand("lpop", lPop)
-	addExecCommand("rpop", rPop)
-	addExecCommand("lindex", lIndex)
-	addExecCommand("lrem", lRem)
-	addExecCommand("linsert", lInsert)
-	addExecCommand("lset", lSet)
-	addExecCommand("ltrim", lTrim)
-	addExecCommand("lrange", lRange)
-	addExecCommand("llen", lLen)
-	addExecCommand("lkeyexists", LKeyExists)
-	addExecCommand("lvalexists", LValExists)
+	addKVCommand("lpush", 2, -1, lPush)
+	addKVCommand("rpush", 2, -1, rPush)
+	addKVCommand("lpop", 1, 1, lPop)
+	addKVCommand("rpop", 1, 1, rPop)
+	addKVCommand("lindex", 2, 2, lIndex)
+	addKVCommand("lrem", 3, 3, lRem)
+	addKVCommand("linsert", 4, 4, lInsert)
+	addKVCommand("lset", 3, 3, lSet)
+	addKVCommand("ltrim", 3, 3, lTrim)
+	addKVCommand("lrange", 3, 3, lRange)
+	addKVCommand("llen", 1, 1, lLen)
+	addKVCommand("lkeyexists", 1, 1, LKeyExists)
+	addKVCommand("lvalexists", 2, 2, LValExists)
 }
@@ -2,17 +2,11 @@ package cmd
 
 import (
 	"strconv"
-	"zeroDB/db"
 
 	"github.com/tidwall/redcon"
 )
 
-func sAdd(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) <= 1 {
-		err = newWrongNumOfArgsError("sadd")
-		return
-	}
-
+func sAdd(db kvStore, args []string) (res interface{}, err error) {
 	var members [][]byte
 	for _, m := range args[1:] {
 		members = append(members, []byte(m))
@@ -24,11 +18,7 @@ func sAdd(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func sPop(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = newWrongNumOfArgsError("spop")
-		return
-	}
+func sPop(db kvStore, args []string) (res interface{}, err error) {
 	count, err := strconv.Atoi(args[1])
 	if err != nil {
 		err = ErrSyntaxIncorrect
@@ -38,11 +28,7 @@ func sPop(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func sIsMember(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = newWrongNumOfArgsError("sismember")
-		return
-	}
+func sIsMember(db kvStore, args []string) (res interface{}, err error) {
 	if ok := db.SIsMember([]byte(args[0]), []byte(args[1])); ok {
 		res = redcon.SimpleInt(1)
 	} else {
@@ -51,11 +37,7 @@ func sIsMember(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func sRandMember(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = newWrongNumOfArgsError("srandmember")
-		return
-	}
+func sRandMember(db kvStore, args []string) (res interface{}, err error) {
 	count, err := strconv.Atoi(args[1])
 	if err != nil {
 		err = ErrSyntaxIncorrect
@@ -65,11 +47,7 @@ func sRandMember(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func sRem(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) <= 1 {
-		err = ErrSyntaxIncorrect
-		return
-	}
+func sRem(db kvStore, args []string) (res interface{}, err error) {
 	var members [][]byte
 	for _, m := range args[1:] {
 		members = append(members, []byte(m))
@@ -81,41 +59,25 @@ func sRem(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func sMove(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 3 {
-		err = newWrongNumOfArgsError("smove")
-		return
-	}
+func sMove(db kvStore, args []string) (res interface{}, err error) {
 	if err = db.SMove([]byte(args[0]), []byte(args[1]), []byte(args[2])); err == nil {
 		res = okResult
 	}
 	return
 }
 
-func sCard(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("scard")
-		return
-	}
+func sCard(db kvStore, args []string) (res interface{}, err error) {
 	card := db.SCard([]byte(args[0]))
 	res = redcon.SimpleInt(card)
 	return
 }
 
-func sMembers(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("smembers")
-		return
-	}
+func sMembers(db kvStore, args []string) (res interface{}, err error) {
 	res = db.SMembers([]byte(args[0]))
 	return
 }
 
-func sUnion(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) <= 0 {
-		err = newWrongNumOfArgsError("sunion")
-		return
-	}
+func sUnion(db kvStore, args []string) (res interface{}, err error) {
 	var keys [][]byte
 	for _, v := range args {
 		keys = append(keys, []byte(v))
@@ -124,11 +86,7 @@ func sUnion(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func sDiff(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) <= 0 {
-		err = newWrongNumOfArgsError("sdiff")
-		return
-	}
+func sDiff(db kvStore, args []string) (res interface{}, err error) {
 	var keys [][]byte
 	for _, v := range args {
 		keys = append(keys, []byte(v))
@@ -138,14 +96,14 @@ func sDiff(db *db.DB, args []string) (res interface{}, err error) {
 }
 
 func init() {
-	addExecCommand("sadd", sAdd)
-	addExecCommand("spop", sPop)
-	addExecCommand("sismember", sIsMember)
-	addExecCommand("srandmember", sRandMember)
-	addExecCommand("srem", sRem)
-	addExecCommand("smove", sMove)
-	addExecCommand("scard", sCard)
-	addExecCommand("smembers", sMembers)
-	addExecCommand("sunion", sUnion)
-	addExecCommand("sdiff", sDiff)
+	addKVCommand("sadd", 2, -1, sAdd)
+	addKVCommand("spop", 2, 2, sPop)
+	addKVCommand("sismember", 2, 2, sIsMember)
+	addKVCommand("srandmember", 2, 2, sRandMember)
+	addKVCommand("srem", 2, -1, sRem)
+	addKVCommand("smove", 3, 3, sMove)
+	addKVCommand("scard", 1, 1, sCard)
+	addKVCommand("smembers", 1, 1, sMembers)
+	addKVCommand("sunion", 1, -1, sUnion)
+	addKVCommand("sdiff", 1, -1, sDiff)
 }
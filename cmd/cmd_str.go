@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
-	"zeroDB/db"
 
 	"github.com/tidwall/redcon"
 )
@@ -17,12 +16,7 @@ func newWrongNumOfArgsError(cmd string) error {
 	return fmt.Errorf("wrong number of arguments for '%s' command", cmd)
 }
 
-func set(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = newWrongNumOfArgsError("set")
-		return
-	}
-
+func set(db kvStore, args []string) (res interface{}, err error) {
 	key, value := args[0], args[1]
 	if err = db.Set([]byte(key), []byte(value)); err == nil {
 		res = okResult
@@ -30,12 +24,7 @@ func set(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func get(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("get")
-		return
-	}
-
+func get(db kvStore, args []string) (res interface{}, err error) {
 	key := args[0]
 	var val string
 	err = db.Get([]byte(key), &val)
@@ -43,12 +32,7 @@ func get(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func setNx(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = newWrongNumOfArgsError("setnx")
-		return
-	}
-
+func setNx(db kvStore, args []string) (res interface{}, err error) {
 	key, value := args[0], args[1]
 	result, err := db.SetNx([]byte(key), []byte(value))
 
@@ -58,12 +42,7 @@ func setNx(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func getSet(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = newWrongNumOfArgsError("getset")
-		return
-	}
-
+func getSet(db kvStore, args []string) (res interface{}, err error) {
 	var val string
 	key, value := args[0], args[1]
 	err = db.GetSet([]byte(key), []byte(value), &val)
@@ -71,12 +50,7 @@ func getSet(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func appendStr(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = newWrongNumOfArgsError("append")
-		return
-	}
-
+func appendStr(db kvStore, args []string) (res interface{}, err error) {
 	key, value := args[0], args[1]
 	if err = db.Append([]byte(key), value); err == nil {
 		res = okResult
@@ -84,11 +58,7 @@ func appendStr(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func strExists(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("strexists")
-		return
-	}
+func strExists(db kvStore, args []string) (res interface{}, err error) {
 	if exists := db.StrExists([]byte(args[0])); exists {
 		res = redcon.SimpleInt(1)
 	} else {
@@ -97,22 +67,14 @@ func strExists(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func remove(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("remove")
-		return
-	}
+func remove(db kvStore, args []string) (res interface{}, err error) {
 	if err = db.Remove([]byte(args[0])); err == nil {
 		res = okResult
 	}
 	return
 }
 
-func prefixScan(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 3 {
-		err = newWrongNumOfArgsError("prefixscan")
-		return
-	}
+func prefixScan(db kvStore, args []string) (res interface{}, err error) {
 	limit, err := strconv.Atoi(args[1])
 	if err != nil {
 		err = ErrSyntaxIncorrect
@@ -128,21 +90,12 @@ func prefixScan(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func rangeScan(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = newWrongNumOfArgsError("rangescan")
-		return
-	}
-
+func rangeScan(db kvStore, args []string) (res interface{}, err error) {
 	res, err = db.RangeScan([]byte(args[0]), []byte(args[1]))
 	return
 }
 
-func expire(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = ErrSyntaxIncorrect
-		return
-	}
+func expire(db kvStore, args []string) (res interface{}, err error) {
 	seconds, err := strconv.Atoi(args[1])
 	if err != nil {
 		err = ErrSyntaxIncorrect
@@ -154,37 +107,29 @@ func expire(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func persist(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("persist")
-		return
-	}
+func persist(db kvStore, args []string) (res interface{}, err error) {
 	db.Persist([]byte(args[0]))
 	res = okResult
 	return
 }
 
-func ttl(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("ttl")
-	}
-
+func ttl(db kvStore, args []string) (res interface{}, err error) {
 	ttlVal := db.TTL([]byte(args[0]))
 	res = strconv.FormatInt(int64(ttlVal), 10)
 	return
 }
 
 func init() {
-	addExecCommand("set", set)
-	addExecCommand("get", get)
-	addExecCommand("setnx", setNx)
-	addExecCommand("getset", getSet)
-	addExecCommand("append", appendStr)
-	addExecCommand("strexists", strExists)
-	addExecCommand("remove", remove)
-	addExecCommand("prefixscan", prefixScan)
-	addExecCommand("rangescan", rangeScan)
-	addExecCommand("expire", expire)
-	addExecCommand("persist", persist)
-	addExecCommand("ttl", ttl)
+	addKVCommand("set", 2, 2, set)
+	addKVCommand("get", 1, 1, get)
+	addKVCommand("setnx", 2, 2, setNx)
+	addKVCommand("getset", 2, 2, getSet)
+	addKVCommand("append", 2, 2, appendStr)
+	addKVCommand("strexists", 1, 1, strExists)
+	addKVCommand("remove", 1, 1, remove)
+	addKVCommand("prefixscan", 3, 3, prefixScan)
+	addKVCommand("rangescan", 2, 2, rangeScan)
+	addKVCommand("expire", 2, 2, expire)
+	addKVCommand("persist", 1, 1, persist)
+	addKVCommand("ttl", 1, 1, ttl)
 }
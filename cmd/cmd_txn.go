@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"zeroDB/db"
+	"zeroDB/global/dberror"
+
+	"github.com/tidwall/redcon"
+)
+
+var (
+	// ErrMultiNested MULTI in a transaction
+	ErrMultiNested = errors.New("ERR MULTI calls can not be nested")
+	// ErrExecWithoutMulti EXEC without MULTI
+	ErrExecWithoutMulti = errors.New("ERR EXEC without MULTI")
+	// ErrDiscardWithoutMulti DISCARD without MULTI
+	ErrDiscardWithoutMulti = errors.New("ERR DISCARD without MULTI")
+	// ErrExecAbort some queued commands were wrong, the transaction is discarded
+	ErrExecAbort = errors.New("EXECABORT Transaction discarded because of previous errors.")
+)
+
+var queuedResult = redcon.SimpleString("QUEUED")
+
+// EXEC 因为 WATCH 的 key 被修改而没有执行时的回复
+type nullArray struct{}
+
+func (nullArray) MarshalRESP() []byte {
+	return redcon.AppendArray(nil, -1)
+}
+
+type (
+	// Session 一个连接上的状态，只在该连接的 goroutine 中访问
+	Session struct {
+		// WATCH 时创建，记录监视的 key 的版本
+		watcher *db.Watcher
+
+		// MULTI 之后入队的命令，EXEC 时在一个事务中执行
+		multi  bool
+		queued []queuedCmd
+		// 有命令入队失败，EXEC 时放弃事务
+		dirty bool
+		// EXEC 时每个命令的结果
+		replies []interface{}
+	}
+
+	queuedCmd struct {
+		name string
+		args []string
+	}
+)
+
+// 取消监视并清空入队的命令，UNWATCH、EXEC、DISCARD 和连接关闭时调用
+func (s *Session) reset() {
+	if s.watcher != nil {
+		s.watcher.Unwatch()
+		s.watcher = nil
+	}
+	s.multi, s.queued, s.dirty, s.replies = false, nil, false, nil
+}
+
+// MULTI 之后把命令加入队列，不支持的命令和参数个数错误的命令使 EXEC 放弃事务
+func (s *Session) queue(cmd string, args []string) (err error) {
+	defer func() {
+		if err != nil {
+			s.dirty = true
+		}
+	}()
+
+	if _, ok := TxnCmd[cmd]; !ok {
+		_, exist := ExecCmd[cmd]
+		_, sessExist := SessionCmd[cmd]
+		if exist || sessExist {
+			return fmt.Errorf("ERR command '%s' can not be used in a transaction", cmd)
+		}
+		return fmt.Errorf("ERR unknown command '%s'", cmd)
+	}
+	if err = checkArity(cmd, args); err != nil {
+		return
+	}
+	s.queued = append(s.queued, queuedCmd{name: cmd, args: args})
+	return
+}
+
+// 在 tx 中依次执行入队的命令，结果保存在 replies 中，命令执行出错时错误作为它的结果，不影响其他命令
+// DB.Txn 重试时重新执行所有命令
+func (s *Session) run(tx *db.Txn) error {
+	s.replies = make([]interface{}, 0, len(s.queued))
+	for _, c := range s.queued {
+		reply, err := TxnCmd[c.name](tx, c.args)
+		if err != nil {
+			reply = err
+		}
+		s.replies = append(s.replies, reply)
+	}
+	return nil
+}
+
+// 和 run 相同，同时提交时检查监视的 key 在 WATCH 之后是否被修改过
+func (s *Session) runWatched(tx *db.Txn) error {
+	if err := tx.AddWatcher(s.watcher); err != nil {
+		return err
+	}
+	return s.run(tx)
+}
+
+// WATCH key [key...] 监视 keys，连接上的事务提交时这些 key 被修改过则提交失败，直到 UNWATCH 或者连接关闭
+func watch(db *db.DB, sess *Session, args []string) (res interface{}, err error) {
+	if len(args) == 0 {
+		err = newWrongNumOfArgsError("watch")
+		return
+	}
+
+	if sess.watcher == nil {
+		sess.watcher = db.NewWatcher()
+	}
+	keys := make([]interface{}, 0, len(args))
+	for _, key := range args {
+		keys = append(keys, []byte(key))
+	}
+	if err = sess.watcher.Watch(keys...); err == nil {
+		res = okResult
+	}
+	return
+}
+
+// UNWATCH 取消监视所有的 key
+func unwatch(db *db.DB, sess *Session, args []string) (res interface{}, err error) {
+	if len(args) != 0 {
+		err = newWrongNumOfArgsError("unwatch")
+		return
+	}
+
+	sess.reset()
+	res = okResult
+	return
+}
+
+// MULTI 开始一个事务，之后的命令入队，直到 EXEC 或者 DISCARD
+func multi(db *db.DB, sess *Session, args []string) (res interface{}, err error) {
+	if len(args) != 0 {
+		err = newWrongNumOfArgsError("multi")
+		return
+	}
+	if sess.multi {
+		err = ErrMultiNested
+		return
+	}
+
+	sess.multi = true
+	res = okResult
+	return
+}
+
+// EXEC 在一个事务中执行入队的命令，返回每个命令的结果
+// 有 WATCH 时监视的 key 被修改过则不提交并返回 nil；否则冲突时按照 TxnMaxRetries 重试
+func execTxn(db *db.DB, sess *Session, args []string) (res interface{}, err error) {
+	if len(args) != 0 {
+		err = newWrongNumOfArgsError("exec")
+		return
+	}
+	if !sess.multi {
+		err = ErrExecWithoutMulti
+		return
+	}
+	defer sess.reset()
+	if sess.dirty {
+		err = ErrExecAbort
+		return
+	}
+
+	if sess.watcher == nil {
+		err = db.Txn(sess.run)
+	} else {
+		err = db.Txn(sess.runWatched)
+		if err == dberror.ErrTxnConflict {
+			return nullArray{}, nil
+		}
+	}
+	if err == nil {
+		res = sess.replies
+	}
+	return
+}
+
+// DISCARD 放弃事务中入队的命令，同时取消监视所有的 key
+func discard(db *db.DB, sess *Session, args []string) (res interface{}, err error) {
+	if len(args) != 0 {
+		err = newWrongNumOfArgsError("discard")
+		return
+	}
+	if !sess.multi {
+		err = ErrDiscardWithoutMulti
+		return
+	}
+
+	sess.reset()
+	res = okResult
+	return
+}
+
+func init() {
+	addSessionCommand("watch", watch)
+	addSessionCommand("unwatch", unwatch)
+	addSessionCommand("multi", multi)
+	addSessionCommand("exec", execTxn)
+	addSessionCommand("discard", discard)
+}
@@ -4,17 +4,12 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"zeroDB/db"
 	"zeroDB/global/utils"
 
 	"github.com/tidwall/redcon"
 )
 
-func zAdd(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 3 {
-		err = newWrongNumOfArgsError("zadd")
-		return
-	}
+func zAdd(db kvStore, args []string) (res interface{}, err error) {
 	score, err := utils.StrToFloat64(args[1])
 	if err != nil {
 		err = ErrSyntaxIncorrect
@@ -26,11 +21,7 @@ func zAdd(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func zScore(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = newWrongNumOfArgsError("zscore")
-		return
-	}
+func zScore(db kvStore, args []string) (res interface{}, err error) {
 	ok, score := db.ZScore([]byte(args[0]), []byte(args[1]))
 	if ok {
 		res = utils.Float64ToStr(score)
@@ -38,41 +29,25 @@ func zScore(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func zCard(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 1 {
-		err = newWrongNumOfArgsError("zcard")
-		return
-	}
+func zCard(db kvStore, args []string) (res interface{}, err error) {
 	card := db.ZCard([]byte(args[0]))
 	res = redcon.SimpleInt(card)
 	return
 }
 
-func zRank(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = ErrSyntaxIncorrect
-		return
-	}
+func zRank(db kvStore, args []string) (res interface{}, err error) {
 	rank := db.ZRank([]byte(args[0]), []byte(args[1]))
 	res = redcon.SimpleInt(rank)
 	return
 }
 
-func zRevRank(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = newWrongNumOfArgsError("zrevrank")
-		return
-	}
+func zRevRank(db kvStore, args []string) (res interface{}, err error) {
 	rank := db.ZRevRank([]byte(args[0]), []byte(args[1]))
 	res = redcon.SimpleInt(rank)
 	return
 }
 
-func zIncrBy(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 3 {
-		err = newWrongNumOfArgsError("zincrby")
-		return
-	}
+func zIncrBy(db kvStore, args []string) (res interface{}, err error) {
 	incr, err := utils.StrToFloat64(args[1])
 	if err != nil {
 		err = ErrSyntaxIncorrect
@@ -85,24 +60,16 @@ func zIncrBy(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func zRange(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 3 && len(args) != 4 {
-		err = newWrongNumOfArgsError("zrange")
-		return
-	}
+func zRange(db kvStore, args []string) (res interface{}, err error) {
 	return zRawRange(db, args, false)
 }
 
-func zRevRange(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 3 && len(args) != 4 {
-		err = newWrongNumOfArgsError("zrevrange")
-		return
-	}
+func zRevRange(db kvStore, args []string) (res interface{}, err error) {
 	return zRawRange(db, args, true)
 }
 
 // for zRange and zRevRange
-func zRawRange(db *db.DB, args []string, rev bool) (res interface{}, err error) {
+func zRawRange(db kvStore, args []string, rev bool) (res interface{}, err error) {
 	withScores := false
 	if len(args) == 4 {
 		if strings.ToLower(args[3]) == "withscores" {
@@ -139,19 +106,11 @@ func zRawRange(db *db.DB, args []string, rev bool) (res interface{}, err error)
 		}
 	}
 
-	results := make([]string, len(val))
-	for i, v := range val {
-		results[i] = fmt.Sprintf("%v", v)
-	}
-	res = results
+	res = zResults(val)
 	return
 }
 
-func zRem(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = ErrSyntaxIncorrect
-		return
-	}
+func zRem(db kvStore, args []string) (res interface{}, err error) {
 	var ok bool
 	if ok, err = db.ZRem([]byte(args[0]), []byte(args[1])); err == nil {
 		if ok {
@@ -163,24 +122,16 @@ func zRem(db *db.DB, args []string) (res interface{}, err error) {
 	return
 }
 
-func zGetByRank(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = newWrongNumOfArgsError("zgetbyrank")
-		return
-	}
+func zGetByRank(db kvStore, args []string) (res interface{}, err error) {
 	return zRawGetByRank(db, args, false)
 }
 
-func zRevGetByRank(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 2 {
-		err = newWrongNumOfArgsError("zrevgetbyrank")
-		return
-	}
+func zRevGetByRank(db kvStore, args []string) (res interface{}, err error) {
 	return zRawGetByRank(db, args, true)
 }
 
 // for zGetByRank and zRevGetByRank
-func zRawGetByRank(db *db.DB, args []string, rev bool) (res interface{}, err error) {
+func zRawGetByRank(db kvStore, args []string, rev bool) (res interface{}, err error) {
 	rank, err := strconv.Atoi(args[1])
 	if err != nil {
 		err = ErrSyntaxIncorrect
@@ -193,32 +144,20 @@ func zRawGetByRank(db *db.DB, args []string, rev bool) (res interface{}, err err
 	} else {
 		val = db.ZGetByRank([]byte(args[0]), rank)
 	}
-	results := make([]string, len(val))
-	for i, v := range val {
-		results[i] = fmt.Sprintf("%v", v)
-	}
-	res = results
+	res = zResults(val)
 	return
 }
 
-func zScoreRange(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 3 {
-		err = newWrongNumOfArgsError("zscorerange")
-		return
-	}
+func zScoreRange(db kvStore, args []string) (res interface{}, err error) {
 	return zRawScoreRange(db, args, false)
 }
 
-func zSRevScoreRange(db *db.DB, args []string) (res interface{}, err error) {
-	if len(args) != 3 {
-		err = newWrongNumOfArgsError("zsrevscorerange")
-		return
-	}
+func zSRevScoreRange(db kvStore, args []string) (res interface{}, err error) {
 	return zRawScoreRange(db, args, true)
 }
 
 // for zScoreRange and zSRevScoreRange
-func zRawScoreRange(db *db.DB, args []string, rev bool) (res interface{}, err error) {
+func zRawScoreRange(db kvStore, args []string, rev bool) (res interface{}, err error) {
 	param1, err := utils.StrToFloat64(args[1])
 	if err != nil {
 		err = ErrSyntaxIncorrect
@@ -235,26 +174,31 @@ func zRawScoreRange(db *db.DB, args []string, rev bool) (res interface{}, err er
 	} else {
 		val = db.ZScoreRange([]byte(args[0]), param1, param2)
 	}
+	res = zResults(val)
+	return
+}
+
+// zset 命令的结果转换为字符串
+func zResults(val []interface{}) []string {
 	results := make([]string, len(val))
 	for i, v := range val {
 		results[i] = fmt.Sprintf("%v", v)
 	}
-	res = results
-	return
+	return results
 }
 
 func init() {
-	addExecCommand("zadd", zAdd)
-	addExecCommand("zscore", zScore)
-	addExecCommand("zcard", zCard)
-	addExecCommand("zrank", zRank)
-	addExecCommand("zrevrank", zRevRank)
-	addExecCommand("zincrby", zIncrBy)
-	addExecCommand("zrange", zRange)
-	addExecCommand("zrevrange", zRevRange)
-	addExecCommand("zrem", zRem)
-	addExecCommand("zgetbyrank", zGetByRank)
-	addExecCommand("zrevgetbyrank", zRevGetByRank)
-	addExecCommand("zscorerange", zScoreRange)
-	addExecCommand("zrevscorerange", zSRevScoreRange)
+	addKVCommand("zadd", 3, 3, zAdd)
+	addKVCommand("zscore", 2, 2, zScore)
+	addKVCommand("zcard", 1, 1, zCard)
+	addKVCommand("zrank", 2, 2, zRank)
+	addKVCommand("zrevrank", 2, 2, zRevRank)
+	addKVCommand("zincrby", 3, 3, zIncrBy)
+	addKVCommand("zrange", 3, 4, zRange)
+	addKVCommand("zrevrange", 3, 4, zRevRange)
+	addKVCommand("zrem", 2, 2, zRem)
+	addKVCommand("zgetbyrank", 2, 2, zGetByRank)
+	addKVCommand("zrevgetbyrank", 2, 2, zRevGetByRank)
+	addKVCommand("zscorerange", 3, 3, zScoreRange)
+	addKVCommand("zrevscorerange", 3, 3, zSRevScoreRange)
 }
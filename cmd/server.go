@@ -21,6 +21,57 @@ func addExecCommand(cmd string, cmdFunc ExecCmdFunc) {
 	ExecCmd[strings.ToLower(cmd)] = cmdFunc
 }
 
+// SessionCmdFunc func for cmd which reads or changes the state of the connection.
+type SessionCmdFunc func(*db.DB, *Session, []string) (interface{}, error)
+
+// SessionCmd saving the commands which need the state of the connection, such as WATCH.
+var SessionCmd = make(map[string]SessionCmdFunc)
+
+func addSessionCommand(cmd string, cmdFunc SessionCmdFunc) {
+	SessionCmd[strings.ToLower(cmd)] = cmdFunc
+}
+
+// TxnCmdFunc func for cmd executed in the transaction of EXEC.
+type TxnCmdFunc func(*db.Txn, []string) (interface{}, error)
+
+// TxnCmd saving the commands which can be queued after MULTI.
+var TxnCmd = make(map[string]TxnCmdFunc)
+
+// 数据命令的函数，直接执行和在 EXEC 的事务中执行时使用同一个函数，参数个数在调用之前已经检查
+type kvCmdFunc func(kvStore, []string) (interface{}, error)
+
+// 命令的参数个数范围，max 小于 0 时没有上限
+type arity struct {
+	min, max int
+}
+
+// 数据命令的参数个数，直接执行和 MULTI 之后入队时检查
+var cmdArity = make(map[string]arity)
+
+// 注册一个数据命令，同时加入 ExecCmd 和 TxnCmd，参数个数在 [minArgs, maxArgs] 之间，maxArgs 小于 0 时没有上限
+func addKVCommand(cmd string, minArgs, maxArgs int, cmdFunc kvCmdFunc) {
+	cmd = strings.ToLower(cmd)
+	cmdArity[cmd] = arity{min: minArgs, max: maxArgs}
+	ExecCmd[cmd] = func(db *db.DB, args []string) (interface{}, error) {
+		if err := checkArity(cmd, args); err != nil {
+			return nil, err
+		}
+		return cmdFunc(db, args)
+	}
+	TxnCmd[cmd] = func(tx *db.Txn, args []string) (interface{}, error) {
+		return cmdFunc(txnStore{tx}, args)
+	}
+}
+
+// 检查数据命令的参数个数
+func checkArity(cmd string, args []string) error {
+	a := cmdArity[cmd]
+	if len(args) < a.min || (a.max >= 0 && len(args) > a.max) {
+		return newWrongNumOfArgsError(cmd)
+	}
+	return nil
+}
+
 // Server a zerokv server.
 type Server struct {
 	server *redcon.Server
@@ -45,9 +96,13 @@ func (s *Server) Listen(addr string) {
 			s.handleCmd(conn, cmd)
 		},
 		func(conn redcon.Conn) bool {
+			conn.SetContext(new(Session))
 			return true
 		},
 		func(conn redcon.Conn, err error) {
+			if sess, ok := conn.Context().(*Session); ok {
+				sess.reset()
+			}
 		},
 	)
 
@@ -82,11 +137,7 @@ func (s *Server) handleCmd(conn redcon.Conn, cmd redcon.Command) {
 	}()
 
 	command := strings.ToLower(string(cmd.Args[0]))
-	exec, exist := ExecCmd[command]
-	if !exist {
-		conn.WriteError(fmt.Sprintf("ERR unknown command '%s'", command))
-		return
-	}
+	sess := conn.Context().(*Session)
 	args := make([]string, 0, len(cmd.Args)-1)
 	for i, bytes := range cmd.Args {
 		if i == 0 {
@@ -94,10 +145,60 @@ func (s *Server) handleCmd(conn redcon.Conn, cmd redcon.Command) {
 		}
 		args = append(args, string(bytes))
 	}
-	reply, err := exec(s.db, args)
+
+	// MULTI 之后除了 EXEC、DISCARD 和 MULTI，命令都进入队列
+	if sess.multi && command != "exec" && command != "discard" && command != "multi" {
+		if err := sess.queue(command, args); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteAny(queuedResult)
+		return
+	}
+
+	exec, exist := ExecCmd[command]
+	sessExec, sessExist := SessionCmd[command]
+	if !exist && !sessExist {
+		conn.WriteError(fmt.Sprintf("ERR unknown command '%s'", command))
+		return
+	}
+
+	var (
+		reply interface{}
+		err   error
+	)
+	if sessExist {
+		reply, err = sessExec(s.db, sess, args)
+	} else {
+		reply, err = exec(s.db, args)
+	}
 	if err != nil {
 		conn.WriteError(err.Error())
 		return
 	}
 	conn.WriteAny(reply)
 }
+
+// BACKUP <dir> 在线备份 db 到服务端的 dir 目录，dir 必须不存在或者是空目录，备份进度输出到日志
+func backup(db *db.DB, args []string) (res interface{}, err error) {
+	if len(args) != 1 {
+		err = newWrongNumOfArgsError("backup")
+		return
+	}
+
+	dir := args[0]
+	if err = db.BackupWithProgress(dir, logBackupProgress(dir)); err == nil {
+		res = okResult
+	}
+	return
+}
+
+func logBackupProgress(dir string) func(db.BackupProgress) {
+	return func(p db.BackupProgress) {
+		log.Printf("backup to %s: %d/%d files, %d bytes copied, %s", dir, p.Done, p.Total, p.Bytes, p.File)
+	}
+}
+
+func init() {
+	addExecCommand("backup", backup)
+}
@@ -0,0 +1,327 @@
+package cmd
+
+import (
+	"zeroDB/datastructure/list"
+	"zeroDB/db"
+)
+
+// 数据命令读写使用的接口，直接执行时是 *db.DB，EXEC 时是 txnStore，两种情况使用同一组命令函数
+type kvStore interface {
+	Set(key, value interface{}) error
+	Get(key, dest interface{}) error
+	SetNx(key, value interface{}) (bool, error)
+	GetSet(key, value, dest interface{}) error
+	Append(key interface{}, value string) error
+	StrExists(key interface{}) bool
+	Remove(key interface{}) error
+	PrefixScan(prefix string, limit, offset int) ([]interface{}, error)
+	RangeScan(start, end interface{}) ([]interface{}, error)
+	Expire(key interface{}, duration int64) error
+	Persist(key interface{}) error
+	TTL(key interface{}) int64
+
+	HSet(key, field, value []byte) (int, error)
+	HSetNx(key, field, value []byte) (int, error)
+	HGet(key, field []byte) []byte
+	HGetAll(key []byte) [][]byte
+	HDel(key []byte, fields ...[]byte) (int, error)
+	HExists(key, field []byte) bool
+	HLen(key []byte) int
+	HKeys(key []byte) []string
+	HVals(key []byte) [][]byte
+
+	LPush(key []byte, values ...[]byte) (int, error)
+	RPush(key []byte, values ...[]byte) (int, error)
+	LPop(key []byte) ([]byte, error)
+	RPop(key []byte) ([]byte, error)
+	LIndex(key []byte, idx int) []byte
+	LRem(key, value []byte, count int) (int, error)
+	LInsert(key string, option list.InsertOption, pivot, val []byte) (int, error)
+	LSet(key []byte, idx int, val []byte) (bool, error)
+	LTrim(key []byte, start, end int) error
+	LRange(key []byte, start, end int) ([][]byte, error)
+	LLen(key []byte) int
+	LKeyExists(key []byte) bool
+	LValExists(key []byte, val []byte) bool
+
+	SAdd(key []byte, members ...[]byte) (int, error)
+	SPop(key []byte, count int) ([][]byte, error)
+	SIsMember(key, member []byte) bool
+	SRandMember(key []byte, count int) [][]byte
+	SRem(key []byte, members ...[]byte) (int, error)
+	SMove(src, dst, member []byte) error
+	SCard(key []byte) int
+	SMembers(key []byte) [][]byte
+	SUnion(keys ...[]byte) [][]byte
+	SDiff(keys ...[]byte) [][]byte
+
+	ZAdd(key []byte, score float64, member []byte) error
+	ZScore(key, member []byte) (bool, float64)
+	ZCard(key []byte) int
+	ZRank(key, member []byte) int64
+	ZRevRank(key, member []byte) int64
+	ZIncrBy(key []byte, increment float64, member []byte) (float64, error)
+	ZRange(key []byte, start, stop int) []interface{}
+	ZRangeWithScores(key []byte, start, stop int) []interface{}
+	ZRevRange(key []byte, start, stop int) []interface{}
+	ZRevRangeWithScores(key []byte, start, stop int) []interface{}
+	ZRem(key, member []byte) (bool, error)
+	ZGetByRank(key []byte, rank int) []interface{}
+	ZRevGetByRank(key []byte, rank int) []interface{}
+	ZScoreRange(key []byte, min, max float64) []interface{}
+	ZRevScoreRange(key []byte, max, min float64) []interface{}
+}
+
+var (
+	_ kvStore = (*db.DB)(nil)
+	_ kvStore = txnStore{}
+)
+
+// txnStore 把 *db.Txn 的方法转换为 *db.DB 的形式，返回值和直接执行时相同
+// string 类型的方法两者相同，直接使用 *db.Txn 的
+type txnStore struct {
+	*db.Txn
+}
+
+func (s txnStore) HSet(key, field, value []byte) (res int, err error) {
+	if !s.Txn.HExists(key, field) {
+		res = 1
+	}
+	err = s.Txn.HSet(key, field, value)
+	return
+}
+
+func (s txnStore) HSetNx(key, field, value []byte) (res int, err error) {
+	if s.Txn.HExists(key, field) {
+		return
+	}
+	if err = s.Txn.HSetNx(key, field, value); err == nil {
+		res = 1
+	}
+	return
+}
+
+func (s txnStore) HGet(key, field []byte) (val []byte) {
+	_ = s.Txn.HGet(key, field, &val)
+	return
+}
+
+func (s txnStore) HGetAll(key []byte) [][]byte {
+	return s.Txn.HGetAll(key)
+}
+
+func (s txnStore) HDel(key []byte, fields ...[]byte) (res int, err error) {
+	deleted := make(map[string]struct{})
+	for _, f := range fields {
+		if _, ok := deleted[string(f)]; !ok && s.Txn.HExists(key, f) {
+			deleted[string(f)] = struct{}{}
+			res++
+		}
+	}
+	err = s.Txn.HDel(key, toInterfaces(fields)...)
+	return
+}
+
+func (s txnStore) HExists(key, field []byte) bool {
+	return s.Txn.HExists(key, field)
+}
+
+func (s txnStore) HLen(key []byte) int {
+	return s.Txn.HLen(key)
+}
+
+func (s txnStore) HKeys(key []byte) []string {
+	return s.Txn.HKeys(key)
+}
+
+func (s txnStore) HVals(key []byte) [][]byte {
+	return s.Txn.HVals(key)
+}
+
+func (s txnStore) LPush(key []byte, values ...[]byte) (res int, err error) {
+	if err = s.Txn.LPush(key, toInterfaces(values)...); err == nil {
+		res = s.Txn.LLen(key)
+	}
+	return
+}
+
+func (s txnStore) RPush(key []byte, values ...[]byte) (res int, err error) {
+	if err = s.Txn.RPush(key, toInterfaces(values)...); err == nil {
+		res = s.Txn.LLen(key)
+	}
+	return
+}
+
+func (s txnStore) LPop(key []byte) ([]byte, error) {
+	return s.Txn.LPop(key)
+}
+
+func (s txnStore) RPop(key []byte) ([]byte, error) {
+	return s.Txn.RPop(key)
+}
+
+func (s txnStore) LIndex(key []byte, idx int) []byte {
+	return s.Txn.LIndex(key, idx)
+}
+
+func (s txnStore) LRem(key, value []byte, count int) (int, error) {
+	return s.Txn.LRem(key, value, count)
+}
+
+func (s txnStore) LInsert(key string, option list.InsertOption, pivot, val []byte) (int, error) {
+	return s.Txn.LInsert([]byte(key), option, pivot, val)
+}
+
+func (s txnStore) LSet(key []byte, idx int, val []byte) (bool, error) {
+	return s.Txn.LSet(key, idx, val)
+}
+
+func (s txnStore) LTrim(key []byte, start, end int) error {
+	return s.Txn.LTrim(key, start, end)
+}
+
+func (s txnStore) LRange(key []byte, start, end int) ([][]byte, error) {
+	return s.Txn.LRange(key, start, end)
+}
+
+func (s txnStore) LLen(key []byte) int {
+	return s.Txn.LLen(key)
+}
+
+func (s txnStore) LKeyExists(key []byte) bool {
+	return s.Txn.LKeyExists(key)
+}
+
+func (s txnStore) LValExists(key []byte, val []byte) bool {
+	return s.Txn.LValExists(key, val)
+}
+
+func (s txnStore) SAdd(key []byte, members ...[]byte) (res int, err error) {
+	added := make(map[string]struct{})
+	for _, m := range members {
+		if _, ok := added[string(m)]; !ok && !s.Txn.SIsMember(key, m) {
+			added[string(m)] = struct{}{}
+			res++
+		}
+	}
+	err = s.Txn.SAdd(key, toInterfaces(members)...)
+	return
+}
+
+func (s txnStore) SPop(key []byte, count int) ([][]byte, error) {
+	return s.Txn.SPop(key, count)
+}
+
+func (s txnStore) SIsMember(key, member []byte) bool {
+	return s.Txn.SIsMember(key, member)
+}
+
+func (s txnStore) SRandMember(key []byte, count int) [][]byte {
+	return s.Txn.SRandMember(key, count)
+}
+
+func (s txnStore) SRem(key []byte, members ...[]byte) (res int, err error) {
+	removed := make(map[string]struct{})
+	for _, m := range members {
+		if _, ok := removed[string(m)]; !ok && s.Txn.SIsMember(key, m) {
+			removed[string(m)] = struct{}{}
+			res++
+		}
+	}
+	err = s.Txn.SRem(key, toInterfaces(members)...)
+	return
+}
+
+func (s txnStore) SMove(src, dst, member []byte) error {
+	return s.Txn.SMove(src, dst, member)
+}
+
+func (s txnStore) SCard(key []byte) int {
+	return s.Txn.SCard(key)
+}
+
+func (s txnStore) SMembers(key []byte) [][]byte {
+	return s.Txn.SMembers(key)
+}
+
+func (s txnStore) SUnion(keys ...[]byte) [][]byte {
+	return s.Txn.SUnion(toInterfaces(keys)...)
+}
+
+func (s txnStore) SDiff(keys ...[]byte) [][]byte {
+	return s.Txn.SDiff(toInterfaces(keys)...)
+}
+
+func (s txnStore) ZAdd(key []byte, score float64, member []byte) error {
+	return s.Txn.ZAdd(key, score, member)
+}
+
+// key 过期时和 *db.DB 相同，返回不存在
+func (s txnStore) ZScore(key, member []byte) (ok bool, score float64) {
+	ok, score, _ = s.Txn.ZScore(key, member)
+	return
+}
+
+func (s txnStore) ZCard(key []byte) int {
+	return s.Txn.ZCard(key)
+}
+
+func (s txnStore) ZRank(key, member []byte) int64 {
+	return s.Txn.ZRank(key, member)
+}
+
+func (s txnStore) ZRevRank(key, member []byte) int64 {
+	return s.Txn.ZRevRank(key, member)
+}
+
+func (s txnStore) ZIncrBy(key []byte, increment float64, member []byte) (float64, error) {
+	return s.Txn.ZIncrBy(key, increment, member)
+}
+
+func (s txnStore) ZRange(key []byte, start, stop int) []interface{} {
+	return s.Txn.ZRange(key, start, stop)
+}
+
+func (s txnStore) ZRangeWithScores(key []byte, start, stop int) []interface{} {
+	return s.Txn.ZRangeWithScores(key, start, stop)
+}
+
+func (s txnStore) ZRevRange(key []byte, start, stop int) []interface{} {
+	return s.Txn.ZRevRange(key, start, stop)
+}
+
+func (s txnStore) ZRevRangeWithScores(key []byte, start, stop int) []interface{} {
+	return s.Txn.ZRevRangeWithScores(key, start, stop)
+}
+
+func (s txnStore) ZRem(key, member []byte) (ok bool, err error) {
+	if ok, _ = s.ZScore(key, member); !ok {
+		return
+	}
+	err = s.Txn.ZRem(key, member)
+	return
+}
+
+func (s txnStore) ZGetByRank(key []byte, rank int) []interface{} {
+	return s.Txn.ZGetByRank(key, rank)
+}
+
+func (s txnStore) ZRevGetByRank(key []byte, rank int) []interface{} {
+	return s.Txn.ZRevGetByRank(key, rank)
+}
+
+func (s txnStore) ZScoreRange(key []byte, min, max float64) []interface{} {
+	return s.Txn.ZScoreRange(key, min, max)
+}
+
+func (s txnStore) ZRevScoreRange(key []byte, max, min float64) []interface{} {
+	return s.Txn.ZRevScoreRange(key, max, min)
+}
+
+func toInterfaces(values [][]byte) []interface{} {
+	res := make([]interface{}, len(values))
+	for i, v := range values {
+		res[i] = v
+	}
+	return res
+}
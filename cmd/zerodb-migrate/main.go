@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"zeroDB/db"
+	"zeroDB/global/config"
+	"zeroDB/storage"
+)
+
+// zerodb-migrate 将旧版本的数据目录重写为当前版本的格式
+// 迁移前需要先停止使用该目录的 zerokv server，目录被其他实例打开时迁移会失败
+var (
+	configPath = flag.String("config", "", "the config file of zerokv, compression, encryption and blob options are read from it")
+	dirPath    = flag.String("dir", "/tmp/zerokv_server", "the data directory of zerokv to migrate, overrides dir_path in the config")
+)
+
+func main() {
+	flag.Parse()
+
+	var cfg config.Config
+	if *configPath != "" {
+		cfg = config.InitConfig(*configPath)
+	}
+	cfg.DirPath = *dirPath
+	if cfg.BlockSize == 0 {
+		cfg.BlockSize = 16 << 20
+	}
+	fs, err := storage.NewFileSystem(cfg.FileSystem)
+	if err != nil {
+		log.Fatalf("open file system err: %+v", err)
+	}
+	cfg.FS = fs
+
+	// 目录正在被使用时不能迁移
+	dirLock, err := storage.LockDir(fs, cfg.DirPath, false)
+	if err != nil {
+		log.Fatalf("lock data directory err: %+v", err)
+	}
+	defer dirLock.Close()
+
+	// 和 db.Open 使用相同的压缩、加密和 blob 文件
+	opts, err := db.FileOptions(cfg)
+	if err != nil {
+		log.Fatalf("load file options err: %+v", err)
+	}
+	defer opts.Blobs.Close(true)
+
+	// 只迁移 manifest 中的文件，不在 manifest 中的是崩溃时留下的，打开 db 时会被删除
+	// 旧版本的目录没有 manifest，和 db.Open 相同，按照 file id 的顺序使用目录中所有的 dbfile
+	fileIdsMap := make(map[uint16][]uint32)
+	activeIds := make(map[uint16]uint32)
+	manifest, err := storage.LoadManifest(cfg.DirPath, opts)
+	switch {
+	case err == nil:
+		for dataType := uint16(0); dataType < uint16(len(storage.DBFileSuffixName)); dataType++ {
+			fileIdsMap[dataType] = manifest.FileIds(dataType)
+			activeIds[dataType] = manifest.ActiveFileId(dataType)
+		}
+	case os.IsNotExist(err):
+		ids, err := storage.ListDBFiles(cfg.DirPath, opts)
+		if err != nil {
+			log.Fatalf("read data directory err: %+v", err)
+		}
+		// 最后一个是活跃文件，不需要 hint 文件
+		for dataType, fileIds := range ids {
+			for _, id := range fileIds {
+				fileIdsMap[dataType] = append(fileIdsMap[dataType], uint32(id))
+			}
+			activeIds[dataType] = uint32(fileIds[len(fileIds)-1])
+		}
+	default:
+		log.Fatalf("load manifest err: %+v", err)
+	}
+
+	var migrated int
+	for dataType, fileIds := range fileIdsMap {
+		for _, id := range fileIds {
+			result, err := storage.MigrateFile(cfg.DirPath, id, dataType, opts)
+			if err != nil {
+				log.Fatalf("migrate db file err: %+v", err)
+			}
+			if result == nil {
+				continue
+			}
+			migrated++
+			log.Printf("migrated %s, %d entries", result.Path, result.Entries)
+			if result.Discarded > 0 {
+				log.Printf("discarded %d bytes of incomplete entry at the end of %s", result.Discarded, result.Path)
+			}
+
+			if id == activeIds[dataType] {
+				continue
+			}
+			df, err := storage.NewDBFile(cfg.DirPath, id, dataType, opts)
+			if err != nil {
+				log.Fatalf("open migrated db file err: %+v", err)
+			}
+			if err = storage.WriteHintFile(df); err != nil {
+				log.Printf("write hint file of %s err: %+v", result.Path, err)
+			}
+			df.Close(false)
+		}
+	}
+	log.Printf("migration finished, %d db files migrated.", migrated)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"zeroDB/db"
+	"zeroDB/global/config"
+)
+
+// zerodb-restore 将数据目录（或者 Backup 生成的备份）恢复到某个时间点，结果写入新的目录
+// 源目录以只读方式打开，正在被 server 使用的目录不能直接恢复，需要先停止 server 或者使用备份
+var (
+	configPath = flag.String("config", "", "the config file of zerokv, compression and encryption options are read from it")
+	dirPath    = flag.String("dir", "/tmp/zerokv_server", "the data directory or backup to restore from, overrides dir_path in the config")
+	outPath    = flag.String("out", "", "the new directory to write the restored data to, must be empty or not exist")
+	until      = flag.String("time", "", "restore to this time, in RFC3339 format, e.g. 2006-01-02T15:04:05+08:00")
+)
+
+func main() {
+	flag.Parse()
+	if *outPath == "" || *until == "" {
+		log.Fatalf("-out and -time are required")
+	}
+	t, err := time.Parse(time.RFC3339Nano, *until)
+	if err != nil {
+		log.Fatalf("parse restore time err: %+v", err)
+	}
+
+	var cfg config.Config
+	if *configPath != "" {
+		cfg = config.InitConfig(*configPath)
+	}
+	cfg.DirPath = *dirPath
+	// 恢复得到的目录不需要后台任务，之后按照正常的配置打开
+	cfg.AutoReclaim, cfg.CheckpointInterval = false, 0
+	if cfg.BlockSize == 0 {
+		cfg.BlockSize = 16 << 20
+	}
+
+	result, err := db.RestoreToTime(cfg, *outPath, t)
+	if err != nil {
+		log.Fatalf("restore err: %+v", err)
+	}
+	log.Printf("restored %s to %s at %s, %d entries replayed, %d entries skipped.",
+		*dirPath, *outPath, result.Until.Format(time.RFC3339Nano), result.Replayed, result.Skipped)
+}
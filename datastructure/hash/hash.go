@@ -75,6 +75,14 @@ func (h *Hash) HDel(key string, field string) (res int) {
 	return
 }
 
+// return all the keys of hash.
+func (h *Hash) Keys() (keys []string) {
+	for key := range h.Record {
+		keys = append(keys, key)
+	}
+	return
+}
+
 // check whether the key exists
 func (h *Hash) HKeyExists(key string) bool {
 	return h.exist(key)
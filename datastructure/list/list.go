@@ -222,7 +222,7 @@ func (lis *List) LTrim(key string, start, end int) bool {
 		newList := list.New()
 		newValueMap := make(map[string]int)
 		for p := startEle; p != endEle.Next(); p = p.Next() {
-			newList.PushBack(p)
+			newList.PushBack(p.Value)
 			if p.Value != nil {
 				newValueMap[string(p.Value.([]byte))] += 1
 			}
@@ -273,6 +273,14 @@ func (lis *List) LClear(key string) {
 	delete(lis.Values, key)
 }
 
+// return all the keys of List.
+func (lis *List) Keys() (keys []string) {
+	for key := range lis.Record {
+		keys = append(keys, key)
+	}
+	return
+}
+
 // check if the key exists
 func (lis *List) LKeyExists(key string) (ok bool) {
 	_, ok = lis.Record[key]
@@ -346,7 +354,7 @@ func (lis *List) validIndex(key string, index int) (bool, int) {
 	if index < 0 {
 		index += length
 	}
-	return index < length && index > 0, index
+	return index < length && index >= 0, index
 }
 
 // get element by the index
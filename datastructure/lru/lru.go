@@ -0,0 +1,121 @@
+package lru
+
+import (
+	"container/list"
+	"sync"
+)
+
+type (
+	// Cache 按 value 的总大小限制容量的 LRU 缓存，可以并发使用
+	Cache struct {
+		mu       sync.Mutex
+		capacity int64 // value 总大小的上限
+		size     int64 // 当前 value 的总大小
+		ll       *list.List
+		items    map[interface{}]*list.Element
+
+		hits   uint64
+		misses uint64
+	}
+
+	item struct {
+		key   interface{}
+		value []byte
+	}
+
+	// Stats 缓存的统计信息
+	Stats struct {
+		Hits   uint64
+		Misses uint64
+		Size   int64 // 当前 value 的总大小
+		Count  int   // 当前缓存的数量
+	}
+)
+
+// new a lru cache, capacity 是 value 总大小的上限
+func New(capacity int64) *Cache {
+	return &Cache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[interface{}]*list.Element),
+	}
+}
+
+// 获取 key 对应的 value，并标记为最近使用
+func (c *Cache) Get(key interface{}) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		c.hits++
+		return el.Value.(*item).value, true
+	}
+	c.misses++
+	return nil, false
+}
+
+// 添加或者更新 key 对应的 value，超过容量时淘汰最久没有使用的 value
+// 比容量还大的 value 不会被缓存
+func (c *Cache) Put(key interface{}, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if int64(len(value)) > c.capacity {
+		c.remove(key)
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		it := el.Value.(*item)
+		c.size += int64(len(value)) - int64(len(it.value))
+		it.value = value
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(&item{key: key, value: value})
+		c.size += int64(len(value))
+	}
+
+	for c.size > c.capacity {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// 删除 key
+func (c *Cache) Remove(key interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+}
+
+// 清空缓存，统计信息保留
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[interface{}]*list.Element)
+	c.size = 0
+}
+
+// 返回缓存的统计信息
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Hits:   c.hits,
+		Misses: c.misses,
+		Size:   c.size,
+		Count:  c.ll.Len(),
+	}
+}
+
+func (c *Cache) remove(key interface{}) {
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *Cache) removeElement(el *list.Element) {
+	it := c.ll.Remove(el).(*item)
+	delete(c.items, it.key)
+	c.size -= int64(len(it.value))
+}
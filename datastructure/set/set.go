@@ -108,6 +108,14 @@ func (s *Set) SMove(src, dst string, member []byte) bool {
 	return true
 }
 
+// return all the keys of set.
+func (s *Set) Keys() (keys []string) {
+	for key := range s.Record {
+		keys = append(keys, key)
+	}
+	return
+}
+
 // return the number of members at this key
 func (s *Set) SCard(key string) int {
 	if !s.exist(key) {
@@ -3,8 +3,10 @@ package string
 import "zeroDB/storage"
 
 // StrData 是 string 数据在内存中的 index 的储存结构
+// key-only 模式下 Meta 中只有 key，value 需要根据 FileId 和 Offset 从 dbfile 中读取
 type StrData struct {
 	Meta   *storage.Meta //meta info
 	Offset int64         // 查询的位置
 	FileId uint32        // file id
+	Size   uint32        // entry 在文件中的大小
 }
@@ -315,6 +315,14 @@ func (z *SortedSet) ZKeyExists(key string) bool {
 	return z.exist(key)
 }
 
+// Keys returns all the keys of sorted set.
+func (z *SortedSet) Keys() (keys []string) {
+	for key := range z.record {
+		keys = append(keys, key)
+	}
+	return
+}
+
 // ZClear clear the key in zset.
 func (z *SortedSet) ZClear(key string) {
 	if z.ZKeyExists(key) {
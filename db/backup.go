@@ -0,0 +1,159 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"zeroDB/global/consts"
+	"zeroDB/global/dberror"
+	"zeroDB/storage"
+)
+
+// 有 reclaim 正在进行时，备份等待它完成的检查间隔
+const backupWaitInterval = 100 * time.Millisecond
+
+type (
+	// BackupProgress 备份的进度，每复制完一个文件回调一次
+	BackupProgress struct {
+		File  string // 刚复制完的文件名
+		Done  int    // 已经复制的文件数量
+		Total int    // 需要复制的文件数量
+		Bytes int64  // 已经复制的字节数
+	}
+
+	// 备份中的一个文件
+	backupFile struct {
+		name     string
+		size     int64 // 复制的字节数，小于 0 时复制整个文件
+		link     bool  // 文件不会再被修改，可以硬链接
+		optional bool  // 不存在时跳过，比如 hint 文件和 checkpoint
+	}
+)
+
+// Backup 在线生成 db 在某一时刻的一致的拷贝，见 BackupWithProgress
+func (db *DB) Backup(dstDir string) error {
+	return db.BackupWithProgress(dstDir, nil)
+}
+
+// BackupWithProgress 在线生成 db 在某一时刻的一致的拷贝，dstDir 必须不存在或者是空目录，progress 不为 nil 时每复制完一个文件回调一次
+// 备份期间暂停 reclaim 和 checkpoint，只在记录每个文件的大小时短暂持有所有类型的写锁，活跃文件不会在这期间归档
+// 归档文件直接硬链接（文件系统不支持时复制），活跃文件、blob 文件和 txn file 只复制到记录的位置，最后写入 manifest 并 fsync 目录
+// 备份写到和 db 相同的文件系统中，失败时删除 dstDir 中已经复制的文件
+func (db *DB) BackupWithProgress(dstDir string, progress func(BackupProgress)) (err error) {
+	if db.isClosed() {
+		return dberror.ErrDBIsClosed
+	}
+	fs := db.fileOpts.FS
+	if names, err := fs.ReadDir(dstDir); err == nil && len(names) > 0 {
+		return dberror.ErrBackupDirNotEmpty
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err = fs.MkdirAll(dstDir, os.ModePerm); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			fs.RemoveAll(dstDir)
+		}
+	}()
+
+	// 等待正在进行的 reclaim 完成，备份期间不会有文件被删除或者替换
+	allTypes := []consts.DataType{consts.String, consts.List, consts.Hash, consts.Set, consts.ZSet}
+	for {
+		if err = db.startReclaim(allTypes...); err != dberror.ErrDBisReclaiming {
+			break
+		}
+		time.Sleep(backupWaitInterval)
+	}
+	if err != nil {
+		return
+	}
+	defer db.finishReclaim(allTypes...)
+
+	files, manifest := db.backupFiles(allTypes)
+	var bytes int64
+	for i, f := range files {
+		src := db.config.DirPath + storage.PathSeparator + f.name
+		dst := dstDir + storage.PathSeparator + f.name
+		n, err := storage.BackupFile(fs, src, dst, f.size, f.link)
+		if err != nil && !(f.optional && os.IsNotExist(err)) {
+			return fmt.Errorf("backup %s: %w", f.name, err)
+		}
+		bytes += n
+		if progress != nil {
+			progress(BackupProgress{File: f.name, Done: i + 1, Total: len(files), Bytes: bytes})
+		}
+	}
+
+	// manifest 最后写入，只列出记录时的文件
+	if err = storage.SaveManifest(dstDir, manifest, db.fileOpts); err != nil {
+		return
+	}
+	return storage.SyncDir(fs, dstDir)
+}
+
+// 持有所有类型的写锁，记录备份需要的文件和它们当前的大小，以及备份中的 manifest
+// 写入和事务提交都持有对应类型的写锁，这时所有文件都处于同一个时刻
+func (db *DB) backupFiles(allTypes []consts.DataType) ([]backupFile, map[uint16][]uint32) {
+	unlock := db.lockMgr.Lock(allTypes...)
+	defer unlock()
+
+	var files []backupFile
+	manifest := make(map[uint16][]uint32)
+	for _, dType := range allTypes {
+		fileIds := db.manifest.FileIds(dType)
+		manifest[dType] = fileIds
+		for i, id := range fileIds {
+			name := fmt.Sprintf(storage.DBFileFormatNames[dType], id)
+			if i == len(fileIds)-1 {
+				// 只读打开时活跃文件可能不存在
+				if df, err := db.getActiveFile(dType); err == nil && df.Id == id {
+					files = append(files, backupFile{name: name, size: df.Offset})
+				}
+				continue
+			}
+			df, ok := db.archFiles[dType][id]
+			if !ok {
+				continue
+			}
+			files = append(files,
+				backupFile{name: name, size: df.Offset, link: true},
+				backupFile{name: fmt.Sprintf(storage.HintFileFormatNames[dType], id), size: -1, optional: true},
+			)
+		}
+		// checkpoint 只会在 reclaim 暂停时写入，复制时不会变化
+		if dType != consts.String {
+			for _, name := range storage.CheckpointFiles(dType) {
+				files = append(files, backupFile{name: name, size: -1, optional: true})
+			}
+		}
+	}
+
+	// 只有 id 最大的 blob 文件可能还在写入
+	blobs := db.fileOpts.Blobs.Sizes()
+	blobIds := make([]uint32, 0, len(blobs))
+	for id := range blobs {
+		blobIds = append(blobIds, id)
+	}
+	sort.Slice(blobIds, func(i, j int) bool { return blobIds[i] < blobIds[j] })
+	for i, id := range blobIds {
+		files = append(files, backupFile{
+			name: fmt.Sprintf(storage.DBFileFormatNames[storage.BlobFileType], id),
+			size: blobs[id],
+			link: i < len(blobIds)-1,
+		})
+	}
+
+	// 只读打开没有迁移的旧版本目录时，事务是否提交仍然记录在 DB.TX.META 中
+	if db.txnMeta.legacyTxIds != nil {
+		files = append(files, backupFile{
+			name:     strings.TrimPrefix(consts.DbTxMetaSaveFile, string(os.PathSeparator)),
+			size:     -1,
+			optional: true,
+		})
+	}
+	return files, manifest
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"bytes"
+	"log"
+	str "zeroDB/datastructure/string"
+	"zeroDB/global/consts"
+	"zeroDB/storage"
+)
+
+const (
+	// blob 文件中无效 value 比例的默认值
+	defaultBlobGCRatio = 0.5
+)
+
+// 找出需要回收的 blob 文件：无效的 value 占比超过 BlobGCRatio
+// value 和当前 index 中的值相同就认为有效，只用来估算，blob 文件是否可以删除由引用计数决定
+func (db *DB) blobGCCandidates() (fileIds []uint32) {
+	ratio := db.config.BlobGCRatio
+	if ratio <= 0 {
+		ratio = defaultBlobGCRatio
+	}
+
+	for _, id := range db.fileOpts.Blobs.ArchivedFileIds() {
+		var total, live int64
+		err := db.fileOpts.Blobs.Scan(id, func(e *storage.Entry) {
+			total += int64(e.Size())
+			unlock := db.lockMgr.RLock(e.GetType())
+			if db.liveBlob(e) {
+				live += int64(e.Size())
+			}
+			unlock()
+		})
+		if err != nil {
+			log.Printf("scan blob file %09d err: %+v", id, err)
+			continue
+		}
+		if total == 0 || float64(total-live)/float64(total) >= ratio {
+			fileIds = append(fileIds, id)
+		}
+	}
+	return
+}
+
+// blob 文件中的 value 是否仍然是 key 当前的值，调用者持有该类型的读锁
+func (db *DB) liveBlob(e *storage.Entry) bool {
+	switch e.GetType() {
+	case consts.String:
+		node := db.strIndex.idxList.Get(e.Meta.Key)
+		if node == nil {
+			return false
+		}
+		idx := node.Value().(*str.StrData)
+		if idx == nil {
+			return false
+		}
+		value, err := db.strValue(idx)
+		return err == nil && bytes.Equal(value, e.Meta.Value)
+	case consts.Hash:
+		return bytes.Equal(db.hashIndex.indexes.HGet(string(e.Meta.Key), string(e.Meta.Extra)), e.Meta.Value)
+	}
+	return false
+}
@@ -0,0 +1,603 @@
+package db
+
+import (
+	"encoding/binary"
+	"log"
+	"math"
+	"time"
+	"zeroDB/global/config"
+	"zeroDB/global/consts"
+	"zeroDB/global/dberror"
+	"zeroDB/storage"
+)
+
+var (
+	// 生成 checkpoint 的数据类型，string 的归档文件通过 hint 文件加载
+	checkpointTypes = []consts.DataType{consts.List, consts.Hash, consts.Set, consts.ZSet}
+)
+
+type (
+	// checkpoint 覆盖的日志位置
+	// fileIds 是生成 checkpoint 时 manifest 中该类型的所有文件，最后一个是当时的活跃文件，offset 是活跃文件写到的位置
+	checkpointPos struct {
+		fileIds []uint32
+		offset  int64
+	}
+
+	// 从 checkpoint 中读出的一种集合类型的状态
+	checkpoint struct {
+		pos      checkpointPos
+		txIds    []uint64
+		blobRefs map[uint32]int64
+		dead     map[uint32]int64
+		items    map[string]map[string]itemLoc
+		lists    map[string]map[entryLoc]uint32
+		expires  map[string]int64
+		keys     []checkpointKey
+	}
+
+	// 一个 key 的内容
+	checkpointKey struct {
+		key string
+		// list 的元素、hash 的 value、set 的 member
+		values [][]byte
+		// hash 的 field、zset 的 member
+		fields []string
+		// zset 的 score，和 fields 一一对应
+		scores []float64
+	}
+
+	// checkpointer 在后台定时为集合类型生成 checkpoint
+	checkpointer struct {
+		db       *DB
+		interval time.Duration
+		stop     chan struct{}
+		done     chan struct{}
+	}
+
+	// 生成 checkpoint 时持有写锁取得的快照
+	cpSnapshot struct {
+		pos checkpointPos
+		// MVCC 快照的 tx id，也是 checkpoint 中最大的 tx id
+		txId uint64
+		refs map[uint32]int64
+		dead map[uint32]int64
+		keys []string
+	}
+
+	cpEncoder struct {
+		buf []byte
+	}
+
+	cpDecoder struct {
+		buf []byte
+		err error
+	}
+)
+
+// 根据配置创建后台 checkpoint，没有开启时返回 nil
+func newCheckpointer(db *DB, cfg config.Config) *checkpointer {
+	if cfg.CheckpointInterval <= 0 {
+		return nil
+	}
+	return &checkpointer{
+		db:       db,
+		interval: cfg.CheckpointInterval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (c *checkpointer) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.db.Checkpoint(); err != nil {
+				log.Printf("background checkpoint err: %+v", err)
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// 停止后台 checkpoint，等待正在写入的 checkpoint 完成
+func (c *checkpointer) close() {
+	close(c.stop)
+	<-c.done
+}
+
+// Checkpoint 为 list、hash、set、zset 保存内存中 index 的快照和它覆盖的日志位置
+// 启动时加载最新的有效 checkpoint，只需要重放之后写入的 entry
+// 只在取得快照时持有该类型的写锁，编码和写文件时不阻塞读写；正在 reclaim 的类型会被跳过，reclaim 之后原来的 checkpoint 不再有效
+func (db *DB) Checkpoint() error {
+	if db.isClosed() {
+		return dberror.ErrDBIsClosed
+	}
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
+	for _, dType := range checkpointTypes {
+		if err := db.startReclaim(dType); err != nil {
+			if err == dberror.ErrDBisReclaiming {
+				continue
+			}
+			return err
+		}
+		err := db.writeCheckpoint(dType)
+		db.finishReclaim(dType)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 生成并保存一种数据类型的 checkpoint，位置和上一次相同时不需要重新生成
+// 调用者需要保证同一种类型没有在 reclaim 或者生成 checkpoint
+func (db *DB) writeCheckpoint(dType consts.DataType) error {
+	unlock := db.lockMgr.Lock(dType)
+	activeFile, err := db.getActiveFile(dType)
+	if err != nil {
+		unlock()
+		return err
+	}
+	pos := checkpointPos{fileIds: db.manifest.FileIds(dType), offset: activeFile.Offset}
+	if pos.equal(db.checkpointed[dType]) {
+		unlock()
+		return nil
+	}
+	snap := db.checkpointSnapshot(dType, pos)
+	unlock()
+	data, err := db.encodeCheckpoint(dType, snap)
+	db.endTxn(snap.txId)
+	if err != nil {
+		return err
+	}
+
+	// checkpoint 覆盖的 entry 需要先持久化，blob 文件先于 dbfile
+	// 之前的活跃文件在归档时已经 sync 过了
+	if err = db.fileOpts.Blobs.Sync(); err != nil {
+		return err
+	}
+	if err = activeFile.Sync(); err != nil {
+		return err
+	}
+	if err = storage.WriteCheckpoint(db.config.DirPath, dType, data, db.fileOpts); err != nil {
+		return err
+	}
+	db.checkpointed[dType] = pos
+	return nil
+}
+
+// 取得生成 checkpoint 需要的快照，调用者持有该类型的写锁
+// 只复制每个文件的统计和所有的 key，key 的内容在释放锁之后从 MVCC 快照中读取，之后被修改的 key 读取 history 中保存的状态
+func (db *DB) checkpointSnapshot(dType consts.DataType, pos checkpointPos) *cpSnapshot {
+	g := db.garbage[dType]
+	snap := &cpSnapshot{
+		pos:  pos,
+		txId: db.beginTxn(),
+		refs: db.fileOpts.Blobs.Refs(dType),
+		dead: g.deadBytesOfFiles(),
+	}
+
+	// index 中的 key、garbage 中记录了位置的 key 和有过期时间的 key
+	seen := make(map[string]struct{})
+	add := func(key string) {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			snap.keys = append(snap.keys, key)
+		}
+	}
+	for _, key := range db.collectionKeys(dType) {
+		add(key)
+	}
+	for _, key := range g.keys() {
+		add(key)
+	}
+	for key := range db.expires[dType] {
+		add(key)
+	}
+	return snap
+}
+
+// 编码一种集合类型在快照中的状态，逐个 key 持有读锁
+// 包括 index、过期时间、dead bytes 统计、blob 文件的引用和最大的 tx id，它们都是重放被覆盖的 entry 得到的
+func (db *DB) encodeCheckpoint(dType consts.DataType, snap *cpSnapshot) ([]byte, error) {
+	enc := &cpEncoder{}
+	enc.uint(uint64(dType))
+	enc.uint(uint64(len(snap.pos.fileIds)))
+	for _, id := range snap.pos.fileIds {
+		enc.uint(uint64(id))
+	}
+	enc.int(snap.pos.offset)
+
+	// 之前的版本保存活跃文件中已经提交的 tx id，现在只保存最大的 tx id，格式相同
+	enc.uint(1)
+	enc.uint(snap.txId)
+
+	enc.uint(uint64(len(snap.refs)))
+	for id, n := range snap.refs {
+		enc.uint(uint64(id))
+		enc.int(n)
+	}
+	enc.uint(uint64(len(snap.dead)))
+	for id, n := range snap.dead {
+		enc.uint(uint64(id))
+		enc.int(n)
+	}
+
+	// 每个部分的数量在编码完所有的 key 之后才知道
+	var (
+		items, lists, expires, keys cpEncoder
+		nItems, nLists, nExpires    int
+		nKeys                       int
+	)
+	for _, key := range snap.keys {
+		unlock := db.lockMgr.RLock(dType)
+		kv := db.versionAt(dType, key, snap.txId)
+		var err error
+		if kv == nil {
+			kv, err = db.keyState(dType, key)
+		}
+		unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(kv.items) > 0 {
+			nItems++
+			items.string(key)
+			items.uint(uint64(len(kv.items)))
+			for field, loc := range kv.items {
+				items.string(field)
+				items.loc(loc.entryLoc)
+				items.uint(uint64(loc.size))
+			}
+		}
+		if len(kv.locs) > 0 {
+			nLists++
+			lists.string(key)
+			lists.uint(uint64(len(kv.locs)))
+			for loc, size := range kv.locs {
+				lists.loc(loc)
+				lists.uint(uint64(size))
+			}
+		}
+		if kv.expire != 0 {
+			nExpires++
+			expires.string(key)
+			expires.int(kv.expire)
+		}
+		if keys.keyContent(dType, key, kv) {
+			nKeys++
+		}
+	}
+	for _, part := range []struct {
+		n   int
+		enc *cpEncoder
+	}{{nItems, &items}, {nLists, &lists}, {nExpires, &expires}, {nKeys, &keys}} {
+		enc.uint(uint64(part.n))
+		enc.buf = append(enc.buf, part.enc.buf...)
+	}
+	return enc.buf, nil
+}
+
+// 编码 key 在 kv 中的内容，key 不存在时不编码并返回 false
+func (enc *cpEncoder) keyContent(dType consts.DataType, key string, kv *keyVersion) bool {
+	switch dType {
+	case consts.List:
+		vals := kv.list.LRange(key, 0, -1)
+		if len(vals) == 0 {
+			return false
+		}
+		enc.string(key)
+		enc.uint(uint64(len(vals)))
+		for _, v := range vals {
+			enc.bytes(v)
+		}
+	case consts.Hash:
+		vals := kv.hash.HGetAll(key)
+		if len(vals) == 0 {
+			return false
+		}
+		enc.string(key)
+		enc.uint(uint64(len(vals) / 2))
+		for i := 0; i+1 < len(vals); i += 2 {
+			enc.bytes(vals[i])
+			enc.bytes(vals[i+1])
+		}
+	case consts.Set:
+		members := kv.set.SMembers(key)
+		if len(members) == 0 {
+			return false
+		}
+		enc.string(key)
+		enc.uint(uint64(len(members)))
+		for _, m := range members {
+			enc.bytes(m)
+		}
+	case consts.ZSet:
+		vals := kv.zset.ZRangeWithScores(key, 0, -1)
+		if len(vals) == 0 {
+			return false
+		}
+		enc.string(key)
+		enc.uint(uint64(len(vals) / 2))
+		for i := 0; i+1 < len(vals); i += 2 {
+			enc.string(vals[i].(string))
+			enc.uint(math.Float64bits(vals[i+1].(float64)))
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func decodeCheckpoint(dType consts.DataType, data []byte) (*checkpoint, error) {
+	dec := &cpDecoder{buf: data}
+	if consts.DataType(dec.uint()) != dType {
+		return nil, dberror.ErrInvalidCheckpoint
+	}
+	cp := &checkpoint{
+		blobRefs: make(map[uint32]int64),
+		dead:     make(map[uint32]int64),
+		items:    make(map[string]map[string]itemLoc),
+		lists:    make(map[string]map[entryLoc]uint32),
+		expires:  make(map[string]int64),
+	}
+	for i, n := 0, dec.count(); i < n; i++ {
+		cp.pos.fileIds = append(cp.pos.fileIds, uint32(dec.uint()))
+	}
+	cp.pos.offset = dec.int()
+
+	for i, n := 0, dec.count(); i < n; i++ {
+		cp.txIds = append(cp.txIds, dec.uint())
+	}
+	for i, n := 0, dec.count(); i < n; i++ {
+		id := uint32(dec.uint())
+		cp.blobRefs[id] = dec.int()
+	}
+
+	for i, n := 0, dec.count(); i < n; i++ {
+		id := uint32(dec.uint())
+		cp.dead[id] = dec.int()
+	}
+	for i, n := 0, dec.count(); i < n; i++ {
+		key := dec.string()
+		fields := make(map[string]itemLoc)
+		for j, m := 0, dec.count(); j < m; j++ {
+			field := dec.string()
+			loc := itemLoc{entryLoc: dec.loc()}
+			loc.size = uint32(dec.uint())
+			fields[field] = loc
+		}
+		cp.items[key] = fields
+	}
+	for i, n := 0, dec.count(); i < n; i++ {
+		key := dec.string()
+		locs := make(map[entryLoc]uint32)
+		for j, m := 0, dec.count(); j < m; j++ {
+			loc := dec.loc()
+			locs[loc] = uint32(dec.uint())
+		}
+		cp.lists[key] = locs
+	}
+
+	for i, n := 0, dec.count(); i < n; i++ {
+		key := dec.string()
+		cp.expires[key] = dec.int()
+	}
+
+	for i, n := 0, dec.count(); i < n && dec.err == nil; i++ {
+		k := checkpointKey{key: dec.string()}
+		for j, m := 0, dec.count(); j < m; j++ {
+			switch dType {
+			case consts.List, consts.Set:
+				k.values = append(k.values, dec.bytes())
+			case consts.Hash:
+				k.fields = append(k.fields, dec.string())
+				k.values = append(k.values, dec.bytes())
+			case consts.ZSet:
+				k.fields = append(k.fields, dec.string())
+				k.scores = append(k.scores, math.Float64frombits(dec.uint()))
+			}
+		}
+		cp.keys = append(cp.keys, k)
+	}
+
+	if dec.err == nil && len(dec.buf) > 0 {
+		dec.err = dberror.ErrInvalidCheckpoint
+	}
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	return cp, nil
+}
+
+// 启动时加载一种类型最新的有效 checkpoint，files 包括该类型的所有 dbfile
+// 返回需要继续重放的第一个文件在 fileIds 中的下标和开始重放的位置，没有可用的 checkpoint 时返回 false
+func (db *DB) loadCheckpoint(dType consts.DataType, fileIds []uint32, files map[uint32]*storage.DBFile) (int, int64, bool) {
+	checkpoints, err := storage.LoadCheckpoints(db.config.DirPath, dType, db.fileOpts)
+	if err != nil {
+		log.Printf("load checkpoint of %s err: %+v, replay the db files instead", storage.DBFileSuffixName[dType], err)
+		return 0, 0, false
+	}
+	for _, data := range checkpoints {
+		cp, err := decodeCheckpoint(dType, data)
+		if err != nil {
+			log.Printf("decode checkpoint of %s err: %+v", storage.DBFileSuffixName[dType], err)
+			continue
+		}
+		if !cp.pos.validFor(fileIds, files) {
+			continue
+		}
+		db.restoreCheckpoint(dType, cp)
+		db.checkpointed[dType] = cp.pos
+		return len(cp.pos.fileIds) - 1, cp.pos.offset, true
+	}
+	return 0, 0, false
+}
+
+// 使用 checkpoint 中的状态建立 index，在加载任何 entry 之前调用
+// 已经过期的 key 和重放时一样被清空
+func (db *DB) restoreCheckpoint(dType consts.DataType, cp *checkpoint) {
+	for _, txId := range cp.txIds {
+		db.txnMeta.observe(txId)
+	}
+	db.fileOpts.Blobs.AddRefs(dType, cp.blobRefs)
+
+	g := db.garbage[dType]
+	g.restore(cp.dead, cp.items, cp.lists)
+
+	now := time.Now().Unix()
+	expired := func(key string) bool {
+		deadline, ok := cp.expires[key]
+		return ok && deadline < now
+	}
+	for key, deadline := range cp.expires {
+		if deadline >= now {
+			db.expires[dType][key] = deadline
+		} else if dType == consts.List {
+			g.clearList(key)
+		} else {
+			g.clearItems(key)
+		}
+	}
+
+	for _, k := range cp.keys {
+		if expired(k.key) {
+			continue
+		}
+		switch dType {
+		case consts.List:
+			for _, v := range k.values {
+				db.listIndex.indexes.RPush(k.key, v)
+			}
+		case consts.Hash:
+			for i, field := range k.fields {
+				db.hashIndex.indexes.HSet(k.key, field, k.values[i])
+			}
+		case consts.Set:
+			for _, m := range k.values {
+				db.setIndex.indexes.SAdd(k.key, m)
+			}
+		case consts.ZSet:
+			for i, member := range k.fields {
+				db.zsetIndex.indexes.ZAdd(k.key, k.scores[i], member)
+			}
+		}
+	}
+}
+
+func (p checkpointPos) equal(o checkpointPos) bool {
+	if p.offset != o.offset || len(p.fileIds) != len(o.fileIds) {
+		return false
+	}
+	for i, id := range p.fileIds {
+		if o.fileIds[i] != id {
+			return false
+		}
+	}
+	return true
+}
+
+// checkpoint 覆盖的文件必须是 manifest 中最前面的文件，并且最后一个文件的长度不小于 offset
+// reclaim 替换了其中的文件之后 checkpoint 不再有效
+func (p checkpointPos) validFor(fileIds []uint32, files map[uint32]*storage.DBFile) bool {
+	if len(p.fileIds) == 0 || len(p.fileIds) > len(fileIds) {
+		return false
+	}
+	for i, id := range p.fileIds {
+		if fileIds[i] != id {
+			return false
+		}
+	}
+	df, ok := files[p.fileIds[len(p.fileIds)-1]]
+	return ok && p.offset >= df.DataOffset() && p.offset <= df.Offset
+}
+
+func (enc *cpEncoder) uint(v uint64) {
+	enc.buf = binary.AppendUvarint(enc.buf, v)
+}
+
+func (enc *cpEncoder) int(v int64) {
+	enc.buf = binary.AppendVarint(enc.buf, v)
+}
+
+func (enc *cpEncoder) bytes(b []byte) {
+	enc.uint(uint64(len(b)))
+	enc.buf = append(enc.buf, b...)
+}
+
+func (enc *cpEncoder) string(s string) {
+	enc.uint(uint64(len(s)))
+	enc.buf = append(enc.buf, s...)
+}
+
+func (enc *cpEncoder) loc(loc entryLoc) {
+	enc.uint(uint64(loc.fileId))
+	enc.int(loc.offset)
+}
+
+func (dec *cpDecoder) uint() uint64 {
+	if dec.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(dec.buf)
+	if n <= 0 {
+		dec.err = dberror.ErrInvalidCheckpoint
+		return 0
+	}
+	dec.buf = dec.buf[n:]
+	return v
+}
+
+func (dec *cpDecoder) int() int64 {
+	if dec.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(dec.buf)
+	if n <= 0 {
+		dec.err = dberror.ErrInvalidCheckpoint
+		return 0
+	}
+	dec.buf = dec.buf[n:]
+	return v
+}
+
+// 读取元素的数量，每个元素至少占一个字节，数量超过剩余的长度说明数据损坏
+func (dec *cpDecoder) count() int {
+	n := dec.uint()
+	if dec.err == nil && n > uint64(len(dec.buf)) {
+		dec.err = dberror.ErrInvalidCheckpoint
+	}
+	if dec.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+func (dec *cpDecoder) bytes() []byte {
+	n := dec.uint()
+	if dec.err == nil && n > uint64(len(dec.buf)) {
+		dec.err = dberror.ErrInvalidCheckpoint
+	}
+	if dec.err != nil {
+		return nil
+	}
+	b := dec.buf[:n:n]
+	dec.buf = dec.buf[n:]
+	return b
+}
+
+func (dec *cpDecoder) string() string {
+	return string(dec.bytes())
+}
+
+func (dec *cpDecoder) loc() entryLoc {
+	return entryLoc{fileId: uint32(dec.uint()), offset: dec.int()}
+}
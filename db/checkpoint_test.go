@@ -0,0 +1,78 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"zeroDB/global/config"
+	"zeroDB/global/consts"
+	"zeroDB/storage"
+)
+
+// 开启加密时 checkpoint 和 hint 文件也是加密的，重新打开时可以使用
+func TestCheckpointAndHintsEncrypted(t *testing.T) {
+	dir := t.TempDir() + "/"
+	keyFile := t.TempDir() + "/keys"
+	if err := os.WriteFile(keyFile, []byte("1:000102030405060708090a0b0c0d0e0f\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Config{
+		DirPath:            dir,
+		BlockSize:          4 << 10,
+		MaxKeySize:         1 << 10,
+		MaxValueSize:       1 << 20,
+		EncryptionKeyFile:  keyFile,
+		CheckpointInterval: time.Hour,
+	}
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if err := db.Set(fmt.Sprintf("secret-key-%d", i), fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.HSet([]byte("secret-hash"), []byte(fmt.Sprintf("field-%d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archived := db.manifest.FileIds(consts.String)[0]
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{storage.HintFilePath(dir, archived, consts.String), storage.CheckpointPath(dir, consts.Hash)} {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(buf, []byte("secret-")) {
+			t.Fatalf("%s contains plaintext keys", path)
+		}
+	}
+
+	db, err = Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.checkpointed[consts.Hash].fileIds == nil {
+		t.Fatal("encrypted checkpoint of hash not loaded")
+	}
+	hints, err := storage.LoadHintFile(db.archFiles[consts.String][archived])
+	if err != nil || len(hints) == 0 {
+		t.Fatalf("load encrypted hint file: %d hints, err %v", len(hints), err)
+	}
+	for i := 0; i < 200; i++ {
+		var val string
+		if err := db.Get(fmt.Sprintf("secret-key-%d", i), &val); err != nil || val != fmt.Sprintf("value-%d", i) {
+			t.Fatalf("secret-key-%d: got %q, err %v", i, val, err)
+		}
+	}
+	if n := db.HLen([]byte("secret-hash")); n != 200 {
+		t.Fatalf("hlen: got %d, want 200", n)
+	}
+}
@@ -0,0 +1,283 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"time"
+	"zeroDB/global/consts"
+	"zeroDB/global/dberror"
+	"zeroDB/global/utils"
+	"zeroDB/storage"
+)
+
+var (
+	// 集合类型的过期标记
+	expireMarks = map[consts.DataType]uint16{
+		consts.List: consts.ListLExpire,
+		consts.Hash: consts.HashHExpire,
+		consts.Set:  consts.SetSExpire,
+		consts.ZSet: consts.ZSetZExpire,
+	}
+	// 集合类型的清空标记
+	clearMarks = map[consts.DataType]uint16{
+		consts.List: consts.ListLClear,
+		consts.Hash: consts.HashHClear,
+		consts.Set:  consts.SetSClear,
+		consts.ZSet: consts.ZSetZClear,
+	}
+)
+
+// 按照内存中的状态重写集合类型时写入的一个 entry
+type stateEntry struct {
+	e *storage.Entry
+	// hash 的 field，set 和 zset 的 member，list 和过期标记为空
+	field string
+	// 过期标记不记录 dead bytes
+	expire bool
+	// hash field 当前的 entry 在旧文件中的位置，value 可能保存在 blob 文件中时复用其中的 blob pointer
+	blobLoc *entryLoc
+}
+
+// 按照内存中的状态重写一种集合类型（list、hash、set、zset）的所有文件
+// 每个 key 写成当前状态的快照：list 按顺序 RPush，hash 写入每个 field，set 写入每个 member，zset 写入当前的 score，最后写入过期时间
+// 持有写锁归档活跃文件并取得所有的 key，这时重放所有旧文件的结果就是内存中的状态，之后的写入都在新的活跃文件中
+// 之后逐个 key 持有读锁生成快照并写入新文件，快照之前已经被写入的 key 不能写入新文件，否则之后的文件重放时会被重复执行
+// 这些 key 在替换文件时持有写锁，在活跃文件中写入清空标记和当前的状态，新文件在 manifest 中替换旧文件，在之后的文件之前加载
+func (db *DB) reclaimCollection(dType consts.DataType) (err error) {
+	unlock := db.lockMgr.Lock(dType)
+	if activeFile, err := db.getActiveFile(dType); err != nil {
+		unlock()
+		return err
+	} else if activeFile.Offset > activeFile.DataOffset() {
+		if _, err = db.rotateActiveFile(activeFile); err != nil {
+			unlock()
+			return err
+		}
+	}
+	oldFiles := make(map[uint32]*storage.DBFile, len(db.archFiles[dType]))
+	for id, f := range db.archFiles[dType] {
+		oldFiles[id] = f
+	}
+	keys := db.collectionKeys(dType)
+	g := db.garbage[dType]
+	g.startTouch()
+	unlock()
+	defer g.stopTouch()
+	if len(oldFiles) == 0 {
+		return dberror.ErrReclaimUnreached
+	}
+
+	fs := db.fileOpts.FS
+	reclaimPath := db.config.DirPath + consts.ReclaimPath + storage.PathSeparator + storage.DBFileSuffixName[dType]
+	if err = fs.MkdirAll(reclaimPath, os.ModePerm); err != nil {
+		return
+	}
+	defer fs.RemoveAll(reclaimPath)
+
+	var (
+		df       *storage.DBFile
+		newFiles []*storage.DBFile
+		// 新写入的 entry 的位置
+		items = make(map[string]map[string]itemLoc)
+		lists = make(map[string]map[entryLoc]uint32)
+		// 生成快照之前已经被写入的 key
+		touched []string
+		swapped bool
+	)
+	defer func() {
+		if err != nil && !swapped {
+			for _, f := range newFiles {
+				f.Close(false)
+			}
+		}
+	}()
+
+	for _, key := range keys {
+		unlock := db.lockMgr.RLock(dType)
+		if g.isTouched(key) {
+			unlock()
+			touched = append(touched, key)
+			continue
+		}
+		entries := db.stateEntries(dType, key, oldFiles)
+		unlock()
+
+		for _, se := range entries {
+			e := se.e
+			if se.blobLoc != nil {
+				e = reuseBlobEntry(oldFiles, se)
+			}
+			if df == nil || int64(e.Size())+df.Offset > db.config.BlockSize {
+				if df, err = storage.NewDBFile(reclaimPath, db.manifest.NextFileId(dType), dType, db.fileOpts); err != nil {
+					return
+				}
+				newFiles = append(newFiles, df)
+			}
+			offset := df.Offset
+			if err = df.Write(e); err != nil {
+				return
+			}
+			if se.expire {
+				continue
+			}
+
+			loc := itemLoc{entryLoc: entryLoc{fileId: df.Id, offset: offset}, size: uint32(df.Offset - offset)}
+			if dType == consts.List {
+				if lists[key] == nil {
+					lists[key] = make(map[entryLoc]uint32)
+				}
+				lists[key][loc.entryLoc] = loc.size
+			} else {
+				if items[key] == nil {
+					items[key] = make(map[string]itemLoc)
+				}
+				items[key][se.field] = loc
+			}
+		}
+	}
+	for _, f := range newFiles {
+		if err = f.Sync(); err != nil {
+			return
+		}
+	}
+
+	// 旧文件中的 entry 对 blob 文件的引用，删除旧文件后释放
+	refs := make(map[uint32]int64)
+	if storage.IsBlobType(dType) {
+		for _, f := range oldFiles {
+			var fileRefs map[uint32]int64
+			if fileRefs, err = f.BlobRefs(); err != nil {
+				return
+			}
+			for id, n := range fileRefs {
+				refs[id] += n
+			}
+		}
+	}
+
+	var fileIds []uint32
+	for _, id := range db.manifest.FileIds(dType) {
+		if _, ok := oldFiles[id]; ok {
+			fileIds = append(fileIds, id)
+		}
+	}
+
+	// 替换文件，只在这里持有写锁
+	unlock = db.lockMgr.Lock(dType)
+	defer unlock()
+
+	// 没有写入新文件的 key 在替换之前写入活跃文件并持久化，崩溃时旧文件和新文件都能恢复出当前的状态
+	if len(touched) > 0 {
+		if err = db.rewriteKeys(dType, touched); err != nil {
+			return
+		}
+	}
+	if err = db.swapFiles(dType, reclaimPath, fileIds, newFiles); err != nil {
+		return
+	}
+	swapped = true
+
+	g.rebuild(fileIdSet(oldFiles), items, lists)
+	db.fileOpts.Blobs.Release(dType, refs)
+
+	// 重写后的文件需要重新生成 hint 文件
+	for _, f := range newFiles {
+		db.writeHintFile(f)
+	}
+	return db.removeDBFiles(oldFiles)
+}
+
+// 集合类型所有的 key，调用者持有该类型的锁
+func (db *DB) collectionKeys(dType consts.DataType) []string {
+	switch dType {
+	case consts.List:
+		return db.listIndex.indexes.Keys()
+	case consts.Hash:
+		return db.hashIndex.indexes.Keys()
+	case consts.Set:
+		return db.setIndex.indexes.Keys()
+	case consts.ZSet:
+		return db.zsetIndex.indexes.Keys()
+	}
+	return nil
+}
+
+// 生成 key 当前状态的 entry，调用者持有该类型的锁
+// 已经过期但还没有删除的 key 不再写入，没有过期的 key 即使已经为空也写入过期时间
+// oldFiles 不为 nil 时，value 保存在 blob 文件中的 hash field 记录它在旧文件中的位置
+func (db *DB) stateEntries(dType consts.DataType, key string, oldFiles map[uint32]*storage.DBFile) (entries []*stateEntry) {
+	deadline, hasExpire := db.expires[dType][key]
+	if hasExpire && deadline <= time.Now().Unix() {
+		return
+	}
+
+	k := []byte(key)
+	switch dType {
+	case consts.List:
+		for _, v := range db.listIndex.indexes.LRange(key, 0, -1) {
+			entries = append(entries, &stateEntry{e: storage.NewEntryNoExtra(k, v, consts.List, consts.ListRPush)})
+		}
+	case consts.Hash:
+		vals := db.hashIndex.indexes.HGetAll(key)
+		for i := 0; i+1 < len(vals); i += 2 {
+			field, v := string(vals[i]), vals[i+1]
+			se := &stateEntry{e: storage.NewEntry(k, v, vals[i], consts.Hash, consts.HashHSet), field: field}
+			if oldFiles != nil && db.config.BlobThreshold > 0 && uint32(len(v)) >= db.config.BlobThreshold {
+				if loc, ok := db.garbage[dType].current(key, field); ok {
+					if _, old := oldFiles[loc.fileId]; old {
+						se.blobLoc = &loc
+					}
+				}
+			}
+			entries = append(entries, se)
+		}
+	case consts.Set:
+		for _, m := range db.setIndex.indexes.SMembers(key) {
+			entries = append(entries, &stateEntry{e: storage.NewEntryNoExtra(k, m, consts.Set, consts.SetSAdd), field: string(m)})
+		}
+	case consts.ZSet:
+		vals := db.zsetIndex.indexes.ZRangeWithScores(key, 0, -1)
+		for i := 0; i+1 < len(vals); i += 2 {
+			member, score := vals[i].(string), vals[i+1].(float64)
+			extra := []byte(utils.Float64ToStr(score))
+			entries = append(entries, &stateEntry{e: storage.NewEntry(k, []byte(member), extra, consts.ZSet, consts.ZSetZAdd), field: member})
+		}
+	}
+
+	if hasExpire {
+		e := storage.NewEntryWithExpire(k, nil, deadline, dType, expireMarks[dType])
+		entries = append(entries, &stateEntry{e: e, expire: true})
+	}
+	return
+}
+
+// 在活跃文件中写入 keys 的清空标记和当前的状态并持久化，调用者持有该类型的写锁
+// 之前的 entry 无论从哪个文件重放，重放到这里时都会被清空，内存中的状态不变
+func (db *DB) rewriteKeys(dType consts.DataType, keys []string) error {
+	for _, key := range keys {
+		if err := db.write(storage.NewEntryNoExtra([]byte(key), nil, dType, clearMarks[dType]), false); err != nil {
+			return err
+		}
+		for _, se := range db.stateEntries(dType, key, nil) {
+			if err := db.write(se.e, false); err != nil {
+				return err
+			}
+		}
+	}
+	return db.syncActive()
+}
+
+// 读取 hash field 在快照时的 entry，value 保存在 blob 文件中时写入新文件只复制其中的 BlobPointer
+// blobLoc 是持有锁时 field 当前的 entry 的位置，旧文件不会再被修改，只需要确认这个位置是该 field 的 entry
+func reuseBlobEntry(oldFiles map[uint32]*storage.DBFile, se *stateEntry) *storage.Entry {
+	e, err := oldFiles[se.blobLoc.fileId].ReadUnresolved(se.blobLoc.offset)
+	if err != nil {
+		return se.e
+	}
+	if _, ok := e.BlobFileId(); !ok || e.GetMark() != consts.HashHSet || !bytes.Equal(e.Meta.Key, se.e.Meta.Key) ||
+		string(e.Meta.Extra) != se.field {
+		return se.e
+	}
+	e.TxId = 0
+	return e
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+	"zeroDB/global/config"
+	"zeroDB/global/dberror"
+	"zeroDB/storage"
+)
+
+// FsyncPolicy 写入持久化到磁盘的方式
+type FsyncPolicy uint8
+
+const (
+	// 不主动 fsync，由操作系统决定什么时候写入磁盘
+	FsyncNo FsyncPolicy = iota
+	// 每次写入都 fsync，写入成功时数据已经持久化
+	FsyncAlways
+	// 后台每秒 fsync 一次，崩溃时最多丢失最近一秒的写入
+	FsyncEverySec
+)
+
+const (
+	// everysec 模式下 fsync 的间隔
+	fsyncInterval = time.Second
+)
+
+// 根据配置返回 fsync 的方式，FsyncPolicy 为空时兼容旧的 Sync 配置
+func parseFsyncPolicy(cfg config.Config) (FsyncPolicy, error) {
+	switch strings.ToLower(cfg.FsyncPolicy) {
+	case "":
+		if cfg.Sync {
+			return FsyncAlways, nil
+		}
+		return FsyncNo, nil
+	case "always":
+		return FsyncAlways, nil
+	case "everysec":
+		return FsyncEverySec, nil
+	case "no":
+		return FsyncNo, nil
+	}
+	return FsyncNo, dberror.ErrUnknownFsyncPolicy
+}
+
+// syncer everysec 模式下在后台定时 fsync 有新写入的活跃文件和 txn file
+type syncer struct {
+	db   *DB
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newSyncer(db *DB) *syncer {
+	s := &syncer{
+		db:   db,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *syncer) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(fsyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.db.syncDirty(); err != nil {
+				log.Printf("background fsync err: %+v", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// 停止后台 fsync，等待正在进行的 fsync 完成
+func (s *syncer) close() {
+	close(s.stop)
+	<-s.done
+}
+
+// fsync 所有有新写入的活跃文件和 blob 文件
+func (db *DB) syncDirty() (err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	start := time.Now()
+	if err = db.syncActive(); err != nil {
+		return
+	}
+	db.markSynced(start)
+	return
+}
+
+// fsync blob 文件和有新写入的活跃文件，blob 文件需要先于 dbfile 持久化
+// 不持有 db.mu，group commit 在关闭时也会调用
+func (db *DB) syncActive() (err error) {
+	if err = db.fileOpts.Blobs.Sync(); err != nil {
+		return
+	}
+	db.activeFile.Range(func(key, value interface{}) bool {
+		if df, ok := value.(*storage.DBFile); ok && df.Dirty() {
+			if err = df.Sync(); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+	return
+}
+
+// 记录最近一次成功 fsync 的时间，start 是开始 fsync 的时间，在这之前的写入都已经持久化
+func (db *DB) markSynced(start time.Time) {
+	atomic.StoreInt64(&db.lastSync, start.UnixNano())
+}
+
+// LastSync 返回最近一次所有写入都成功 fsync 的时间，还没有 fsync 过时返回零值
+// everysec 模式下，这个时间之后的写入在崩溃时可能丢失
+func (db *DB) LastSync() time.Time {
+	nano := atomic.LoadInt64(&db.lastSync)
+	if nano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nano)
+}
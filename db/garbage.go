@@ -0,0 +1,517 @@
+package db
+
+import (
+	"sort"
+	"sync"
+	"time"
+	str "zeroDB/datastructure/string"
+	"zeroDB/global/consts"
+	"zeroDB/storage"
+)
+
+type (
+	// entry 在 dbfile 中的位置
+	entryLoc struct {
+		fileId uint32
+		offset int64
+	}
+
+	// field/member 最新的 entry 的位置
+	itemLoc struct {
+		entryLoc
+		// 被覆盖时计入 dead bytes 的大小，重写文件时不会被删除的 entry 为 0
+		size uint32
+	}
+
+	// garbage 记录一种数据类型每个 dbfile 中已经被覆盖的 entry 的大小（dead bytes）
+	// string 最新的位置保存在 string index 中，hash、set、zset 记录每个 field/member 最新的 entry 的位置
+	// list 的 entry 没有单独的标识，只记录每个 key 的所有 entry，LClear 或过期时全部失效
+	garbage struct {
+		mu    sync.Mutex
+		dead  map[uint32]int64
+		items map[string]map[string]itemLoc
+		lists map[string]map[entryLoc]uint32
+
+		// 集合类型按照内存中的状态重写时，记录重写开始之后被写入的 key，为 nil 时不记录，见 reclaimCollection
+		touched map[string]struct{}
+	}
+
+	// FileGarbage 一个 dbfile 的垃圾统计
+	FileGarbage struct {
+		Type   consts.DataType
+		FileId uint32
+		Active bool
+		// 文件中 entry 的总大小，不包括文件头
+		Size int64
+		// 已经被覆盖、删除或者过期的 entry 的大小
+		DeadBytes int64
+		// DeadBytes / Size，Size 为 0 时为 0
+		Ratio float64
+	}
+)
+
+func newGarbage() *garbage {
+	return &garbage{
+		dead:  make(map[uint32]int64),
+		items: make(map[string]map[string]itemLoc),
+		lists: make(map[string]map[entryLoc]uint32),
+	}
+}
+
+// 开始记录被写入的 key
+func (g *garbage) startTouch() {
+	g.mu.Lock()
+	g.touched = make(map[string]struct{})
+	g.mu.Unlock()
+}
+
+// 停止记录被写入的 key
+func (g *garbage) stopTouch() {
+	g.mu.Lock()
+	g.touched = nil
+	g.mu.Unlock()
+}
+
+func (g *garbage) touch(key string) {
+	g.mu.Lock()
+	if g.touched != nil {
+		g.touched[key] = struct{}{}
+	}
+	g.mu.Unlock()
+}
+
+// 开始记录之后 key 是否被写入过
+func (g *garbage) isTouched(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.touched[key]
+	return ok
+}
+
+// 复制 key 的每个 field/member 和 list 的每个 entry 的位置
+func (g *garbage) keyLocs(key string) (items map[string]itemLoc, locs map[entryLoc]uint32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if fields := g.items[key]; len(fields) > 0 {
+		items = make(map[string]itemLoc, len(fields))
+		for field, loc := range fields {
+			items[field] = loc
+		}
+	}
+	if list := g.lists[key]; len(list) > 0 {
+		locs = make(map[entryLoc]uint32, len(list))
+		for loc, size := range list {
+			locs[loc] = size
+		}
+	}
+	return
+}
+
+// 记录了 entry 位置的所有 key
+func (g *garbage) keys() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	keys := make([]string, 0, len(g.items)+len(g.lists))
+	for key := range g.items {
+		keys = append(keys, key)
+	}
+	for key := range g.lists {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// 复制每个文件的 dead bytes
+func (g *garbage) deadBytesOfFiles() map[uint32]int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	dead := make(map[uint32]int64, len(g.dead))
+	for id, n := range g.dead {
+		dead[id] = n
+	}
+	return dead
+}
+
+func (g *garbage) addDead(fileId uint32, size int64) {
+	if size == 0 {
+		return
+	}
+	g.mu.Lock()
+	g.dead[fileId] += size
+	g.mu.Unlock()
+}
+
+func (g *garbage) deadBytes(fileId uint32) int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.dead[fileId]
+}
+
+// 设置 field/member 最新的位置，loc 为 nil 表示删除，旧的 entry 计入 dead bytes
+func (g *garbage) setItem(key, field string, loc *itemLoc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	fields := g.items[key]
+	if old, ok := fields[field]; ok {
+		g.dead[old.fileId] += int64(old.size)
+	}
+	if loc == nil {
+		if fields != nil {
+			delete(fields, field)
+			if len(fields) == 0 {
+				delete(g.items, key)
+			}
+		}
+		return
+	}
+	if fields == nil {
+		fields = make(map[string]itemLoc)
+		g.items[key] = fields
+	}
+	fields[field] = *loc
+}
+
+// key 的所有 field/member 都失效
+func (g *garbage) clearItems(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, old := range g.items[key] {
+		g.dead[old.fileId] += int64(old.size)
+	}
+	delete(g.items, key)
+}
+
+// entry 是不是 field/member 最新的 entry
+func (g *garbage) isCurrent(key, field string, loc entryLoc) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	cur, ok := g.items[key][field]
+	return ok && cur.entryLoc == loc
+}
+
+// field/member 最新的 entry 的位置
+func (g *garbage) current(key, field string) (entryLoc, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	cur, ok := g.items[key][field]
+	return cur.entryLoc, ok
+}
+
+func (g *garbage) addListEntry(key string, loc itemLoc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	locs := g.lists[key]
+	if locs == nil {
+		locs = make(map[entryLoc]uint32)
+		g.lists[key] = locs
+	}
+	locs[loc.entryLoc] = loc.size
+}
+
+// list 的所有 entry 都失效
+func (g *garbage) clearList(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for loc, size := range g.lists[key] {
+		g.dead[loc.fileId] += int64(size)
+	}
+	delete(g.lists, key)
+}
+
+func (g *garbage) inList(key string, loc entryLoc) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	_, ok := g.lists[key][loc]
+	return ok
+}
+
+// 文件被重写之后更新 entry 的位置
+// oldIds 中的文件被重写，relocs 是被保留的 entry 从旧位置到新位置的映射，没有保留的 entry 不再记录
+func (g *garbage) relocate(oldIds map[uint32]struct{}, relocs map[entryLoc]entryLoc) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for key, fields := range g.items {
+		for field, loc := range fields {
+			if _, ok := oldIds[loc.fileId]; !ok {
+				continue
+			}
+			if newLoc, ok := relocs[loc.entryLoc]; ok {
+				loc.entryLoc = newLoc
+				fields[field] = loc
+			} else {
+				delete(fields, field)
+			}
+		}
+		if len(fields) == 0 {
+			delete(g.items, key)
+		}
+	}
+
+	// 新旧位置可能在同一个文件中，重新建立 map 避免新的位置被再次映射
+	for key, locs := range g.lists {
+		newLocs := make(map[entryLoc]uint32, len(locs))
+		for loc, size := range locs {
+			if _, ok := oldIds[loc.fileId]; !ok {
+				newLocs[loc] = size
+			} else if newLoc, ok := relocs[loc]; ok {
+				newLocs[newLoc] = size
+			}
+		}
+		if len(newLocs) == 0 {
+			delete(g.lists, key)
+		} else {
+			g.lists[key] = newLocs
+		}
+	}
+
+	for id := range oldIds {
+		delete(g.dead, id)
+	}
+}
+
+// 集合类型按照内存中的状态重写之后更新 entry 的位置，oldIds 中的文件被重写
+// items 和 lists 是重写时写入的 entry 的位置，重写开始之后被修改、删除的 field/member 和被清空的 list 对应的新 entry 已经失效
+func (g *garbage) rebuild(oldIds map[uint32]struct{}, items map[string]map[string]itemLoc, lists map[string]map[entryLoc]uint32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	isOld := func(loc entryLoc) bool {
+		_, ok := oldIds[loc.fileId]
+		return ok
+	}
+	for key, fields := range items {
+		for field, loc := range fields {
+			if cur, ok := g.items[key][field]; ok && isOld(cur.entryLoc) {
+				g.items[key][field] = loc
+			} else {
+				g.dead[loc.fileId] += int64(loc.size)
+			}
+		}
+	}
+	for key, locs := range lists {
+		// list 只会被整个清空，还有旧的 entry 说明重写之后没有被清空
+		cur := g.lists[key]
+		var kept bool
+		for loc := range cur {
+			if isOld(loc) {
+				delete(cur, loc)
+				kept = true
+			}
+		}
+		for loc, size := range locs {
+			if kept {
+				cur[loc] = size
+			} else {
+				g.dead[loc.fileId] += int64(size)
+			}
+		}
+	}
+
+	// 重写时没有写入的 entry 不再记录
+	for key, fields := range g.items {
+		for field, loc := range fields {
+			if isOld(loc.entryLoc) {
+				delete(fields, field)
+			}
+		}
+		if len(fields) == 0 {
+			delete(g.items, key)
+		}
+	}
+	for key, locs := range g.lists {
+		for loc := range locs {
+			if isOld(loc) {
+				delete(locs, loc)
+			}
+		}
+		if len(locs) == 0 {
+			delete(g.lists, key)
+		}
+	}
+	for id := range oldIds {
+		delete(g.dead, id)
+	}
+}
+
+// 从 checkpoint 中恢复统计，启动时在加载任何 entry 之前调用
+func (g *garbage) restore(dead map[uint32]int64, items map[string]map[string]itemLoc, lists map[string]map[entryLoc]uint32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.dead, g.items, g.lists = dead, items, lists
+}
+
+// 记录写入到 fileId 的 offset 处的 entry，被它覆盖的 entry 计入所在文件的 dead bytes
+// 需要在更新 index 之前调用，调用者持有该数据类型的写锁
+func (db *DB) trackEntry(e *storage.Entry, fileId uint32, offset int64) {
+	if len(e.Meta.Key) == 0 {
+		return
+	}
+	dType := e.GetType()
+	g := db.garbage[dType]
+	loc := itemLoc{entryLoc: entryLoc{fileId: fileId, offset: offset}, size: e.Size()}
+	key := string(e.Meta.Key)
+	expired := e.Expire < uint64(time.Now().Unix())
+	g.touch(key)
+
+	switch dType {
+	case consts.String:
+		// string index 指向的 entry 被新的 entry 覆盖
+		if node := db.strIndex.idxList.Get(e.Meta.Key); node != nil {
+			old := node.Value().(*str.StrData)
+			g.addDead(old.FileId, int64(old.Size))
+		}
+	case consts.Hash:
+		switch e.GetMark() {
+		case consts.HashHSet:
+			g.setItem(key, string(e.Meta.Extra), &loc)
+		case consts.HashHDel:
+			g.setItem(key, string(e.Meta.Extra), nil)
+		case consts.HashHClear:
+			g.clearItems(key)
+		case consts.HashHExpire:
+			if expired {
+				g.clearItems(key)
+			}
+		}
+	case consts.Set:
+		switch e.GetMark() {
+		case consts.SetSAdd:
+			g.setItem(key, string(e.Meta.Value), &loc)
+		case consts.SetSRem:
+			g.setItem(key, string(e.Meta.Value), nil)
+		case consts.SetSMove:
+			// SMove 同时是源 key 的删除标记，重写文件时总是保留
+			g.setItem(key, string(e.Meta.Value), nil)
+			g.setItem(string(e.Meta.Extra), string(e.Meta.Value), &itemLoc{entryLoc: loc.entryLoc})
+			g.touch(string(e.Meta.Extra))
+		case consts.SetSClear:
+			g.clearItems(key)
+		case consts.SetSExpire:
+			if expired {
+				g.clearItems(key)
+			}
+		}
+	case consts.ZSet:
+		switch e.GetMark() {
+		case consts.ZSetZAdd:
+			g.setItem(key, string(e.Meta.Value), &loc)
+		case consts.ZSetZRem:
+			g.setItem(key, string(e.Meta.Value), nil)
+		case consts.ZSetZClear:
+			g.clearItems(key)
+		case consts.ZSetZExpire:
+			if expired {
+				g.clearItems(key)
+			}
+		}
+	case consts.List:
+		switch e.GetMark() {
+		case consts.ListLClear:
+			g.clearList(key)
+		case consts.ListLExpire:
+			if expired {
+				g.clearList(key)
+			}
+		default:
+			g.addListEntry(key, loc)
+		}
+	}
+}
+
+// 重写单个文件时 entry 是否需要保留
+// 被覆盖的 entry 可以删除，删除标记（Rem、Clear、Expire 等）需要保留，否则更早的文件中的数据会在重启后恢复
+func (db *DB) liveEntry(e *storage.Entry, fileId uint32, offset int64) bool {
+	// uncommitted entry is invalid.
+	// 提交标记需要保留，事务的 entry 可能在上一个文件中
+	if e.IsCommit() {
+		return true
+	}
+	if e.TxId != 0 && db.txnMeta.isAborted(e.TxId) {
+		return false
+	}
+
+	loc := entryLoc{fileId: fileId, offset: offset}
+	g := db.garbage[e.GetType()]
+	key := string(e.Meta.Key)
+	mark := e.GetMark()
+	switch e.GetType() {
+	case consts.String:
+		if mark == consts.StringRem {
+			return true
+		}
+		node := db.strIndex.idxList.Get(e.Meta.Key)
+		if node == nil {
+			// key 已经过期被删除，过期的标记需要保留
+			return mark == consts.StringExpire && e.Expire <= uint64(time.Now().Unix())
+		}
+		idx := node.Value().(*str.StrData)
+		return idx.FileId == fileId && idx.Offset == offset
+	case consts.Hash:
+		if mark == consts.HashHSet {
+			return g.isCurrent(key, string(e.Meta.Extra), loc)
+		}
+	case consts.Set:
+		if mark == consts.SetSAdd {
+			return g.isCurrent(key, string(e.Meta.Value), loc)
+		}
+	case consts.ZSet:
+		if mark == consts.ZSetZAdd {
+			return g.isCurrent(key, string(e.Meta.Value), loc)
+		}
+	case consts.List:
+		if mark != consts.ListLClear && mark != consts.ListLExpire {
+			return g.inList(key, loc)
+		}
+	}
+	return true
+}
+
+// FileGarbage 返回所有 dbfile 的垃圾统计，按数据类型和文件 id 排序
+func (db *DB) FileGarbage() []FileGarbage {
+	var stats []FileGarbage
+	for i := 0; i < consts.DataStructureNum; i++ {
+		stats = append(stats, db.typeGarbage(uint16(i))...)
+	}
+	return stats
+}
+
+func (db *DB) typeGarbage(dType consts.DataType) []FileGarbage {
+	unlock := db.lockMgr.RLock(dType)
+	defer unlock()
+
+	var stats []FileGarbage
+	add := func(df *storage.DBFile, active bool) {
+		fg := FileGarbage{
+			Type:      dType,
+			FileId:    df.Id,
+			Active:    active,
+			Size:      df.Offset - df.DataOffset(),
+			DeadBytes: db.garbage[dType].deadBytes(df.Id),
+		}
+		if fg.Size > 0 {
+			fg.Ratio = float64(fg.DeadBytes) / float64(fg.Size)
+		}
+		stats = append(stats, fg)
+	}
+	for _, df := range db.archFiles[dType] {
+		add(df, false)
+	}
+	if activeFile, err := db.getActiveFile(dType); err == nil {
+		add(activeFile, true)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].FileId < stats[j].FileId
+	})
+	return stats
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"log"
+	"sync"
+	"zeroDB/storage"
+)
+
+// hintWriter 在后台为归档的 dbfile 生成 hint 文件
+// 生成 hint 文件需要读取整个文件，不在持有类型写锁的时候进行
+type hintWriter struct {
+	gen     sync.Mutex // 生成 hint 文件时持有，删除 dbfile 前通过它等待正在进行的生成
+	mu      sync.Mutex // 保护 pending
+	pending []*storage.DBFile
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newHintWriter() *hintWriter {
+	w := &hintWriter{
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *hintWriter) run() {
+	defer close(w.done)
+	for {
+		select {
+		case <-w.wake:
+			w.flush()
+		case <-w.stop:
+			// 关闭前生成剩余的 hint 文件，下次启动时可以使用
+			w.flush()
+			return
+		}
+	}
+}
+
+// 为 df 生成 hint 文件，df 已经归档，不会再写入
+func (w *hintWriter) add(df *storage.DBFile) {
+	w.mu.Lock()
+	w.pending = append(w.pending, df)
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *hintWriter) flush() {
+	for {
+		w.gen.Lock()
+		w.mu.Lock()
+		if len(w.pending) == 0 {
+			w.mu.Unlock()
+			w.gen.Unlock()
+			return
+		}
+		df := w.pending[0]
+		w.pending = w.pending[1:]
+		w.mu.Unlock()
+
+		if err := storage.WriteHintFile(df); err != nil {
+			log.Printf("write hint file err: %+v", err)
+		}
+		w.gen.Unlock()
+	}
+}
+
+// 不再为 files 生成 hint 文件，并等待正在进行的生成完成，删除 dbfile 之前调用
+func (w *hintWriter) forget(files map[uint32]*storage.DBFile) {
+	w.gen.Lock()
+	defer w.gen.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	pending := w.pending[:0]
+	for _, df := range w.pending {
+		if files[df.Id] != df {
+			pending = append(pending, df)
+		}
+	}
+	w.pending = pending
+}
+
+// 停止后台生成，等待剩余的 hint 文件生成完成
+func (w *hintWriter) close() {
+	close(w.stop)
+	<-w.done
+}
+
+// 在后台为 df 生成 hint 文件，只读打开时没有 hintWriter，直接生成
+func (db *DB) writeHintFile(df *storage.DBFile) {
+	if db.hints != nil {
+		db.hints.add(df)
+		return
+	}
+	if err := storage.WriteHintFile(df); err != nil {
+		log.Printf("write hint file err: %+v", err)
+	}
+}
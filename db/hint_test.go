@@ -0,0 +1,77 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"zeroDB/global/consts"
+	"zeroDB/storage"
+)
+
+// 每个归档文件在关闭之后都有 hint 文件，被回收删除的文件没有
+func checkHintFiles(t *testing.T, dir string, archived []uint32, removed []uint32) {
+	for _, id := range archived {
+		if _, err := os.Stat(storage.HintFilePath(dir, id, consts.String)); err != nil {
+			t.Errorf("hint file of archived file %d: %v", id, err)
+		}
+	}
+	for _, id := range removed {
+		if _, err := os.Stat(storage.HintFilePath(dir, id, consts.String)); !os.IsNotExist(err) {
+			t.Errorf("hint file of removed file %d: %v", id, err)
+		}
+	}
+}
+
+func TestHintFilesWrittenInBackground(t *testing.T) {
+	dir := t.TempDir() + "/"
+	db, _ := openTxnTestDB(t, dir, 4<<10)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 200; i++ {
+			if err := db.Set(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d-%d", round, i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	oldIds := db.manifest.FileIds(consts.String)
+	active := activeFile(t, db, consts.String).Id
+	if len(oldIds) < 3 {
+		t.Fatalf("expected several string files, got %v", oldIds)
+	}
+
+	// 回收的文件可能还在等待生成 hint 文件
+	if _, err := db.ReclaimGarbage(); err != nil {
+		t.Fatal(err)
+	}
+	ids := db.manifest.FileIds(consts.String)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	kept := make(map[uint32]bool)
+	var archived, removed []uint32
+	for _, id := range ids {
+		kept[id] = true
+		if id != active {
+			archived = append(archived, id)
+		}
+	}
+	for _, id := range oldIds {
+		if !kept[id] {
+			removed = append(removed, id)
+		}
+	}
+	if len(removed) == 0 {
+		t.Fatal("expected reclaim to remove some files")
+	}
+	checkHintFiles(t, dir, archived, removed)
+
+	db, _ = openTxnTestDB(t, dir, 4<<10)
+	defer db.Close()
+	for i := 0; i < 200; i++ {
+		var val string
+		if err := db.Get(fmt.Sprintf("key-%d", i), &val); err != nil || val != fmt.Sprintf("value-2-%d", i) {
+			t.Fatalf("key-%d: got %q, err %v", i, val, err)
+		}
+	}
+}
@@ -1,9 +1,10 @@
 package db
 
 import (
+	"fmt"
 	"io"
 	"log"
-	"sort"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -11,6 +12,7 @@ import (
 	"zeroDB/datastructure/list"
 	str "zeroDB/datastructure/string"
 	"zeroDB/global/consts"
+	"zeroDB/global/dberror"
 	"zeroDB/global/utils"
 	"zeroDB/storage"
 )
@@ -24,14 +26,18 @@ func (db *DB) buildStringIndex(idx *str.StrData, entry *storage.Entry) {
 
 	switch entry.GetMark() {
 	case consts.StringSet:
+		// 和 Set 相同，覆盖 value 时清除过期时间
 		db.strIndex.idxList.Put(idx.Meta.Key, idx)
+		delete(db.expires[consts.String], string(idx.Meta.Key))
 	case consts.StringRem:
 		db.strIndex.idxList.Remove(idx.Meta.Key)
+		delete(db.expires[consts.String], string(idx.Meta.Key))
 	case consts.StringExpire:
-		if entry.Timestamp < uint64(time.Now().Unix()) {
+		if entry.Expire < uint64(time.Now().Unix()) {
 			db.strIndex.idxList.Remove(idx.Meta.Key)
+			delete(db.expires[consts.String], string(idx.Meta.Key))
 		} else {
-			db.expires[consts.String][string(idx.Meta.Key)] = int64(entry.Timestamp)
+			db.expires[consts.String][string(idx.Meta.Key)] = int64(entry.Expire)
 			db.strIndex.idxList.Put(idx.Meta.Key, idx)
 		}
 	case consts.StringPersist:
@@ -83,13 +89,15 @@ func (db *DB) buildListIndex(entry *storage.Entry) {
 			db.listIndex.indexes.LTrim(string(entry.Meta.Key), start, end)
 		}
 	case consts.ListLExpire:
-		if entry.Timestamp < uint64(time.Now().Unix()) {
+		if entry.Expire < uint64(time.Now().Unix()) {
 			db.listIndex.indexes.LClear(key)
+			delete(db.expires[consts.List], key)
 		} else {
-			db.expires[consts.List][key] = int64(entry.Timestamp)
+			db.expires[consts.List][key] = int64(entry.Expire)
 		}
 	case consts.ListLClear:
 		db.listIndex.indexes.LClear(key)
+		delete(db.expires[consts.List], key)
 	}
 }
 
@@ -107,11 +115,13 @@ func (db *DB) buildHashIndex(entry *storage.Entry) {
 		db.hashIndex.indexes.HDel(key, string(entry.Meta.Extra))
 	case consts.HashHClear:
 		db.hashIndex.indexes.HClear(key)
+		delete(db.expires[consts.Hash], key)
 	case consts.HashHExpire:
-		if entry.Timestamp < uint64(time.Now().Unix()) {
+		if entry.Expire < uint64(time.Now().Unix()) {
 			db.hashIndex.indexes.HClear(key)
+			delete(db.expires[consts.Hash], key)
 		} else {
-			db.expires[consts.Hash][key] = int64(entry.Timestamp)
+			db.expires[consts.Hash][key] = int64(entry.Expire)
 		}
 	}
 }
@@ -133,11 +143,13 @@ func (db *DB) buildSetIndex(entry *storage.Entry) {
 		db.setIndex.indexes.SMove(key, string(extra), entry.Meta.Value)
 	case consts.SetSClear:
 		db.setIndex.indexes.SClear(key)
+		delete(db.expires[consts.Set], key)
 	case consts.SetSExpire:
-		if entry.Timestamp < uint64(time.Now().Unix()) {
+		if entry.Expire < uint64(time.Now().Unix()) {
 			db.setIndex.indexes.SClear(key)
+			delete(db.expires[consts.Set], key)
 		} else {
-			db.expires[consts.Set][key] = int64(entry.Timestamp)
+			db.expires[consts.Set][key] = int64(entry.Expire)
 		}
 	}
 }
@@ -158,93 +170,200 @@ func (db *DB) buildZsetIndex(entry *storage.Entry) {
 		db.zsetIndex.indexes.ZRem(key, string(entry.Meta.Value))
 	case consts.ZSetZClear:
 		db.zsetIndex.indexes.ZClear(key)
+		delete(db.expires[consts.ZSet], key)
 	case consts.ZSetZExpire:
-		if entry.Timestamp < uint64(time.Now().Unix()) {
+		if entry.Expire < uint64(time.Now().Unix()) {
 			db.zsetIndex.indexes.ZClear(key)
+			delete(db.expires[consts.ZSet], key)
 		} else {
-			db.expires[consts.ZSet][key] = int64(entry.Timestamp)
+			db.expires[consts.ZSet][key] = int64(entry.Expire)
 		}
 	}
 }
 
 // 从dbfile中加载所有数据类型的index
+// 活跃文件末尾不完整的 entry 会被截断，归档文件中的损坏会返回错误
 func (db *DB) loadIdxFromFiles() error {
 	if db.archFiles == nil && db.activeFile == nil {
 		return nil
 	}
 
+	errs := make([]error, consts.DataStructureNum)
+	replays := make([]*txnReplay, consts.DataStructureNum)
 	wg := sync.WaitGroup{}
 	wg.Add(consts.DataStructureNum)
 	for dataType := 0; dataType < consts.DataStructureNum; dataType++ {
 		go func(dType uint16) {
 			defer wg.Done()
+			r := db.newLoadReplay()
+			replays[dType] = r
 
 			// archived files
-			var fileIds []int
 			dbFile := make(map[uint32]*storage.DBFile)
 			for k, v := range db.archFiles[dType] {
 				dbFile[k] = v
-				fileIds = append(fileIds, int(k))
 			}
 
 			// active file
+			// 只读打开时活跃文件可能还没有创建
 			activeFile, err := db.getActiveFile(dType)
-			if err != nil {
-				log.Fatalf("active file is nil, the db can not open.[%+v]", err)
+			if err == nil {
+				dbFile[activeFile.Id] = activeFile
+			} else if !db.readOnly {
+				errs[dType] = err
 				return
 			}
-			dbFile[activeFile.Id] = activeFile
-			fileIds = append(fileIds, int(activeFile.Id))
-
-			// load the db files in the order of created time.
-			sort.Ints(fileIds)
-			for i := 0; i < len(fileIds); i++ {
-				fid := uint32(fileIds[i])
-				df := dbFile[fid]
-				var offset int64 = 0
 
-				for offset <= db.config.BlockSize {
-					if e, err := df.Read(offset); err == nil {
-						idx := &str.StrData{
-							Meta:   e.Meta,
-							FileId: fid,
-							Offset: offset,
-						}
-						offset += int64(e.Size())
+			// load the db files in the order of manifest, the active file is the last one.
+			// 集合类型有有效的 checkpoint 时，只需要从 checkpoint 覆盖的位置开始重放
+			fileIds := db.manifest.FileIds(dType)
+			start, startOffset := 0, int64(-1)
+			if dType != consts.String {
+				if i, offset, ok := db.loadCheckpoint(dType, fileIds, dbFile); ok {
+					start, startOffset = i, offset
+				}
+			}
+			for i := start; i < len(fileIds); i++ {
+				df, ok := dbFile[fileIds[i]]
+				if !ok {
+					continue
+				}
+				isActive := i == len(fileIds)-1
 
-						if len(e.Meta.Key) > 0 {
-							if err := db.buildIndex(e, idx, true); err != nil {
-								log.Fatalf("a fatal err occurred, the db can not open.[%+v]", err)
-							}
+				offset, stale := df.DataOffset(), false
+				if i == start && startOffset >= 0 {
+					offset = startOffset
+				} else if !isActive {
+					// 归档文件优先从 hint 文件加载，hint 不存在或损坏时再完整读取 dbfile
+					var loaded bool
+					if loaded, stale, err = db.loadIdxFromHint(df, r); err != nil {
+						errs[dType] = err
+						return
+					}
+					if loaded {
+						continue
+					}
+				}
 
-							//save tx ids which are in actice files.
-							if i == len(fileIds)-1 && e.TxId != 0 {
-								db.txnMeta.ActiveTxIds.Store(e.TxId, struct{}{})
-							}
-						}
-					} else {
-						if err == io.EOF {
-							break
-						}
-						log.Fatalf("a fatal err occurred, the db can not open.[%+v]", err)
+				if err := db.loadIdxFromFile(df, offset, isActive, r); err != nil {
+					errs[dType] = err
+					return
+				}
+				// 损坏或者旧格式的 hint 文件在重放 dbfile 之后重新生成
+				if stale && !db.readOnly {
+					if err := storage.WriteHintFile(df); err != nil {
+						log.Printf("write hint file err: %+v", err)
 					}
 				}
 			}
+			errs[dType] = r.finish()
 		}(uint16(dataType))
 	}
 	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	// 写入多种类型的事务停在提交标记时，所有类型都读完之后才能确定是否提交
+	aborted, err := resolveTxnReplays(replays)
+	if err != nil {
+		return err
+	}
+	for _, re := range aborted {
+		if err = db.truncateCommit(re); err != nil {
+			return err
+		}
+	}
+	for _, r := range replays {
+		db.txnMeta.observe(r.maxTxId)
+		for txId := range r.torn {
+			db.txnMeta.abort(txId)
+		}
+	}
 	return nil
 }
 
-// 为不同类型数据建立内存索引 index
-func (db *DB) buildIndex(entry *storage.Entry, idx *str.StrData, isOpen bool) (err error) {
+// 打开时重放 entry 使用的 txnReplay，提交的 entry 建立 index，没有提交的 entry 计入 dead bytes
+func (db *DB) newLoadReplay() *txnReplay {
+	apply := func(e *storage.Entry, fileId uint32, offset int64) error {
+		if len(e.Meta.Key) == 0 {
+			return nil
+		}
+		idx := db.newStrData(e, fileId, offset)
+		db.trackEntry(e, fileId, offset)
+		return db.buildIndex(e, idx)
+	}
+	discard := func(e *storage.Entry, fileId uint32, offset int64) {
+		if !e.IsCommit() {
+			db.garbage[e.GetType()].addDead(fileId, int64(e.Size()))
+		}
+	}
+	return newTxnReplay(db.txnMeta.legacyTxIds, apply, discard)
+}
 
-	// uncommitted entry is invalid.
-	if entry.TxId != 0 && isOpen {
-		if _, ok := db.txnMeta.CommittedTxIds[entry.TxId]; !ok {
-			return
+// 从 offset 开始逐条读取 dbfile 中的 entry 建立 index
+func (db *DB) loadIdxFromFile(df *storage.DBFile, offset int64, isActive bool, r *txnReplay) error {
+	for {
+		e, err := df.Read(offset)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			// 活跃文件末尾的 entry 可能因为崩溃没有写完整，截断即可
+			if isActive && isTornWrite(df, offset, err) {
+				return db.truncateFile(df, offset, err)
+			}
+			return fmt.Errorf("%w: %s at offset %d: %v", dberror.ErrCorruptedFile, df.File.Name(), offset, err)
 		}
+
+		db.fileOpts.Blobs.AddRef(e)
+		if err := r.add(e, df, offset); err != nil {
+			return err
+		}
+		offset += int64(e.Size())
 	}
+}
+
+// 通过 hint 文件加载一个归档文件的 index，返回 false 表示需要完整读取 dbfile
+// stale 为 true 表示 hint 文件存在但是不可用，需要重新生成
+func (db *DB) loadIdxFromHint(df *storage.DBFile, r *txnReplay) (loaded, stale bool, err error) {
+	hints, err := storage.LoadHintFile(df)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, false, nil
+		}
+		log.Printf("load hint file of %09d.data.%s err: %+v, replay the db file instead", df.Id, storage.DBFileSuffixName[df.Type], err)
+		return false, true, nil
+	}
+
+	// 先把所有 entry 还原出来，避免 hint 读到一半失败时 index 已经被修改
+	// key-only 模式下 string 的 index 只需要 entry 的位置和大小，不读取 value
+	withValue := df.Type != consts.String || !db.config.KeyOnlyIndex
+	entries := make([]*storage.Entry, 0, len(hints))
+	for _, h := range hints {
+		e, err := df.ReadHint(h, withValue)
+		if err != nil {
+			log.Printf("read entry by hint of %09d.data.%s err: %+v, replay the db file instead", df.Id, storage.DBFileSuffixName[df.Type], err)
+			return false, true, nil
+		}
+		entries = append(entries, e)
+	}
+
+	for i, e := range entries {
+		db.fileOpts.Blobs.AddRef(e)
+		if err := r.add(e, df, hints[i].Offset); err != nil {
+			return false, false, err
+		}
+	}
+	return true, false, nil
+}
+
+// 为不同类型数据建立内存索引 index
+// 打开时没有提交的事务的 entry 在重放时已经被丢弃，见 txnReplay
+func (db *DB) buildIndex(entry *storage.Entry, idx *str.StrData) (err error) {
 	switch entry.GetType() {
 	case consts.String:
 		db.buildStringIndex(idx, entry)
@@ -26,6 +26,9 @@ func (db *DB) HGet(key, field []byte) []byte {
 
 // sets field in the hash stored at key to value.
 func (db *DB) HSet(key []byte, field []byte, value []byte) (res int, err error) {
+	if db.readOnly {
+		return 0, dberror.ErrReadOnly
+	}
 	if err = db.checkKeyValue(key, value); err != nil {
 		return
 	}
@@ -35,9 +38,13 @@ func (db *DB) HSet(key []byte, field []byte, value []byte) (res int, err error)
 		return
 	}
 
+	defer db.waitSync(&err)
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
+	if err = db.recordWrite(consts.Hash, key); err != nil {
+		return
+	}
 	e := storage.NewEntry(key, value, field, consts.Hash, consts.HashHSet)
 	if err = db.store(e); err != nil {
 		return
@@ -49,12 +56,19 @@ func (db *DB) HSet(key []byte, field []byte, value []byte) (res int, err error)
 
 // sets field in the hash stored at key to value, only if field does not yet exist.
 func (db *DB) HSetNx(key, field, value []byte) (res int, err error) {
+	if db.readOnly {
+		return 0, dberror.ErrReadOnly
+	}
 	if err = db.checkKeyValue(key, value); err != nil {
 		return
 	}
+	defer db.waitSync(&err)
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
+	if err = db.recordWrite(consts.Hash, key); err != nil {
+		return
+	}
 	if res = db.hashIndex.indexes.HSetNx(string(key), string(field), value); res == 1 {
 		e := storage.NewEntry(key, value, field, consts.Hash, consts.HashHSet)
 		if err = db.store(e); err != nil {
@@ -80,6 +94,9 @@ func (db *DB) HGetAll(key []byte) [][]byte {
 
 // removes the specified fields from the hash stored at key.
 func (db *DB) HDel(key []byte, field ...[]byte) (res int, err error) {
+	if db.readOnly {
+		return 0, dberror.ErrReadOnly
+	}
 	if err = db.checkKeyValue(key, nil); err != nil {
 		return
 	}
@@ -87,10 +104,14 @@ func (db *DB) HDel(key []byte, field ...[]byte) (res int, err error) {
 		return
 	}
 
+	defer db.waitSync(&err)
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
 	for _, f := range field {
+		if err = db.recordWrite(consts.Hash, key); err != nil {
+			return
+		}
 		if ok := db.hashIndex.indexes.HDel(string(key), string(f)); ok == 1 {
 			//remove it
 			e := storage.NewEntry(key, nil, f, consts.Hash, consts.HashHDel)
@@ -184,6 +205,9 @@ func (db *DB) HVals(key []byte) (val [][]byte) {
 
 // HClear clear the key in hash.
 func (db *DB) HClear(key []byte) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	if err = db.checkKeyValue(key, nil); err != nil {
 		return
 	}
@@ -192,9 +216,13 @@ func (db *DB) HClear(key []byte) (err error) {
 		return dberror.ErrKeyNotExist
 	}
 
+	defer db.waitSync(&err)
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
+	if err := db.recordWrite(consts.Hash, key); err != nil {
+		return err
+	}
 	e := storage.NewEntryNoExtra(key, nil, consts.HashHClear, consts.Hash)
 	if err := db.store(e); err != nil {
 		return err
@@ -207,6 +235,9 @@ func (db *DB) HClear(key []byte) (err error) {
 
 // HExpire set expired time for a hash key.
 func (db *DB) HExpire(key []byte, duration int64) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	if duration <= 0 {
 		return dberror.ErrInvalidTTL
 	}
@@ -217,11 +248,15 @@ func (db *DB) HExpire(key []byte, duration int64) (err error) {
 		return dberror.ErrKeyNotExist
 	}
 
+	defer db.waitSync(&err)
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
+	if err = db.recordWrite(consts.Hash, key); err != nil {
+		return
+	}
 	deadline := time.Now().Unix() + duration
-	e := storage.NewEntryWithExpire(key, nil, deadline, consts.Hash, consts.Hash)
+	e := storage.NewEntryWithExpire(key, nil, deadline, consts.Hash, consts.HashHExpire)
 	if err := db.store(e); err != nil {
 		return err
 	}
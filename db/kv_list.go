@@ -14,14 +14,21 @@ import (
 // insert all the specified values at the head of the list stored at key.
 // If key does not exist, it is created as empty list before performing the push operations.
 func (db *DB) LPush(key []byte, values ...[]byte) (res int, err error) {
+	if db.readOnly {
+		return 0, dberror.ErrReadOnly
+	}
 	if err = db.checkKeyValue(key, values...); err != nil {
 		return
 	}
 
+	defer db.waitSync(&err)
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
 	for _, val := range values {
+		if err = db.recordWrite(consts.List, key); err != nil {
+			return
+		}
 		e := storage.NewEntryNoExtra(key, val, consts.List, consts.ListLPush)
 		if err = db.store(e); err != nil {
 			return
@@ -35,14 +42,21 @@ func (db *DB) LPush(key []byte, values ...[]byte) (res int, err error) {
 // RPush insert all the specified values at the tail of the list stored at key.
 // If key does not exist, it is created as empty list before performing the push operation.
 func (db *DB) RPush(key []byte, values ...[]byte) (res int, err error) {
+	if db.readOnly {
+		return 0, dberror.ErrReadOnly
+	}
 	if err = db.checkKeyValue(key, values...); err != nil {
 		return
 	}
 
+	defer db.waitSync(&err)
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
 	for _, val := range values {
+		if err = db.recordWrite(consts.List, key); err != nil {
+			return
+		}
 		e := storage.NewEntryNoExtra(key, val, consts.List, consts.ListRPush)
 		if err = db.store(e); err != nil {
 			return
@@ -54,11 +68,15 @@ func (db *DB) RPush(key []byte, values ...[]byte) (res int, err error) {
 }
 
 // LPop removes and returns the first elements of the list stored at key.
-func (db *DB) LPop(key []byte) ([]byte, error) {
+func (db *DB) LPop(key []byte) (val []byte, err error) {
+	if db.readOnly {
+		return nil, dberror.ErrReadOnly
+	}
 	if err := db.checkKeyValue(key, nil); err != nil {
 		return nil, err
 	}
 
+	defer db.waitSync(&err)
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
@@ -66,7 +84,10 @@ func (db *DB) LPop(key []byte) ([]byte, error) {
 		return nil, dberror.ErrKeyExpired
 	}
 
-	val := db.listIndex.indexes.LPop(string(key))
+	if err := db.recordWrite(consts.List, key); err != nil {
+		return nil, err
+	}
+	val = db.listIndex.indexes.LPop(string(key))
 	if val != nil {
 		e := storage.NewEntryNoExtra(key, val, consts.List, consts.ListLPop)
 		if err := db.store(e); err != nil {
@@ -77,11 +98,15 @@ func (db *DB) LPop(key []byte) ([]byte, error) {
 }
 
 // Removes and returns the last elements of the list stored at key.
-func (db *DB) RPop(key []byte) ([]byte, error) {
+func (db *DB) RPop(key []byte) (val []byte, err error) {
+	if db.readOnly {
+		return nil, dberror.ErrReadOnly
+	}
 	if err := db.checkKeyValue(key, nil); err != nil {
 		return nil, err
 	}
 
+	defer db.waitSync(&err)
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
@@ -89,7 +114,10 @@ func (db *DB) RPop(key []byte) ([]byte, error) {
 		return nil, dberror.ErrKeyExpired
 	}
 
-	val := db.listIndex.indexes.RPop(string(key))
+	if err := db.recordWrite(consts.List, key); err != nil {
+		return nil, err
+	}
+	val = db.listIndex.indexes.RPop(string(key))
 	if val != nil {
 		e := storage.NewEntryNoExtra(key, val, consts.List, consts.ListRPop)
 		if err := db.store(e); err != nil {
@@ -119,11 +147,15 @@ func (db *DB) LIndex(key []byte, idx int) []byte {
 // count > 0: Remove elements equal to element moving from head to tail.
 // count < 0: Remove elements equal to element moving from tail to head.
 // count = 0: Remove all elements equal to element.
-func (db *DB) LRem(key, value []byte, count int) (int, error) {
+func (db *DB) LRem(key, value []byte, count int) (res int, err error) {
+	if db.readOnly {
+		return 0, dberror.ErrReadOnly
+	}
 	if err := db.checkKeyValue(key, value); err != nil {
 		return 0, nil
 	}
 
+	defer db.waitSync(&err)
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
@@ -131,7 +163,10 @@ func (db *DB) LRem(key, value []byte, count int) (int, error) {
 		return 0, dberror.ErrKeyExpired
 	}
 
-	res := db.listIndex.indexes.LRem(string(key), value, count)
+	if err := db.recordWrite(consts.List, key); err != nil {
+		return 0, err
+	}
+	res = db.listIndex.indexes.LRem(string(key), value, count)
 	if res > 0 {
 		c := strconv.Itoa(count)
 		e := storage.NewEntry(key, value, []byte(c), consts.List, consts.ListLRem)
@@ -144,6 +179,9 @@ func (db *DB) LRem(key, value []byte, count int) (int, error) {
 
 // LInsert inserts element in the list stored at key either before or after the reference value pivot.
 func (db *DB) LInsert(key string, option list.InsertOption, pivot, val []byte) (count int, err error) {
+	if db.readOnly {
+		return 0, dberror.ErrReadOnly
+	}
 	if err = db.checkKeyValue([]byte(key), val); err != nil {
 		return
 	}
@@ -152,9 +190,13 @@ func (db *DB) LInsert(key string, option list.InsertOption, pivot, val []byte) (
 		return 0, dberror.ErrExtraContainsSeparator
 	}
 
+	defer db.waitSync(&err)
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
+	if err = db.recordWrite(consts.List, []byte(key)); err != nil {
+		return
+	}
 	count = db.listIndex.indexes.LInsert(key, option, pivot, val)
 	if count != -1 {
 		var buf bytes.Buffer
@@ -174,13 +216,20 @@ func (db *DB) LInsert(key string, option list.InsertOption, pivot, val []byte) (
 // LSet sets the list element at index to element.
 // returns whether is successful.
 func (db *DB) LSet(key []byte, idx int, val []byte) (ok bool, err error) {
+	if db.readOnly {
+		return false, dberror.ErrReadOnly
+	}
 	if err := db.checkKeyValue(key, val); err != nil {
 		return false, err
 	}
 
+	defer db.waitSync(&err)
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
+	if err = db.recordWrite(consts.List, key); err != nil {
+		return
+	}
 	if ok = db.listIndex.indexes.LSet(string(key), idx, val); ok {
 		i := strconv.Itoa(idx)
 		e := storage.NewEntry(key, val, []byte(i), consts.List, consts.ListLSet)
@@ -193,11 +242,15 @@ func (db *DB) LSet(key []byte, idx int, val []byte) (ok bool, err error) {
 
 // LTrim trim an existing list so that it will contain only the specified range of elements specified.
 // Both start and stop are zero-based indexes, where 0 is the first element of the list (the head), 1 the next element and so on.
-func (db *DB) LTrim(key []byte, start, end int) error {
+func (db *DB) LTrim(key []byte, start, end int) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	if err := db.checkKeyValue(key, nil); err != nil {
 		return err
 	}
 
+	defer db.waitSync(&err)
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
@@ -205,6 +258,9 @@ func (db *DB) LTrim(key []byte, start, end int) error {
 		return dberror.ErrKeyExpired
 	}
 
+	if err := db.recordWrite(consts.List, key); err != nil {
+		return err
+	}
 	if res := db.listIndex.indexes.LTrim(string(key), start, end); res {
 		var buf bytes.Buffer
 		buf.Write([]byte(strconv.Itoa(start)))
@@ -283,6 +339,9 @@ func (db *DB) LValExists(key []byte, val []byte) (ok bool) {
 
 // LClear clear a specified key.
 func (db *DB) LClear(key []byte) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	if err = db.checkKeyValue(key, nil); err != nil {
 		return
 	}
@@ -291,9 +350,13 @@ func (db *DB) LClear(key []byte) (err error) {
 		return dberror.ErrKeyNotExist
 	}
 
+	defer db.waitSync(&err)
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
+	if err = db.recordWrite(consts.List, key); err != nil {
+		return
+	}
 	e := storage.NewEntryNoExtra(key, nil, consts.List, consts.ListLClear)
 	if err = db.store(e); err != nil {
 		return err
@@ -306,6 +369,9 @@ func (db *DB) LClear(key []byte) (err error) {
 
 // LExpire set expired time for a specified key of List.
 func (db *DB) LExpire(key []byte, duration int64) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	if duration <= 0 {
 		return dberror.ErrInvalidTTL
 	}
@@ -313,9 +379,13 @@ func (db *DB) LExpire(key []byte, duration int64) (err error) {
 		return dberror.ErrKeyNotExist
 	}
 
+	defer db.waitSync(&err)
 	db.listIndex.mu.Lock()
 	defer db.listIndex.mu.Unlock()
 
+	if err = db.recordWrite(consts.List, key); err != nil {
+		return
+	}
 	deadline := time.Now().Unix() + duration
 	e := storage.NewEntryWithExpire(key, nil, deadline, consts.List, consts.ListLExpire)
 	if err = db.store(e); err != nil {
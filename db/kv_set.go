@@ -11,16 +11,23 @@ import (
 // Specified members that are already a member of this set are ignored.
 // If key does not exist, a new set is created before adding the specified members.
 func (db *DB) SAdd(key []byte, members ...[]byte) (res int, err error) {
+	if db.readOnly {
+		return 0, dberror.ErrReadOnly
+	}
 	if err = db.checkKeyValue(key, members...); err != nil {
 		return
 	}
 
+	defer db.waitSync(&err)
 	db.setIndex.mu.Lock()
 	defer db.setIndex.mu.Unlock()
 
 	for _, m := range members {
 		exist := db.setIndex.indexes.SIsMember(string(key), m)
 		if !exist {
+			if err = db.recordWrite(consts.Set, key); err != nil {
+				return
+			}
 			e := storage.NewEntryNoExtra(key, m, consts.Set, consts.SetSAdd)
 			if err = db.store(e); err != nil {
 				return
@@ -33,10 +40,14 @@ func (db *DB) SAdd(key []byte, members ...[]byte) (res int, err error) {
 
 // SPop removes and returns one or more random members from the set value store at key.
 func (db *DB) SPop(key []byte, count int) (values [][]byte, err error) {
+	if db.readOnly {
+		return nil, dberror.ErrReadOnly
+	}
 	if err = db.checkKeyValue(key, nil); err != nil {
 		return
 	}
 
+	defer db.waitSync(&err)
 	db.setIndex.mu.Lock()
 	defer db.setIndex.mu.Unlock()
 
@@ -44,6 +55,9 @@ func (db *DB) SPop(key []byte, count int) (values [][]byte, err error) {
 		return nil, dberror.ErrKeyExpired
 	}
 
+	if err = db.recordWrite(consts.Set, key); err != nil {
+		return
+	}
 	values = db.setIndex.indexes.SPop(string(key), count)
 	for _, v := range values {
 		e := storage.NewEntryNoExtra(key, v, consts.Set, consts.SetSRem)
@@ -82,10 +96,14 @@ func (db *DB) SRandMember(key []byte, count int) [][]byte {
 // Specified members that are not a member of this set are ignored.
 // If key does not exist, it is treated as an empty set and this command returns 0.
 func (db *DB) SRem(key []byte, members ...[]byte) (res int, err error) {
+	if db.readOnly {
+		return 0, dberror.ErrReadOnly
+	}
 	if err = db.checkKeyValue(key, members...); err != nil {
 		return
 	}
 
+	defer db.waitSync(&err)
 	db.setIndex.mu.Lock()
 	defer db.setIndex.mu.Unlock()
 
@@ -94,6 +112,9 @@ func (db *DB) SRem(key []byte, members ...[]byte) (res int, err error) {
 	}
 
 	for _, m := range members {
+		if err = db.recordWrite(consts.Set, key); err != nil {
+			return
+		}
 		if ok := db.setIndex.indexes.SRem(string(key), m); ok {
 			e := storage.NewEntryNoExtra(key, m, consts.Set, consts.SetSRem)
 			if err = db.store(e); err != nil {
@@ -106,7 +127,11 @@ func (db *DB) SRem(key []byte, members ...[]byte) (res int, err error) {
 }
 
 // SMove move member from the set at source to the set at destination.
-func (db *DB) SMove(src, dst, member []byte) error {
+func (db *DB) SMove(src, dst, member []byte) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
+	defer db.waitSync(&err)
 	db.setIndex.mu.Lock()
 	defer db.setIndex.mu.Unlock()
 
@@ -117,6 +142,14 @@ func (db *DB) SMove(src, dst, member []byte) error {
 		return dberror.ErrKeyExpired
 	}
 
+	if !db.setIndex.indexes.SIsMember(string(src), member) {
+		return nil
+	}
+	for _, key := range [][]byte{src, dst} {
+		if err := db.recordWrite(consts.Set, key); err != nil {
+			return err
+		}
+	}
 	if ok := db.setIndex.indexes.SMove(string(src), string(dst), member); ok {
 		e := storage.NewEntry(src, member, dst, consts.Set, consts.SetSMove)
 		if err := db.store(e); err != nil {
@@ -215,13 +248,20 @@ func (db *DB) SKeyExists(key []byte) (ok bool) {
 
 // SClear clear the specified key in set.
 func (db *DB) SClear(key []byte) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	if !db.SKeyExists(key) {
 		return dberror.ErrKeyNotExist
 	}
 
+	defer db.waitSync(&err)
 	db.setIndex.mu.Lock()
 	defer db.setIndex.mu.Unlock()
 
+	if err = db.recordWrite(consts.Set, key); err != nil {
+		return
+	}
 	e := storage.NewEntryNoExtra(key, nil, consts.Set, consts.SetSClear)
 	if err = db.store(e); err != nil {
 		return
@@ -233,6 +273,9 @@ func (db *DB) SClear(key []byte) (err error) {
 
 // SExpire set expired time for the key in set.
 func (db *DB) SExpire(key []byte, duration int64) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	if duration <= 0 {
 		return dberror.ErrInvalidTTL
 	}
@@ -240,9 +283,13 @@ func (db *DB) SExpire(key []byte, duration int64) (err error) {
 		return dberror.ErrKeyNotExist
 	}
 
+	defer db.waitSync(&err)
 	db.setIndex.mu.Lock()
 	defer db.setIndex.mu.Unlock()
 
+	if err = db.recordWrite(consts.Set, key); err != nil {
+		return
+	}
 	deadline := time.Now().Unix() + duration
 	e := storage.NewEntryWithExpire(key, nil, deadline, consts.Set, consts.SetSExpire)
 	if err = db.store(e); err != nil {
@@ -15,6 +15,9 @@ import (
 // Set set key to hold the string value. If key already holds a value, it is overwritten.
 // Any previous time to live associated with the key is discarded on successful Set operation.
 func (db *DB) Set(key, value interface{}) error {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	encKey, encVal, err := db.encode(key, value)
 	if err != nil {
 		return err
@@ -25,6 +28,9 @@ func (db *DB) Set(key, value interface{}) error {
 // SetNx is short for "Set if not exists", set key to hold string value if key does not exist.
 // In that case, it is equal to Set. When key already holds a value, no operation is performed.
 func (db *DB) SetNx(key, value interface{}) (ok bool, err error) {
+	if db.readOnly {
+		return false, dberror.ErrReadOnly
+	}
 	encKey, encVal, err := db.encode(key, value)
 	if err != nil {
 		return false, err
@@ -41,6 +47,9 @@ func (db *DB) SetNx(key, value interface{}) (ok bool, err error) {
 
 // SetEx set key to hold the string value and set key to timeout after a given number of seconds.
 func (db *DB) SetEx(key, value interface{}, duration int64) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	if duration <= 0 {
 		return dberror.ErrInvalidTTL
 	}
@@ -50,9 +59,13 @@ func (db *DB) SetEx(key, value interface{}, duration int64) (err error) {
 		return err
 	}
 
+	defer db.waitSync(&err)
 	db.strIndex.mu.Lock()
 	defer db.strIndex.mu.Unlock()
 
+	if err = db.recordWrite(consts.String, encKey); err != nil {
+		return
+	}
 	deadline := time.Now().Unix() + duration
 	e := storage.NewEntryWithExpire(encKey, encVal, deadline, consts.String, consts.StringExpire)
 	if err = db.store(e); err != nil {
@@ -96,6 +109,9 @@ func (db *DB) Get(key, dest interface{}) error {
 // GetSet set key to value and returns the old value stored at key.
 // If the key not exist, return an err.
 func (db *DB) GetSet(key, value, dest interface{}) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	err = db.Get(key, dest)
 	if err != nil && err != dberror.ErrKeyNotExist && err != dberror.ErrKeyExpired {
 		return
@@ -106,6 +122,9 @@ func (db *DB) GetSet(key, value, dest interface{}) (err error) {
 // Append if key already exists and is a string, this command appends the value at the end of the string.
 // If key does not exist it is created and set as an empty string, so Append will be similar to Set in this special case.
 func (db *DB) Append(key interface{}, value string) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	encKey, encVal, err := db.encode(key, value)
 	if err != nil {
 		return err
@@ -145,7 +164,10 @@ func (db *DB) StrExists(key interface{}) bool {
 }
 
 // Remove remove the value stored at key.
-func (db *DB) Remove(key interface{}) error {
+func (db *DB) Remove(key interface{}) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	encKey, err := utils.EncodeKey(key)
 	if err != nil {
 		return err
@@ -154,9 +176,13 @@ func (db *DB) Remove(key interface{}) error {
 		return err
 	}
 
+	defer db.waitSync(&err)
 	db.strIndex.mu.Lock()
 	defer db.strIndex.mu.Unlock()
 
+	if err := db.recordWrite(consts.String, encKey); err != nil {
+		return err
+	}
 	e := storage.NewEntryNoExtra(encKey, nil, consts.String, consts.StringRem)
 	if err := db.store(e); err != nil {
 		return err
@@ -197,7 +223,9 @@ func (db *DB) PrefixScan(prefix string, limit, offset int) (val []interface{}, e
 		var value interface{}
 
 		if item != nil {
-			value = item.Meta.Value
+			if value, err = db.strValue(item); err != nil {
+				return nil, err
+			}
 		}
 
 		// Check if the key is expired.
@@ -237,7 +265,9 @@ func (db *DB) RangeScan(start, end interface{}) (val []interface{}, err error) {
 
 		var value interface{}
 
-		value = node.Value().(*str.StrData).Meta.Value
+		if value, err = db.strValue(node.Value().(*str.StrData)); err != nil {
+			return nil, err
+		}
 
 		val = append(val, value)
 		node = node.Next()
@@ -247,6 +277,9 @@ func (db *DB) RangeScan(start, end interface{}) (val []interface{}, err error) {
 
 // Expire set the expiration time of the key.
 func (db *DB) Expire(key interface{}, duration int64) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	encKey, err := utils.EncodeKey(key)
 	if err != nil {
 		return err
@@ -255,6 +288,7 @@ func (db *DB) Expire(key interface{}, duration int64) (err error) {
 		return dberror.ErrInvalidTTL
 	}
 
+	defer db.waitSync(&err)
 	db.strIndex.mu.Lock()
 	defer db.strIndex.mu.Unlock()
 
@@ -263,23 +297,34 @@ func (db *DB) Expire(key interface{}, duration int64) (err error) {
 		return
 	}
 
+	if err = db.recordWrite(consts.String, encKey); err != nil {
+		return
+	}
 	deadline := time.Now().Unix() + duration
 	e := storage.NewEntryWithExpire(encKey, value, deadline, consts.String, consts.StringExpire)
 	if err = db.store(e); err != nil {
 		return err
 	}
 
+	// 和启动时加载一样，index 指向最新的 entry
+	if err = db.setStrData(e); err != nil {
+		return
+	}
 	db.expires[consts.String][string(encKey)] = deadline
 	return
 }
 
 // Persist clear expiration time.
 func (db *DB) Persist(key interface{}) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	var val interface{}
 	if err = db.Get(key, &val); err != nil {
 		return
 	}
 
+	defer db.waitSync(&err)
 	db.strIndex.mu.Lock()
 	defer db.strIndex.mu.Unlock()
 
@@ -287,10 +332,16 @@ func (db *DB) Persist(key interface{}) (err error) {
 	if err != nil {
 		return err
 	}
+	if err = db.recordWrite(consts.String, encKey); err != nil {
+		return
+	}
 	e := storage.NewEntryNoExtra(encKey, encVal, consts.String, consts.StringPersist)
 	if err = db.store(e); err != nil {
 		return
 	}
+	if err = db.setStrData(e); err != nil {
+		return
+	}
 
 	delete(db.expires[consts.String], string(encKey))
 	return
@@ -323,19 +374,25 @@ func (db *DB) setVal(key, value []byte) (err error) {
 		return err
 	}
 
+	defer db.waitSync(&err)
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	// 读取旧的 value 也需要持有写锁，getVal 可能删除过期的 key，key-only 模式下还会读取 dbfile
 	var existVal []byte
 	existVal, err = db.getVal(key)
 	if err != nil && err != dberror.ErrKeyExpired && err != dberror.ErrKeyNotExist {
 		return
 	}
+	err = nil
 
 	if bytes.Compare(existVal, value) == 0 {
 		return
 	}
 
-	db.strIndex.mu.Lock()
-	defer db.strIndex.mu.Unlock()
-
+	if err = db.recordWrite(consts.String, key); err != nil {
+		return
+	}
 	e := storage.NewEntryNoExtra(key, value, consts.String, consts.StringSet)
 
 	if err := db.store(e); err != nil {
@@ -363,18 +420,76 @@ func (db *DB) setStrData(e *storage.Entry) error {
 		return err
 	}
 	// string indexes, stored in skiplist.
-	idx := &str.StrData{
-		Meta: &storage.Meta{
-			Key:   e.Meta.Key,
-			Value: e.Meta.Value,
-		},
-		FileId: activeFile.Id,
-		Offset: activeFile.Offset - int64(e.Size()),
-	}
+	idx := db.newStrData(e, activeFile.Id, activeFile.Offset-int64(e.Size()))
 	db.strIndex.idxList.Put(idx.Meta.Key, idx)
 	return nil
 }
 
+// 根据 entry 生成 string index，key-only 模式下不保存 value
+func (db *DB) newStrData(e *storage.Entry, fileId uint32, offset int64) *str.StrData {
+	idx := &str.StrData{
+		Meta:   &storage.Meta{Key: e.Meta.Key},
+		FileId: fileId,
+		Offset: offset,
+		Size:   e.Size(),
+	}
+	if !db.config.KeyOnlyIndex {
+		idx.Meta.Value = e.Meta.Value
+	}
+	return idx
+}
+
+// 缓存中 value 的 key，同一个位置的 entry 不会改变，Reclaim 之后需要清空缓存
+type valueCacheKey struct {
+	fileId uint32
+	offset int64
+}
+
+// 返回 string index 对应的 value，key-only 模式下先查找缓存，没有时从 dbfile 中读取
+func (db *DB) strValue(idx *str.StrData) ([]byte, error) {
+	if !db.config.KeyOnlyIndex {
+		return idx.Meta.Value, nil
+	}
+
+	cacheKey := valueCacheKey{fileId: idx.FileId, offset: idx.Offset}
+	if db.valueCache != nil {
+		if value, ok := db.valueCache.Get(cacheKey); ok {
+			return value, nil
+		}
+	}
+
+	df, err := db.getStrFile(idx.FileId)
+	if err != nil {
+		return nil, err
+	}
+	e, err := df.Read(idx.Offset)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(e.Meta.Key, idx.Meta.Key) {
+		return nil, dberror.ErrCorruptedFile
+	}
+	if db.valueCache != nil {
+		db.valueCache.Put(cacheKey, e.Meta.Value)
+	}
+	return e.Meta.Value, nil
+}
+
+// 返回 string 的活跃文件或者归档文件
+func (db *DB) getStrFile(fileId uint32) (*storage.DBFile, error) {
+	activeFile, err := db.getActiveFile(consts.String)
+	if err != nil {
+		return nil, err
+	}
+	if activeFile.Id == fileId {
+		return activeFile, nil
+	}
+	if df, ok := db.archFiles[consts.String][fileId]; ok {
+		return df, nil
+	}
+	return nil, dberror.ErrDBFileNotFound
+}
+
 func (db *DB) getVal(key []byte) ([]byte, error) {
 	// Get index info from a skip list in memory.
 	node := db.strIndex.idxList.Get(key)
@@ -392,5 +507,5 @@ func (db *DB) getVal(key []byte) ([]byte, error) {
 		return nil, dberror.ErrKeyExpired
 	}
 
-	return idx.Meta.Value, nil
+	return db.strValue(idx)
 }
@@ -9,7 +9,10 @@ import (
 )
 
 // ZAdd adds the specified member with the specified score to the sorted set stored at key.
-func (db *DB) ZAdd(key []byte, score float64, member []byte) error {
+func (db *DB) ZAdd(key []byte, score float64, member []byte) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	if err := db.checkKeyValue(key, member); err != nil {
 		return err
 	}
@@ -19,9 +22,13 @@ func (db *DB) ZAdd(key []byte, score float64, member []byte) error {
 		return nil
 	}
 
+	defer db.waitSync(&err)
 	db.zsetIndex.mu.Lock()
 	defer db.zsetIndex.mu.Unlock()
 
+	if err := db.recordWrite(consts.ZSet, key); err != nil {
+		return err
+	}
 	extra := []byte(utils.Float64ToStr(score))
 	e := storage.NewEntry(key, member, extra, consts.ZSet, consts.ZSetZAdd)
 	if err := db.store(e); err != nil {
@@ -93,14 +100,21 @@ func (db *DB) ZRevRank(key, member []byte) int64 {
 // ZIncrBy increments the score of member in the sorted set stored at key by increment.
 // If member does not exist in the sorted set, it is added with increment as its score (as if its previous score was 0.0).
 // If key does not exist, a new sorted set with the specified member as its sole member is created.
-func (db *DB) ZIncrBy(key []byte, increment float64, member []byte) (float64, error) {
+func (db *DB) ZIncrBy(key []byte, increment float64, member []byte) (score float64, err error) {
+	if db.readOnly {
+		return 0, dberror.ErrReadOnly
+	}
 	if err := db.checkKeyValue(key, member); err != nil {
 		return increment, err
 	}
 
+	defer db.waitSync(&err)
 	db.zsetIndex.mu.Lock()
 	defer db.zsetIndex.mu.Unlock()
 
+	if err := db.recordWrite(consts.ZSet, key); err != nil {
+		return increment, err
+	}
 	increment = db.zsetIndex.indexes.ZIncrBy(string(key), increment, string(member))
 
 	extra := utils.Float64ToStr(increment)
@@ -183,10 +197,14 @@ func (db *DB) ZRevRangeWithScores(key []byte, start, stop int) []interface{} {
 // ZRem removes the specified members from the sorted set stored at key. Non existing members are ignored.
 // An error is returned when key exists and does not hold a sorted set.
 func (db *DB) ZRem(key, member []byte) (ok bool, err error) {
+	if db.readOnly {
+		return false, dberror.ErrReadOnly
+	}
 	if err = db.checkKeyValue(key, member); err != nil {
 		return
 	}
 
+	defer db.waitSync(&err)
 	db.zsetIndex.mu.Lock()
 	defer db.zsetIndex.mu.Unlock()
 
@@ -194,6 +212,9 @@ func (db *DB) ZRem(key, member []byte) (ok bool, err error) {
 		return
 	}
 
+	if err = db.recordWrite(consts.ZSet, key); err != nil {
+		return
+	}
 	if ok = db.zsetIndex.indexes.ZRem(string(key), string(member)); ok {
 		e := storage.NewEntryNoExtra(key, member, consts.ZSet, consts.ZSetZRem)
 		if err = db.store(e); err != nil {
@@ -283,13 +304,20 @@ func (db *DB) ZKeyExists(key []byte) (ok bool) {
 
 // ZClear clear the specified key in zset.
 func (db *DB) ZClear(key []byte) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	if !db.ZKeyExists(key) {
 		return dberror.ErrKeyNotExist
 	}
 
+	defer db.waitSync(&err)
 	db.zsetIndex.mu.Lock()
 	defer db.zsetIndex.mu.Unlock()
 
+	if err = db.recordWrite(consts.ZSet, key); err != nil {
+		return
+	}
 	e := storage.NewEntryNoExtra(key, nil, consts.ZSet, consts.ZSetZClear)
 	if err = db.store(e); err != nil {
 		return
@@ -300,6 +328,9 @@ func (db *DB) ZClear(key []byte) (err error) {
 
 // ZExpire set expired time for the key in zset.
 func (db *DB) ZExpire(key []byte, duration int64) (err error) {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
 	if duration <= 0 {
 		return dberror.ErrInvalidTTL
 	}
@@ -307,9 +338,13 @@ func (db *DB) ZExpire(key []byte, duration int64) (err error) {
 		return dberror.ErrKeyNotExist
 	}
 
+	defer db.waitSync(&err)
 	db.zsetIndex.mu.Lock()
 	defer db.zsetIndex.mu.Unlock()
 
+	if err = db.recordWrite(consts.ZSet, key); err != nil {
+		return
+	}
 	deadline := time.Now().Unix() + duration
 	e := storage.NewEntryWithExpire(key, nil, deadline, consts.ZSet, consts.ZSetZExpire)
 	if err = db.store(e); err != nil {
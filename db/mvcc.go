@@ -0,0 +1,373 @@
+package db
+
+import (
+	"sync"
+	"time"
+	"zeroDB/datastructure/hash"
+	"zeroDB/datastructure/list"
+	"zeroDB/datastructure/set"
+	str "zeroDB/datastructure/string"
+	"zeroDB/datastructure/zset"
+	"zeroDB/global/consts"
+	"zeroDB/global/dberror"
+)
+
+// 事务的快照隔离（MVCC）
+// 事务开始时从 TxnMeta.MaxTxId 分配 tx id 作为快照，版本小于 tx id 的修改对事务可见
+// 有活跃的事务时，每次修改 key 之前从同一个计数器分配版本，并在需要时保存 key 被修改之前的状态，
+// 事务读取快照之后被修改过的 key 时使用保存的状态；没有活跃的事务时不记录任何东西
+// 提交时要写入的 key 在事务开始之后被修改过则返回 ErrTxnConflict，冲突按 key 判断，不区分集合中的元素
+// Watcher 监视的 key 即使没有活跃的事务也记录版本，直到取消监视，见 watch.go
+
+type (
+	// key 在某次修改之前的状态
+	keyVersion struct {
+		version uint64 // 覆盖这个状态的修改的版本，快照不大于该版本的事务读到这个状态
+		exists  bool   // string 是否存在
+		value   []byte // string 的 value
+		expire  int64  // 过期时间，没有过期时间时为 0
+
+		// 集合类型只包含这个 key 的 index
+		list *list.List
+		hash *hash.Hash
+		set  *set.Set
+		zset *zset.SortedSet
+
+		// 集合类型在 garbage 中记录的 entry 的位置，生成 checkpoint 时使用
+		items map[string]itemLoc
+		locs  map[entryLoc]uint32
+	}
+
+	// 活跃事务的快照，以及这些快照之后被修改的 key
+	mvcc struct {
+		mu       sync.Mutex
+		active   map[uint64]struct{}                               // 活跃事务的 tx id
+		versions [consts.DataStructureNum]map[string]uint64        // key 最近一次被修改的版本
+		history  [consts.DataStructureNum]map[string][]*keyVersion // key 每次被修改之前的状态，版本递增
+		watched  map[string]int                                    // 被 Watcher 监视的 key 和监视它的 Watcher 数量
+	}
+)
+
+func newMVCC() *mvcc {
+	m := &mvcc{active: make(map[uint64]struct{}), watched: make(map[string]int)}
+	m.reset()
+	return m
+}
+
+// 清空所有版本，没有活跃的事务时调用，被监视的 key 的版本需要保留
+func (m *mvcc) reset() {
+	for i := 0; i < consts.DataStructureNum; i++ {
+		versions := make(map[string]uint64)
+		for key := range m.watched {
+			if version, ok := m.versions[i][key]; ok {
+				versions[key] = version
+			}
+		}
+		m.versions[i] = versions
+		m.history[i] = make(map[string][]*keyVersion)
+	}
+}
+
+// 开始一个事务，分配 tx id 并记录它的快照
+func (db *DB) beginTxn() uint64 {
+	m := db.mvcc
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	db.txnMeta.MaxTxId++
+	txId := db.txnMeta.MaxTxId
+	m.active[txId] = struct{}{}
+	return txId
+}
+
+// 事务结束，删除其他活跃事务都不再需要的版本
+func (db *DB) endTxn(txId uint64) {
+	m := db.mvcc
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.active[txId]; !ok {
+		return
+	}
+	delete(m.active, txId)
+	m.gc(txId)
+}
+
+// 删除快照都大于的版本，txId 是刚结束的事务，它不是最早的快照时没有可以删除的版本，调用者持有 m.mu
+func (m *mvcc) gc(txId uint64) {
+	if len(m.active) == 0 {
+		m.reset()
+		return
+	}
+	oldest := m.oldest()
+	if txId > oldest {
+		return
+	}
+	for dType := 0; dType < consts.DataStructureNum; dType++ {
+		for key, version := range m.versions[dType] {
+			if _, ok := m.watched[key]; !ok && version < oldest {
+				delete(m.versions[dType], key)
+			}
+		}
+		for key, kvs := range m.history[dType] {
+			i := 0
+			for i < len(kvs) && kvs[i].version < oldest {
+				i++
+			}
+			if i == len(kvs) {
+				delete(m.history[dType], key)
+			} else if i > 0 {
+				m.history[dType][key] = kvs[i:]
+			}
+		}
+	}
+}
+
+// 最早的活跃事务的 tx id，调用者持有 m.mu
+func (m *mvcc) oldest() uint64 {
+	oldest := ^uint64(0)
+	for txId := range m.active {
+		if txId < oldest {
+			oldest = txId
+		}
+	}
+	return oldest
+}
+
+// 在修改 key 之前调用，调用者持有该类型的写锁
+// 有活跃的事务或者 key 被监视时为这次修改分配版本，需要时保存 key 当前的状态
+func (db *DB) recordWrite(dType consts.DataType, key []byte) error {
+	m := db.mvcc
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.watched[string(key)]; !ok && len(m.active) == 0 {
+		return nil
+	}
+	db.txnMeta.MaxTxId++
+	return db.recordVersion(dType, string(key), db.txnMeta.MaxTxId)
+}
+
+// 提交事务的写入之前调用，调用者持有 keys 中所有类型的写锁
+// keys 中有 key 在事务开始之后被修改过，或者 watches 中的 key 在任何类型中的版本大于记录的版本时返回 ErrTxnConflict，否则为 keys 分配提交的版本
+func (db *DB) commitVersions(txId uint64, keys map[consts.DataType]map[string]struct{}, watches map[string]uint64) error {
+	m := db.mvcc
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for dType, typeKeys := range keys {
+		for key := range typeKeys {
+			if version, ok := m.versions[dType][key]; ok && version > txId {
+				return dberror.ErrTxnConflict
+			}
+		}
+	}
+	for key, watched := range watches {
+		if m.version(key) > watched {
+			return dberror.ErrTxnConflict
+		}
+	}
+
+	// 事务自己的快照不再需要
+	delete(m.active, txId)
+	defer m.gc(txId)
+	var version uint64
+	for dType, typeKeys := range keys {
+		for key := range typeKeys {
+			if _, ok := m.watched[key]; !ok && len(m.active) == 0 {
+				continue
+			}
+			if version == 0 {
+				db.txnMeta.MaxTxId++
+				version = db.txnMeta.MaxTxId
+			}
+			if err := db.recordVersion(dType, key, version); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// key 在所有类型中最近一次被修改的版本，没有记录时为 0，调用者持有 m.mu
+func (m *mvcc) version(key string) (version uint64) {
+	for dType := 0; dType < consts.DataStructureNum; dType++ {
+		if v := m.versions[dType][key]; v > version {
+			version = v
+		}
+	}
+	return
+}
+
+// 记录 key 在 version 被修改，调用者持有 m.mu 和该类型的写锁
+// 快照在上一次修改之后的事务读到的是 key 当前的状态，需要保存下来
+func (db *DB) recordVersion(dType consts.DataType, key string, version uint64) error {
+	m := db.mvcc
+	prev := m.versions[dType][key]
+	for txId := range m.active {
+		if txId > prev {
+			kv, err := db.keyState(dType, key)
+			if err != nil {
+				return err
+			}
+			kv.version = version
+			m.history[dType][key] = append(m.history[dType][key], kv)
+			break
+		}
+	}
+	m.versions[dType][key] = version
+	return nil
+}
+
+// 返回 key 在快照 snap 中的状态，snap 之后没有被修改过时返回 nil，调用者持有该类型的读锁
+func (db *DB) versionAt(dType consts.DataType, key string, snap uint64) *keyVersion {
+	m := db.mvcc
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if version, ok := m.versions[dType][key]; !ok || version < snap {
+		return nil
+	}
+	for _, kv := range m.history[dType][key] {
+		if kv.version > snap {
+			return kv
+		}
+	}
+	return nil
+}
+
+// 快照 snap 之后被修改过的 key，这些 key 在快照中的状态可能和 index 中不同，调用者持有该类型的读锁
+func (db *DB) changedKeys(dType consts.DataType, snap uint64) (keys []string) {
+	m := db.mvcc
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, version := range m.versions[dType] {
+		if version > snap {
+			keys = append(keys, key)
+		}
+	}
+	return
+}
+
+// 复制 key 当前的状态，调用者持有该类型的锁
+func (db *DB) keyState(dType consts.DataType, key string) (*keyVersion, error) {
+	kv := &keyVersion{expire: db.expires[dType][key]}
+	switch dType {
+	case consts.String:
+		if node := db.strIndex.idxList.Get([]byte(key)); node != nil {
+			value, err := db.strValue(node.Value().(*str.StrData))
+			if err != nil {
+				return nil, err
+			}
+			kv.exists, kv.value = true, value
+		}
+	case consts.List:
+		kv.list = list.New()
+		copyList(kv.list, db.listIndex.indexes, key)
+	case consts.Hash:
+		kv.hash = hash.New()
+		copyHash(kv.hash, db.hashIndex.indexes, key)
+	case consts.Set:
+		kv.set = set.New()
+		copySet(kv.set, db.setIndex.indexes, key)
+	case consts.ZSet:
+		kv.zset = zset.New()
+		copyZSet(kv.zset, db.zsetIndex.indexes, key)
+	}
+	if dType != consts.String {
+		kv.items, kv.locs = db.garbage[dType].keyLocs(key)
+	}
+	return kv, nil
+}
+
+// key 在事务快照中的状态，快照之后没有被修改过时返回 nil，调用者持有该类型的读锁
+func (tx *Txn) version(dType consts.DataType, key []byte) *keyVersion {
+	return tx.db.versionAt(dType, string(key), tx.id)
+}
+
+// key 在事务快照中的过期时间，调用者持有该类型的读锁
+func (tx *Txn) deadline(dType consts.DataType, key []byte) (deadline int64, ok bool) {
+	if kv := tx.version(dType, key); kv != nil {
+		return kv.expire, kv.expire != 0
+	}
+	deadline, ok = tx.db.expires[dType][string(key)]
+	return
+}
+
+// key 在事务快照中是否已经过期，只判断不写入删除记录，调用者持有该类型的读锁
+func (tx *Txn) expired(dType consts.DataType, key []byte) bool {
+	deadline, ok := tx.deadline(dType, key)
+	return ok && time.Now().Unix() > deadline
+}
+
+// key 在事务中是否已经过期，事务写入过 key 时使用 view，否则持有该类型的读锁判断快照
+func (tx *Txn) keyExpired(dType consts.DataType, key []byte) bool {
+	if tx.view.loaded(dType, key) {
+		return tx.view.expired(dType, key)
+	}
+	unlock := tx.db.lockMgr.RLock(dType)
+	defer unlock()
+	return tx.expired(dType, key)
+}
+
+// 事务快照中 string 的 value，调用者持有 string 的读锁
+func (tx *Txn) strValue(key []byte) ([]byte, error) {
+	if tx.expired(consts.String, key) {
+		return nil, dberror.ErrKeyExpired
+	}
+	if kv := tx.version(consts.String, key); kv != nil {
+		if !kv.exists {
+			return nil, dberror.ErrKeyNotExist
+		}
+		return kv.value, nil
+	}
+	node := tx.db.strIndex.idxList.Get(key)
+	if node == nil {
+		return nil, dberror.ErrKeyNotExist
+	}
+	return tx.db.strValue(node.Value().(*str.StrData))
+}
+
+// 事务快照中 string 是否存在，调用者持有 string 的读锁
+func (tx *Txn) strExists(key []byte) bool {
+	if tx.expired(consts.String, key) {
+		return false
+	}
+	if kv := tx.version(consts.String, key); kv != nil {
+		return kv.exists
+	}
+	return tx.db.strIndex.idxList.Exist(key)
+}
+
+// 事务快照中 key 所在的 list index，调用者持有 list 的读锁
+func (tx *Txn) listIndex(key []byte) *list.List {
+	if kv := tx.version(consts.List, key); kv != nil {
+		return kv.list
+	}
+	return tx.db.listIndex.indexes
+}
+
+// 事务快照中 key 所在的 hash index，调用者持有 hash 的读锁
+func (tx *Txn) hashIndex(key []byte) *hash.Hash {
+	if kv := tx.version(consts.Hash, key); kv != nil {
+		return kv.hash
+	}
+	return tx.db.hashIndex.indexes
+}
+
+// 事务快照中 key 所在的 set index，调用者持有 set 的读锁
+func (tx *Txn) setIndex(key []byte) *set.Set {
+	if kv := tx.version(consts.Set, key); kv != nil {
+		return kv.set
+	}
+	return tx.db.setIndex.indexes
+}
+
+// 事务快照中 key 所在的 zset index，调用者持有 zset 的读锁
+func (tx *Txn) zsetIndex(key []byte) *zset.SortedSet {
+	if kv := tx.version(consts.ZSet, key); kv != nil {
+		return kv.zset
+	}
+	return tx.db.zsetIndex.indexes
+}
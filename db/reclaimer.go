@@ -0,0 +1,286 @@
+package db
+
+import (
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"zeroDB/global/config"
+	"zeroDB/global/consts"
+	"zeroDB/global/dberror"
+	"zeroDB/storage"
+)
+
+const (
+	// 文件中 dead bytes 的比例默认超过一半时才重写
+	defaultReclaimGarbageRatio = 0.5
+
+	// 后台检查 dead bytes 的默认间隔
+	defaultReclaimInterval = time.Minute
+)
+
+type (
+	// reclaimer 在后台定时重写 dead bytes 比例超过阈值的归档文件
+	reclaimer struct {
+		db       *DB
+		interval time.Duration
+		window   *reclaimWindow
+		stop     chan struct{}
+		done     chan struct{}
+	}
+
+	// 一天中允许后台回收的时间段，分钟数，end 小于 start 时跨过零点
+	reclaimWindow struct {
+		start, end int
+	}
+)
+
+// 解析 "HH:MM-HH:MM" 格式的时间段，为空时返回 nil，表示任何时间都可以回收
+func parseReclaimWindow(s string) (*reclaimWindow, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, dberror.ErrInvalidReclaimWindow
+	}
+	start, err := parseClock(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, dberror.ErrInvalidReclaimWindow
+	}
+	return &reclaimWindow{start: start, end: end}, nil
+}
+
+// 将 "HH:MM" 转化为一天中的分钟数
+func parseClock(s string) (int, error) {
+	hm := strings.Split(strings.TrimSpace(s), ":")
+	if len(hm) != 2 {
+		return 0, dberror.ErrInvalidReclaimWindow
+	}
+	h, err := strconv.Atoi(hm[0])
+	if err != nil || h < 0 || h > 23 {
+		return 0, dberror.ErrInvalidReclaimWindow
+	}
+	m, err := strconv.Atoi(hm[1])
+	if err != nil || m < 0 || m > 59 {
+		return 0, dberror.ErrInvalidReclaimWindow
+	}
+	return h*60 + m, nil
+}
+
+// t 是否在时间段中，包含开始时间，不包含结束时间
+func (w *reclaimWindow) contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	m := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return m >= w.start && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+// 根据配置创建后台回收，没有开启时返回 nil
+func newReclaimer(db *DB, cfg config.Config) (*reclaimer, error) {
+	window, err := parseReclaimWindow(cfg.ReclaimWindow)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.AutoReclaim {
+		return nil, nil
+	}
+	interval := cfg.ReclaimInterval
+	if interval <= 0 {
+		interval = defaultReclaimInterval
+	}
+	return &reclaimer{
+		db:       db,
+		interval: interval,
+		window:   window,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func (r *reclaimer) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !r.window.contains(time.Now()) {
+				continue
+			}
+			if n, err := r.db.ReclaimGarbage(); err != nil {
+				log.Printf("background reclaim err: %+v", err)
+			} else if n > 0 {
+				log.Printf("background reclaim rewrote %d db files", n)
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// 停止后台回收，等待正在重写的文件完成
+func (r *reclaimer) close() {
+	close(r.stop)
+	<-r.done
+}
+
+// 文件被后台回收的 dead bytes 比例
+func (db *DB) garbageRatio() float64 {
+	if db.config.ReclaimGarbageRatio > 0 {
+		return db.config.ReclaimGarbageRatio
+	}
+	return defaultReclaimGarbageRatio
+}
+
+// ReclaimGarbage 逐个重写 dead bytes 比例达到 ReclaimGarbageRatio 的归档文件，返回重写的文件数量
+// 和 Reclaim 不同，只处理垃圾多的文件，重写一个文件时只在替换文件时阻塞该数据类型的读写
+func (db *DB) ReclaimGarbage() (reclaimed int, err error) {
+	if db.isClosed() {
+		return 0, dberror.ErrDBIsClosed
+	}
+	if db.readOnly {
+		return 0, dberror.ErrReadOnly
+	}
+	ratio := db.garbageRatio()
+	for _, fg := range db.FileGarbage() {
+		if fg.Active || fg.DeadBytes == 0 || fg.Ratio < ratio {
+			continue
+		}
+		var ok bool
+		if ok, err = db.reclaimFile(fg.Type, fg.FileId, ratio); err != nil {
+			return
+		}
+		if ok {
+			reclaimed++
+		}
+	}
+	return
+}
+
+// 再次检查文件的 dead bytes，Reclaim 可能已经重写了所有文件
+// 该类型正在 reclaim 时跳过这个文件
+func (db *DB) reclaimFile(dType consts.DataType, fileId uint32, ratio float64) (bool, error) {
+	if err := db.startReclaim(dType); err != nil {
+		if err == dberror.ErrDBisReclaiming {
+			err = nil
+		}
+		return false, err
+	}
+	defer db.finishReclaim(dType)
+
+	unlock := db.lockMgr.RLock(dType)
+	df, ok := db.archFiles[dType][fileId]
+	unlock()
+	if !ok {
+		return false, nil
+	}
+	size := df.Offset - df.DataOffset()
+	dead := db.garbage[dType].deadBytes(fileId)
+	if size <= 0 || dead == 0 || float64(dead)/float64(size) < ratio {
+		return false, nil
+	}
+	if err := db.compactFile(df); err != nil {
+		return false, err
+	}
+	_, err := db.fileOpts.Blobs.Collect()
+	return true, err
+}
+
+// 重写一个归档文件，去掉已经被覆盖的 entry
+// 新文件先写到临时目录中，使用新的 id，在 manifest 中替换旧文件的位置，所以 entry 的加载顺序不变
+// 和 reclaimType 一样，只在判断 entry 是否有效时持有读锁，替换文件时持有写锁
+func (db *DB) compactFile(df *storage.DBFile) (err error) {
+	var (
+		dType       = df.Type
+		fs          = db.fileOpts.FS
+		compactPath = db.config.DirPath + consts.CompactPath + storage.PathSeparator + storage.DBFileSuffixName[dType]
+		// 旧文件中每个 blob 文件被引用的次数，替换旧文件后释放
+		refs    = make(map[uint32]int64)
+		relocs  = make(map[entryLoc]entryLoc)
+		moves   []strMove
+		swapped bool
+	)
+	if err = fs.MkdirAll(compactPath, os.ModePerm); err != nil {
+		return
+	}
+	defer fs.RemoveAll(compactPath)
+
+	newFile, err := storage.NewDBFile(compactPath, db.manifest.NextFileId(dType), dType, db.fileOpts)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil && !swapped {
+			newFile.Close(false)
+		}
+	}()
+
+	offset := df.DataOffset()
+	for {
+		var e *storage.Entry
+		if e, err = df.ReadUnresolved(offset); err != nil {
+			if err == io.EOF {
+				err = nil
+				break
+			}
+			return
+		}
+		if blobId, ok := e.BlobFileId(); ok {
+			refs[blobId]++
+		}
+		size := int64(e.Size())
+
+		unlock := db.lockMgr.RLock(dType)
+		live := db.liveEntry(e, df.Id, offset)
+		unlock()
+		if !live {
+			offset += size
+			continue
+		}
+
+		// 保留 tx id，事务的 entry 和提交标记可能不在同一个文件中，见 txn_commit.go
+		if err = newFile.Write(e); err != nil {
+			return
+		}
+		from := entryLoc{fileId: df.Id, offset: offset}
+		to := entryLoc{fileId: newFile.Id, offset: newFile.Offset - int64(e.Size())}
+		relocs[from] = to
+		if dType == consts.String {
+			moves = append(moves, strMove{key: e.Meta.Key, from: from, to: to, size: e.Size()})
+		}
+		offset += size
+	}
+	if err = newFile.Sync(); err != nil {
+		return
+	}
+
+	unlock := db.lockMgr.Lock(dType)
+	defer unlock()
+
+	if err = db.swapFiles(dType, compactPath, []uint32{df.Id}, []*storage.DBFile{newFile}); err != nil {
+		return
+	}
+	swapped = true
+
+	db.moveStrIndex(dType, moves)
+	db.garbage[dType].relocate(map[uint32]struct{}{df.Id: {}}, relocs)
+	db.fileOpts.Blobs.Release(dType, refs)
+
+	db.writeHintFile(newFile)
+	return db.removeDBFiles(map[uint32]*storage.DBFile{df.Id: df})
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"zeroDB/global/dberror"
+	"zeroDB/storage"
+)
+
+type (
+	// RecoveryReport 记录 Open 时从文件末尾丢弃的不完整或损坏的数据
+	RecoveryReport struct {
+		mu        sync.Mutex
+		Truncated []TruncatedFile
+	}
+
+	// TruncatedFile 一个被截断的文件
+	TruncatedFile struct {
+		Path      string // 文件路径
+		ValidSize int64  // 截断后的大小，即最后一个有效 entry 的结尾
+		Discarded int64  // 被丢弃的字节数
+		Reason    error  // 丢弃的原因
+	}
+)
+
+// 是否有数据被丢弃
+func (r *RecoveryReport) HasTruncated() bool {
+	return r != nil && len(r.Truncated) > 0
+}
+
+func (r *RecoveryReport) add(t TruncatedFile) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Truncated = append(r.Truncated, t)
+}
+
+func (t TruncatedFile) String() string {
+	return fmt.Sprintf("%s: discarded %d bytes after offset %d (%v)", t.Path, t.Discarded, t.ValidSize, t.Reason)
+}
+
+// 判断读取 entry 时的错误是否发生在文件末尾
+// 进程在写入过程中崩溃时，只有最后一个 entry 会不完整
+func isTornWrite(df *storage.DBFile, offset int64, err error) bool {
+	if err == io.ErrUnexpectedEOF {
+		// header 中的大小超出了文件，也可能是中间的 entry 的 header 损坏，之后还有有效的 entry 时不能截断
+		found, scanErr := df.HasValidEntryAfter(offset + 1)
+		return scanErr == nil && !found
+	}
+	if err != dberror.ErrInvalidCrc {
+		return false
+	}
+	// crc 校验失败的 entry 必须是文件中的最后一个
+	e, decodeErr := df.ReadHeader(offset)
+	return decodeErr == nil && offset+int64(e.Size()) == df.Offset
+}
+
+// 将 dbfile 截断到 offset，丢弃之后的内容，通常是活跃文件中最后一个有效 entry 的结尾
+// 只读打开时不修改文件，只记录被忽略的部分
+func (db *DB) truncateFile(df *storage.DBFile, offset int64, reason error) error {
+	discarded := df.Offset - offset
+	path := df.File.Name()
+	if db.readOnly {
+		df.Offset = offset
+	} else if err := df.Truncate(offset); err != nil {
+		return err
+	}
+	db.recovery.add(TruncatedFile{
+		Path:      path,
+		ValidSize: offset,
+		Discarded: discarded,
+		Reason:    reason,
+	})
+	return nil
+}
+
+// 截断写入多种类型的事务在某个类型末尾的提交标记，这个事务在其他类型中没有提交标记
+// 新的活跃文件还没有写入时标记在最后一个归档文件中，它的 hint 文件中也有这个标记，需要删除
+func (db *DB) truncateCommit(re *replayEntry) error {
+	if active, err := db.getActiveFile(re.df.Type); !db.readOnly && (err != nil || active.Id != re.df.Id) {
+		if err = storage.RemoveHintFile(re.df.Path, re.df.Id, re.df.Type, db.fileOpts); err != nil {
+			return err
+		}
+	}
+	return db.truncateFile(re.df, re.offset, dberror.ErrTxnIncomplete)
+}
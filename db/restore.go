@@ -0,0 +1,184 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+	"zeroDB/global/config"
+	"zeroDB/global/consts"
+	"zeroDB/global/dberror"
+	"zeroDB/storage"
+)
+
+type (
+	// RestoreResult 时间点恢复的结果
+	RestoreResult struct {
+		Until    time.Time
+		Replayed int // 写入新目录的 entry 数量
+		Skipped  int // 晚于恢复时间、没有提交或者所在事务晚于恢复时间而跳过的 entry 数量，不包括提交标记
+	}
+
+	// 恢复时每种类型的重放进度
+	restoreCursor struct {
+		until uint64
+		last  uint64 // 上一个 entry 的创建时间
+	}
+)
+
+// RestoreToTime 只重放 cfg.DirPath 中创建时间不晚于 until 的 entry，把结果写入新的目录 dstDir，dstDir 必须不存在或者是空目录
+// 源目录以只读方式打开，可以是停止写入的数据目录或者 Backup 生成的备份，新目录使用 cfg 中除了 DirPath 之外的配置
+// 事务中有任何一个 entry 或者提交标记晚于 until 时整个事务都不恢复，没有提交标记的事务不恢复；旧版本文件中设置过期时间的 entry 没有创建时间，沿用同类型上一个 entry 的时间
+// 已经被 Reclaim 合并的历史无法恢复：集合类型重写后的 entry 的创建时间是 reclaim 的时间，string 被覆盖的旧值已经删除
+// 过期时间是绝对时间，恢复时已经过期的 key 不会出现在新目录中
+func RestoreToTime(cfg config.Config, dstDir string, until time.Time) (result *RestoreResult, err error) {
+	src, err := OpenReadOnly(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	fs := src.fileOpts.FS
+	if names, err := fs.ReadDir(dstDir); err == nil && len(names) > 0 {
+		return nil, dberror.ErrRestoreDirNotEmpty
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	dstCfg := cfg
+	dstCfg.DirPath = dstDir
+	dst, err := Open(dstCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if e := dst.Close(); e != nil && err == nil {
+			err = e
+		}
+		if err != nil {
+			fs.RemoveAll(dstDir)
+			result = nil
+		}
+	}()
+
+	ts := uint64(until.UnixNano())
+	lateTxns, err := src.lateTxns(ts)
+	if err != nil {
+		return nil, err
+	}
+	result = &RestoreResult{Until: until}
+	replays := make([]*txnReplay, consts.DataStructureNum)
+	for dType := uint16(0); dType < consts.DataStructureNum; dType++ {
+		var (
+			cursor   = &restoreCursor{until: ts}
+			included bool // 当前 entry 是否不晚于恢复时间，事务的 entry 按照整个事务判断
+		)
+		apply := func(e *storage.Entry, _ uint32, _ int64) error {
+			if _, late := lateTxns[e.TxId]; late || (e.TxId == 0 && !included) {
+				result.Skipped++
+				return nil
+			}
+			// 新目录中不需要事务信息，已经提交的事务的 entry 直接写入
+			re := e.Clone()
+			re.TxId = 0
+			if err := dst.restoreEntry(re); err != nil {
+				return err
+			}
+			result.Replayed++
+			return nil
+		}
+		discard := func(e *storage.Entry, _ uint32, _ int64) {
+			if !e.IsCommit() {
+				result.Skipped++
+			}
+		}
+		r := newTxnReplay(src.txnMeta.legacyTxIds, apply, discard)
+		replays[dType] = r
+
+		for _, df := range src.typeFiles(dType) {
+			offset := df.DataOffset()
+			for {
+				e, err := df.Read(offset)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, fmt.Errorf("%s at offset %d: %w", df.File.Name(), offset, err)
+				}
+				if len(e.Meta.Key) > 0 || e.IsCommit() {
+					included = cursor.include(e)
+					if err = r.add(e, df, offset); err != nil {
+						return nil, err
+					}
+				}
+				offset += int64(e.Size())
+			}
+		}
+		if err = r.finish(); err != nil {
+			return nil, err
+		}
+	}
+	// 停在提交标记的事务在所有类型都读完之后处理，它们是各自类型中最后的 entry
+	if _, err = resolveTxnReplays(replays); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// entry 的创建时间是否不晚于恢复时间，没有创建时间的 entry 沿用上一个 entry 的时间
+func (c *restoreCursor) include(e *storage.Entry) bool {
+	if e.Timestamp != 0 {
+		c.last = e.Timestamp
+	}
+	return c.last <= c.until
+}
+
+// 只读取 header，找出有 entry 的创建时间晚于 until 的事务
+func (db *DB) lateTxns(until uint64) (map[uint64]struct{}, error) {
+	late := make(map[uint64]struct{})
+	for dType := uint16(0); dType < consts.DataStructureNum; dType++ {
+		cursor := &restoreCursor{until: until}
+		for _, df := range db.typeFiles(dType) {
+			for offset := df.DataOffset(); offset < df.Offset; {
+				e, err := df.ReadHeader(offset)
+				if err != nil {
+					return nil, fmt.Errorf("%s at offset %d: %w", df.File.Name(), offset, err)
+				}
+				offset += int64(e.Size())
+				if !cursor.include(e) && e.TxId != 0 {
+					late[e.TxId] = struct{}{}
+				}
+			}
+		}
+	}
+	return late, nil
+}
+
+// 按照加载的顺序返回该类型的所有 dbfile，只读打开时不存在的活跃文件被跳过
+func (db *DB) typeFiles(dType consts.DataType) (files []*storage.DBFile) {
+	fileIds := db.manifest.FileIds(dType)
+	for i, id := range fileIds {
+		if i == len(fileIds)-1 {
+			if df, err := db.getActiveFile(dType); err == nil && df.Id == id {
+				files = append(files, df)
+			}
+			continue
+		}
+		if df, ok := db.archFiles[dType][id]; ok {
+			files = append(files, df)
+		}
+	}
+	return
+}
+
+// 将恢复的 entry 写入活跃文件并更新 index
+func (db *DB) restoreEntry(e *storage.Entry) error {
+	unlock := db.lockMgr.Lock(e.GetType())
+	defer unlock()
+
+	df, err := db.appendEntry(e)
+	if err != nil {
+		return err
+	}
+	idx := db.newStrData(e, df.Id, df.Offset-int64(e.Size()))
+	return db.buildIndex(e, idx)
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats db 运行时的统计信息，从 Open 开始计算
+type Stats struct {
+	// 写入的 value 压缩前的总大小
+	RawValueBytes uint64
+	// 写入的 value 实际占用的总大小
+	StoredValueBytes uint64
+	// 压缩率，实际大小 / 压缩前大小，没有写入时为 1
+	CompressionRatio float64
+
+	// group commit 写入的批次数量和 entry 数量，没有开启 Sync 时为 0
+	GroupCommitBatches uint64
+	GroupCommitEntries uint64
+	// 平均每个批次的 entry 数量
+	GroupCommitAvgBatchSize float64
+	// 最大的批次的 entry 数量
+	GroupCommitMaxBatchSize uint64
+
+	// 最近一次所有写入都成功 fsync 的时间，见 DB.LastSync
+	LastSync time.Time
+
+	// key-only 模式下 value 缓存的统计信息
+	ValueCacheHits   uint64
+	ValueCacheMisses uint64
+	// 命中率，没有查询时为 0
+	ValueCacheHitRatio float64
+	// 缓存的 value 的总大小和数量
+	ValueCacheBytes int64
+	ValueCacheCount int
+}
+
+// Stats 返回 db 的统计信息
+func (db *DB) Stats() Stats {
+	var stats Stats
+	stats.RawValueBytes, stats.StoredValueBytes = db.fileOpts.Stats.ValueBytes()
+	stats.CompressionRatio = 1
+	if stats.RawValueBytes > 0 {
+		stats.CompressionRatio = float64(stats.StoredValueBytes) / float64(stats.RawValueBytes)
+	}
+	if w := db.writer; w != nil {
+		stats.GroupCommitBatches = atomic.LoadUint64(&w.batches)
+		stats.GroupCommitEntries = atomic.LoadUint64(&w.entries)
+		stats.GroupCommitMaxBatchSize = atomic.LoadUint64(&w.maxBatchSize)
+		if stats.GroupCommitBatches > 0 {
+			stats.GroupCommitAvgBatchSize = float64(stats.GroupCommitEntries) / float64(stats.GroupCommitBatches)
+		}
+	}
+	stats.LastSync = db.LastSync()
+	if db.valueCache != nil {
+		cs := db.valueCache.Stats()
+		stats.ValueCacheHits, stats.ValueCacheMisses = cs.Hits, cs.Misses
+		stats.ValueCacheBytes, stats.ValueCacheCount = cs.Size, cs.Count
+		if total := cs.Hits + cs.Misses; total > 0 {
+			stats.ValueCacheHitRatio = float64(cs.Hits) / float64(total)
+		}
+	}
+	return stats
+}
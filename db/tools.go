@@ -38,14 +38,21 @@ func (db *DB) checkExpired(key []byte, dataType consts.DataType) (expired bool)
 	//已经过期
 	if time.Now().Unix() > deadline {
 		expired = true
+		// 只读打开时不写入删除记录，也不修改内存中的数据，只返回过期
+		if db.readOnly {
+			return
+		}
+		// 删除过期的 key 也是修改，事务和 checkpoint 的快照需要保存之前的状态
+		if err := db.recordWrite(dataType, key); err != nil {
+			log.Println("checkExpired: record write err :", err)
+			return
+		}
 		var e *storage.Entry
 		switch dataType {
 
 		case consts.String:
-			// 将删除信息记入entry
+			// 将删除信息记入entry，写入之后再删除内存中的记录，被覆盖的 entry 需要计入 dead bytes
 			e = storage.NewEntryNoExtra(key, nil, consts.String, consts.StringRem)
-			//删除内存中的记录
-			db.strIndex.idxList.Remove(key)
 		case consts.Set:
 			e = storage.NewEntryNoExtra(key, nil, consts.Set, consts.SetSClear)
 			db.setIndex.indexes.SClear(string(key))
@@ -63,50 +70,104 @@ func (db *DB) checkExpired(key []byte, dataType consts.DataType) (expired bool)
 			log.Println("checkExpired: store entry err :", err)
 			return
 		}
+		if dataType == consts.String {
+			db.strIndex.idxList.Remove(key)
+		}
 		//删除key的过期信息
 		delete(db.expires[dataType], string(key))
 	}
 	return
 }
 
-// 将entry写进dbfile里,并根据配置持久化处理
+// 将entry写进dbfile里,并根据 fsync policy 持久化处理
 func (db *DB) store(e *storage.Entry) error {
-	// sync the db file if file size is not enough, and open a new db file.
-	config := db.config
-	activeFile, err := db.getActiveFile(e.GetType())
+	return db.write(e, db.fsyncPolicy == FsyncAlways)
+}
+
+// 将entry写进dbfile里，sync 为 true 时需要持久化
+// 开启 group commit 时只记录需要持久化的写入，调用者释放锁之后在 waitSync 中等待批次的 fsync
+func (db *DB) write(e *storage.Entry, sync bool) error {
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
+	activeFile, err := db.appendEntry(e)
 	if err != nil {
 		return err
 	}
+	if !sync {
+		return nil
+	}
+	if db.writer != nil {
+		db.writer.append()
+		return nil
+	}
 
-	if activeFile.Offset+int64(e.Size()) > config.BlockSize {
-		if err := activeFile.Sync(); err != nil {
-			return err
-		}
+	// 根据配置持久化处理dbfile，blob 文件需要先于 dbfile 持久化
+	if err := db.fileOpts.Blobs.Sync(); err != nil {
+		return err
+	}
+	return activeFile.Sync()
+}
 
-		// save the old db file as arched file.
-		activeFileId := activeFile.Id
-		db.archFiles[e.GetType()][activeFileId] = activeFile
+// 开启 group commit 时等待之前的写入持久化，写入的方法在获取类型的锁之前 defer 调用
+// 释放锁之后再等待 fsync，同一类型的并发写入才能合并到一个批次中
+func (db *DB) waitSync(err *error) {
+	if *err != nil || db.writer == nil {
+		return
+	}
+	*err = db.writer.wait()
+}
+
+// 将 entry 追加到活跃文件，活跃文件写满时归档并创建新的活跃文件，返回写入的文件
+func (db *DB) appendEntry(e *storage.Entry) (*storage.DBFile, error) {
+	activeFile, err := db.getActiveFile(e.GetType())
+	if err != nil {
+		return nil, err
+	}
 
-		newDbFile, err := storage.NewDBFile(config.DirPath, activeFileId+1, e.GetType())
-		if err != nil {
-			return err
+	if activeFile.Offset+int64(e.Size()) > db.config.BlockSize {
+		if activeFile, err = db.rotateActiveFile(activeFile); err != nil {
+			return nil, err
 		}
-		activeFile = newDbFile
 	}
 
 	// 将entry写进dbfile
 	if err := activeFile.Write(e); err != nil {
-		return err
+		return nil, err
 	}
-	db.activeFile.Store(e.GetType(), activeFile)
 
-	// 根据配置持久化处理dbfile
-	if config.Sync {
-		if err := activeFile.Sync(); err != nil {
-			return err
-		}
+	// 事务的 entry 在提交之后再记录
+	if e.TxId == 0 {
+		db.trackEntry(e, activeFile.Id, activeFile.Offset-int64(e.Size()))
 	}
-	return nil
+	return activeFile, nil
+}
+
+// 归档活跃文件并创建新的活跃文件，调用者持有该类型的写锁
+// 新的活跃文件先加入 manifest 再写入，崩溃时没有加入 manifest 的空文件会在启动时删除
+func (db *DB) rotateActiveFile(activeFile *storage.DBFile) (*storage.DBFile, error) {
+	// sync the db file if file size is not enough, and open a new db file.
+	if err := activeFile.Sync(); err != nil {
+		return nil, err
+	}
+
+	dType := activeFile.Type
+	newDbFile, err := storage.NewDBFile(db.config.DirPath, db.manifest.NextFileId(dType), dType, db.fileOpts)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.manifest.AddFile(dType, newDbFile.Id); err != nil {
+		newDbFile.Close(false)
+		return nil, err
+	}
+
+	// save the old db file as arched file.
+	db.archFiles[dType][activeFile.Id] = activeFile
+
+	// 在后台为归档文件生成 hint 文件，加快下次启动时的加载
+	db.writeHintFile(activeFile)
+	db.activeFile.Store(dType, newDbFile)
+	return newDbFile, nil
 }
 
 // 将 key，value 转化成 []byte
@@ -127,9 +188,13 @@ func (db *DB) isClosed() bool {
 
 // 持久化文件
 func (db *DB) Sync() (err error) {
-	if db == nil || db.activeFile == nil {
+	// 只读打开时没有需要持久化的写入
+	if db == nil || db.activeFile == nil || db.readOnly {
 		return nil
 	}
+	if err = db.fileOpts.Blobs.Sync(); err != nil {
+		return
+	}
 
 	db.activeFile.Range(func(key, value interface{}) bool {
 		if dbFile, ok := value.(*storage.DBFile); ok {
@@ -1,20 +1,14 @@
 package db
 
 import (
-	"encoding/binary"
-	"io"
-	"os"
 	"sync"
 	str "zeroDB/datastructure/string"
 	"zeroDB/global/consts"
 	"zeroDB/global/dberror"
+	"zeroDB/global/utils"
 	"zeroDB/storage"
 )
 
-const (
-	txIdLen = 8
-)
-
 type (
 	// Txn is a Transaction.
 	// read-write transaction call Txn, read-only transaction call TxnView.
@@ -37,37 +31,46 @@ type (
 		// just del and set operate will be in.
 		keysMap map[string]int
 
+		// 事务中写入过的 list、hash、set、zset 的 key 的当前状态，第一次写入时创建
+		view *txnView
+
+		// 监视的 key 和比较的版本，提交时在任何类型中的版本大于它都会冲突
+		watches map[string]uint64
+
 		// save and get data structures in the transaction.
 		dsState    uint16
 		isFinished bool
 	}
 
 	// TxnMeta represents some transaction info while tx is running.
+	// 事务是否提交由 dbfile 中的提交标记决定，见 txn_commit.go
 	TxnMeta struct {
 		// MaxTxId the max tx id now.
+		// 打开时是 dbfile、checkpoint 和旧版本 DB.TX.META 中最大的 tx id，之后由 mvcc.mu 保护
 		MaxTxId uint64
 
-		// ActiveTxIds committed tx ids in active files.
-		// they will be write into txnfile.
-		ActiveTxIds *sync.Map
-
-		// save committed entrys, used to check uncommited entrys.
-		CommittedTxIds map[uint64]struct{}
-
-		// a file for saving committed tx ids.
-		// ids in it will be writed into ComittedTxids.
-		txnFile *TxnFile
-	}
+		// 没有提交的事务：重放时没有提交标记，或者提交时写入失败，只有失败的事务会被记录
+		// Reclaim 去掉 entry 的 tx id 时需要跳过它们
+		aborted map[uint64]struct{}
+		mu      sync.Mutex // 保护 aborted，打开时也保护 MaxTxId
 
-	// TxnFile a single file in disk to save committed transaction ids.
-	TxnFile struct {
-		File   *os.File // file.
-		Offset int64    // write offset.
+		// 旧版本 DB.TX.META 中已经提交的 tx id，只在迁移之前或者只读打开旧版本的目录时使用
+		legacyTxIds map[uint64]struct{}
 	}
 )
 
 // Txn execute a transaction which read and write.
+// 提交时和并发的写入冲突返回 ErrTxnConflict，配置了 TxnMaxRetries 时使用新的快照重新执行 fn，fn 需要可以重复执行
 func (db *DB) Txn(fn func(tx *Txn) error) (err error) {
+	for retries := 0; ; retries++ {
+		if err = db.txnOnce(fn); err != dberror.ErrTxnConflict || retries >= db.config.TxnMaxRetries {
+			return
+		}
+	}
+}
+
+// 执行一次读写事务
+func (db *DB) txnOnce(fn func(tx *Txn) error) (err error) {
 	if db.isClosed() {
 		return dberror.ErrDBIsClosed
 	}
@@ -86,36 +89,23 @@ func (db *DB) Txn(fn func(tx *Txn) error) (err error) {
 }
 
 // TxnView execute a transaction which read only.
+// 所有读取都使用事务开始时的快照，不阻塞写入
 func (db *DB) TxnView(fn func(tx *Txn) error) (err error) {
 	if db.isClosed() {
 		return dberror.ErrDBIsClosed
 	}
 	txn := db.NewTransaction()
-	//lock indexes of these data types.
-	dTypes := txn.getDTypes()
-	unlockFunc := txn.db.lockMgr.RLock(dTypes...)
-	defer unlockFunc()
+	defer txn.finished()
 
-	if err = fn(txn); err != nil {
-		txn.Rollback()
-		return
-	}
-	txn.finished()
-	return
+	return fn(txn)
 }
 
-// NewTransaction create a new transaction, don`t support concurrent execution of transactions now.
-// So you can only open a read-write transaction at the same time.
-// For read-only transactions, you can execute multiple, and any write operations will be omitted.
+// NewTransaction create a new transaction, 事务使用开始时的快照读取，和其他事务以及非事务的写入可以并发执行。
+// 提交时如果要写入的 key 在事务开始之后被修改过，提交失败并返回 ErrTxnConflict，可以使用新的事务重试。
+// 事务结束之前会保留快照需要的旧版本，使用后必须 Commit 或者 Rollback。
 func (db *DB) NewTransaction() *Txn {
-	db.mu.Lock()
-	defer func() {
-		db.txnMeta.MaxTxId += 1
-		db.mu.Unlock()
-	}()
-
 	return &Txn{
-		id:         db.txnMeta.MaxTxId + 1,
+		id:         db.beginTxn(),
 		db:         db,
 		wg:         new(sync.WaitGroup),
 		strEntries: make(map[string]*storage.Entry),
@@ -132,7 +122,11 @@ func (tx *Txn) Commit() (err error) {
 	defer tx.finished()
 
 	if len(tx.strEntries) == 0 && len(tx.writeEntries) == 0 {
-		return
+		// 没有写入时只需要检查监视的 key
+		return tx.db.commitVersions(tx.id, nil, tx.watches)
+	}
+	if tx.db.readOnly {
+		return dberror.ErrReadOnly
 	}
 
 	dTypes := tx.getDTypes()
@@ -140,56 +134,88 @@ func (tx *Txn) Commit() (err error) {
 	unlockFunc := tx.db.lockMgr.Lock(dTypes...)
 	defer unlockFunc()
 
+	// 写入的 key 在事务开始之后被修改过时不能提交
+	if err = tx.db.commitVersions(tx.id, tx.writeKeys(), tx.watches); err != nil {
+		return
+	}
+	// 之后失败时可能已经写入了部分 entry
+	defer func() {
+		if err != nil {
+			tx.db.txnMeta.abort(tx.id)
+		}
+	}()
+
 	// write entry into db files.
-	var indexes []*str.StrData
+	var (
+		indexes []*str.StrData
+		locs    []*entryLoc
+	)
 	if len(tx.strEntries) > 0 && len(tx.writeEntries) > 0 {
+		// 两个 goroutine 分别返回错误，不能同时写 err
+		var strErr, otherErr error
 		tx.wg.Add(2)
 		go func() {
 			defer tx.wg.Done()
-			if indexes, err = tx.writeStrEntries(); err != nil {
-				return
-			}
+			indexes, strErr = tx.writeStrEntries()
 		}()
 
 		go func() {
 			defer tx.wg.Done()
-			if err = tx.writeOtherEntries(); err != nil {
-				return
-			}
+			locs, otherErr = tx.writeOtherEntries()
 		}()
 		tx.wg.Wait()
-		if err != nil {
-			return err
+		if strErr != nil {
+			return strErr
+		}
+		if otherErr != nil {
+			return otherErr
 		}
 	} else {
 		if indexes, err = tx.writeStrEntries(); err != nil {
 			return
 		}
-		if err = tx.writeOtherEntries(); err != nil {
+		if locs, err = tx.writeOtherEntries(); err != nil {
 			return
 		}
 	}
 
 	// sync the db file for transaction durability.
-	if tx.db.config.Sync {
+	// 写入提交标记之前 entry 已经持久化，崩溃后有提交标记的事务的 entry 是完整的
+	if tx.db.fsyncPolicy == FsyncAlways {
 		if err := tx.db.Sync(); err != nil {
 			return err
 		}
 	}
 
 	// mark the transaction is committed.
-	if err = tx.db.MarkCommit(tx.id); err != nil {
+	// 所有类型的 entry 都写入之后再写入提交标记
+	if err = tx.writeCommit(); err != nil {
 		return
 	}
+	if tx.db.fsyncPolicy == FsyncAlways {
+		if err = tx.db.Sync(); err != nil {
+			return
+		}
+	}
+
+	// 事务提交之后才记录被覆盖的 entry
+	for _, idx := range indexes {
+		tx.db.trackEntry(tx.strEntries[string(idx.Meta.Key)], idx.FileId, idx.Offset)
+	}
+	for i, loc := range locs {
+		if loc != nil {
+			tx.db.trackEntry(tx.writeEntries[i], loc.fileId, loc.offset)
+		}
+	}
 
 	// build indexes.
 	for _, idx := range indexes {
-		if err = tx.db.buildIndex(tx.strEntries[string(idx.Meta.Key)], idx, false); err != nil {
+		if err = tx.db.buildIndex(tx.strEntries[string(idx.Meta.Key)], idx); err != nil {
 			return
 		}
 	}
 	for _, entry := range tx.writeEntries {
-		if err = tx.db.buildIndex(entry, nil, false); err != nil {
+		if err = tx.db.buildIndex(entry, nil); err != nil {
 			return
 		}
 	}
@@ -201,79 +227,62 @@ func (tx *Txn) Rollback() {
 	tx.finished()
 }
 
-// MarkCommit write the tx id into txn file.
-func (db *DB) MarkCommit(txId uint64) (err error) {
-	buf := make([]byte, txIdLen)
-	binary.BigEndian.PutUint64(buf[:], txId)
-
-	offset := db.txnMeta.txnFile.Offset
-	_, err = db.txnMeta.txnFile.File.WriteAt(buf, offset)
-	if err != nil {
-		return
+// Watch 监视 keys，同一个 key 在五种类型中的任何一种被其他事务或者非事务的写入修改过时，Commit 返回 ErrTxnConflict
+// 比较的是 key 在事务快照之后有没有被修改，在事务开始之后调用 Watch 和在开始时调用相同，不需要写入这些 key
+func (tx *Txn) Watch(keys ...interface{}) error {
+	if tx.isFinished {
+		return dberror.ErrTxIsFinished
 	}
-	db.txnMeta.txnFile.Offset += int64(len(buf))
-
-	if db.config.Sync {
-		if err = db.txnMeta.txnFile.File.Sync(); err != nil {
-			return
+	for _, key := range keys {
+		encKey, err := utils.EncodeKey(key)
+		if err != nil {
+			return err
+		}
+		if len(encKey) == 0 {
+			return dberror.ErrEmptyKey
 		}
+		tx.watch(string(encKey), tx.id)
 	}
-	return
+	return nil
 }
 
-// LoadTxnMeta load txn meta info, committed tx id.
-func LoadTxnMeta(path string) (txnMeta *TxnMeta, err error) {
-	txnMeta = &TxnMeta{
-		CommittedTxIds: make(map[uint64]struct{}),
-		ActiveTxIds:    new(sync.Map),
+// AddWatcher 提交时同时检查 w 监视的 key，它们在开始监视之后被修改过时 Commit 返回 ErrTxnConflict
+// 事务结束之前 w 不能 Unwatch
+func (tx *Txn) AddWatcher(w *Watcher) error {
+	if tx.isFinished {
+		return dberror.ErrTxIsFinished
 	}
-
-	var (
-		file    *os.File
-		maxTxId uint64
-		stat    os.FileInfo
-	)
-	if file, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644); err != nil {
-		return
+	for key, version := range w.versions {
+		tx.watch(key, version)
 	}
-	if stat, err = file.Stat(); err != nil {
-		return
+	return nil
+}
+
+// 记录监视的 key，同一个 key 使用较小的版本
+func (tx *Txn) watch(key string, version uint64) {
+	if tx.watches == nil {
+		tx.watches = make(map[string]uint64)
 	}
-	txnMeta.txnFile = &TxnFile{
-		File:   file,
-		Offset: stat.Size(),
-	}
-
-	if txnMeta.txnFile.Offset > 0 {
-		var offset int64
-		for {
-			buf := make([]byte, txIdLen)
-			_, err = file.ReadAt(buf, offset)
-			if err != nil {
-				if err == io.EOF {
-					err = nil
-					break
-				}
-				return
-			}
-			txId := binary.BigEndian.Uint64(buf)
-			if txId > maxTxId {
-				maxTxId = txId
-			}
-			txnMeta.CommittedTxIds[txId] = struct{}{}
-			offset += txIdLen
-		}
+	if v, ok := tx.watches[key]; !ok || version < v {
+		tx.watches[key] = version
 	}
-	txnMeta.MaxTxId = maxTxId
-	return
+}
+
+// Unwatch 取消监视所有的 key
+// 只影响提交时的检查，事务中已经写入的内容仍然可以读到
+func (tx *Txn) Unwatch() {
+	tx.watches = nil
 }
 
 func (tx *Txn) finished() {
+	tx.db.endTxn(tx.id)
 	tx.strEntries = nil
 	tx.writeEntries = nil
 
 	tx.skipIds = nil
 	tx.keysMap = nil
+	tx.watches = nil
+	tx.view = nil
 
 	tx.isFinished = true
 	return
@@ -286,7 +295,7 @@ func (tx *Txn) writeStrEntries() (indexes []*str.StrData, err error) {
 	}
 
 	for _, entry := range tx.strEntries {
-		if err = tx.db.store(entry); err != nil {
+		if err = tx.db.write(entry, false); err != nil {
 			return
 		}
 		activeFile, err := tx.db.getActiveFile(consts.String)
@@ -294,43 +303,61 @@ func (tx *Txn) writeStrEntries() (indexes []*str.StrData, err error) {
 			return nil, err
 		}
 		// generate index.
-		indexes = append(indexes, &str.StrData{
-			Meta: &storage.Meta{
-				Key: entry.Meta.Key,
-			},
-			FileId: activeFile.Id,
-			Offset: activeFile.Offset - int64(entry.Size()),
-		})
+		indexes = append(indexes, tx.db.newStrData(entry, activeFile.Id, activeFile.Offset-int64(entry.Size())))
 	}
 	return
 }
 
-func (tx *Txn) writeOtherEntries() (err error) {
+// 返回每个 entry 写入的位置，没有写入的 entry 为 nil
+func (tx *Txn) writeOtherEntries() (locs []*entryLoc, err error) {
 	// if write enrty is empty,return nil
 	if len(tx.writeEntries) == 0 {
 		return
 	}
 
+	locs = make([]*entryLoc, len(tx.writeEntries))
 	for i, entry := range tx.writeEntries {
 		if _, ok := tx.skipIds[i]; ok {
 			continue
 		}
-		if err = tx.db.store(entry); err != nil {
+		if err = tx.db.write(entry, false); err != nil {
 			return
 		}
+		activeFile, err := tx.db.getActiveFile(entry.GetType())
+		if err != nil {
+			return nil, err
+		}
+		locs[i] = &entryLoc{fileId: activeFile.Id, offset: activeFile.Offset - int64(entry.Size())}
 	}
 	return
 }
 
+// 事务要写入的所有 key
+func (tx *Txn) writeKeys() map[consts.DataType]map[string]struct{} {
+	keys := make(map[consts.DataType]map[string]struct{})
+	add := func(dType consts.DataType, key string) {
+		if keys[dType] == nil {
+			keys[dType] = make(map[string]struct{})
+		}
+		keys[dType][key] = struct{}{}
+	}
+	for key := range tx.strEntries {
+		add(consts.String, key)
+	}
+	for i, e := range tx.writeEntries {
+		if _, ok := tx.skipIds[i]; !ok {
+			add(e.GetType(), string(e.Meta.Key))
+		}
+	}
+	return keys
+}
+
 func (tx *Txn) putEntry(e *storage.Entry) (err error) {
 	if e == nil {
 		return
 	}
-	if tx.db.isClosed() {
-		return dberror.ErrDBIsClosed
-	}
-	if tx.isFinished {
-		return dberror.ErrTxIsFinished
+	if err = tx.writable(); err != nil {
+		return
 	}
 
 	switch e.GetType() {
@@ -343,6 +370,20 @@ func (tx *Txn) putEntry(e *storage.Entry) (err error) {
 	return
 }
 
+// 事务是否还可以写入，集合类型的写入在修改 view 之前检查
+func (tx *Txn) writable() error {
+	if tx.db.isClosed() {
+		return dberror.ErrDBIsClosed
+	}
+	if tx.isFinished {
+		return dberror.ErrTxIsFinished
+	}
+	if tx.db.readOnly {
+		return dberror.ErrReadOnly
+	}
+	return nil
+}
+
 func (tx *Txn) setDsState(dType consts.DataType) {
 	tx.dsState = tx.dsState | (1 << dType)
 }
@@ -3,6 +3,7 @@ package db
 import (
 	"bytes"
 	"encoding/binary"
+	"sort"
 	"time"
 
 	"zeroDB/global/consts"
@@ -63,7 +64,7 @@ func (tx *Txn) SetEx(key, value interface{}, duration int64) (err error) {
 
 	deadline := time.Now().Unix() + duration
 	e := storage.NewEntryWithTxn(encKey, encVal, nil, consts.String, consts.StringExpire, tx.id)
-	e.Timestamp = uint64(deadline)
+	e.Expire = uint64(deadline)
 	if err = tx.putEntry(e); err != nil {
 		return
 	}
@@ -77,26 +78,35 @@ func (tx *Txn) Get(key, dest interface{}) (err error) {
 		return err
 	}
 
-	var val []byte
-	if e, ok := tx.strEntries[string(encKey)]; ok {
-		if e.GetMark() == consts.StringRem {
-			err = dberror.ErrKeyNotExist
-			return
-		}
-		if e.GetMark() == consts.StringExpire && e.Timestamp < uint64(time.Now().Unix()) {
-			return
-		}
-		val = e.Meta.Value
-	} else {
-		val, err = tx.db.getVal(encKey)
+	val, err := tx.strGet(encKey)
+	if err != nil {
+		return
 	}
-
 	if len(val) > 0 {
 		err = utils.DecodeValue(val, dest)
 	}
 	return
 }
 
+// 事务中 string 的 value，事务写入过 key 时使用还没有提交的 entry，否则读取快照
+func (tx *Txn) strGet(key []byte) ([]byte, error) {
+	if e, ok := tx.strEntries[string(key)]; ok {
+		switch e.GetMark() {
+		case consts.StringRem:
+			return nil, dberror.ErrKeyNotExist
+		case consts.StringExpire:
+			if time.Now().Unix() > int64(e.Expire) {
+				return nil, dberror.ErrKeyExpired
+			}
+		}
+		return e.Meta.Value, nil
+	}
+
+	unlock := tx.db.lockMgr.RLock(consts.String)
+	defer unlock()
+	return tx.strValue(key)
+}
+
 // GetSet see db_str.go:GetSet
 func (tx *Txn) GetSet(key, value, dest interface{}) (err error) {
 	err = tx.Get(key, dest)
@@ -113,19 +123,15 @@ func (tx *Txn) Append(key interface{}, value string) (err error) {
 		return err
 	}
 
-	if e, ok := tx.strEntries[string(encKey)]; ok && e.GetMark() != consts.StringRem {
-		e.Meta.Value = append(e.Meta.Value, value...)
-		return
-	}
-
-	var existVal []byte
-	err = tx.Get(key, &existVal)
+	existVal, err := tx.strGet(encKey)
 	if err != nil && err != dberror.ErrKeyNotExist && err != dberror.ErrKeyExpired {
 		return err
 	}
-	existVal = append(existVal, []byte(value)...)
+	// 不修改还没有提交的 entry 中的 value
+	val := make([]byte, 0, len(existVal)+len(value))
+	val = append(append(val, existVal...), value...)
 
-	return tx.Set(key, existVal)
+	return tx.Set(encKey, val)
 }
 
 // StrExists see db_str.go:StrExists
@@ -135,15 +141,8 @@ func (tx *Txn) StrExists(key interface{}) (ok bool) {
 		return false
 	}
 
-	if e, ok := tx.strEntries[string(encKey)]; ok && e.GetMark() != consts.StringRem {
-		return true
-	}
-	if tx.db.checkExpired(encKey, consts.String) {
-		return false
-	}
-
-	ok = tx.db.strIndex.idxList.Exist(encKey)
-	return
+	_, err = tx.strGet(encKey)
+	return err == nil
 }
 
 // Remove see db_str.go:Remove
@@ -156,11 +155,7 @@ func (tx *Txn) Remove(key interface{}) (err error) {
 		return
 	}
 
-	if _, ok := tx.strEntries[string(encKey)]; ok {
-		delete(tx.strEntries, string(encKey))
-		return
-	}
-
+	// 覆盖事务中之前的写入，快照中存在的 key 同样需要删除
 	e := storage.NewEntryWithTxn(encKey, nil, nil, consts.String, consts.StringRem, tx.id)
 	if err = tx.putEntry(e); err != nil {
 		return
@@ -168,379 +163,156 @@ func (tx *Txn) Remove(key interface{}) (err error) {
 	return
 }
 
-// LPush see db_list.go:LPush
-func (tx *Txn) LPush(key interface{}, values ...interface{}) (err error) {
-	encKey, err := utils.EncodeKey(key)
-	if err != nil {
-		return err
+// PrefixScan see db_str.go:PrefixScan
+func (tx *Txn) PrefixScan(prefix string, limit, offset int) (val []interface{}, err error) {
+	if limit <= 0 {
+		return
 	}
-
-	for _, v := range values {
-		var encVal []byte
-		if encVal, err = utils.EncodeValue(v); err != nil {
-			return
-		}
-		if err = tx.db.checkKeyValue(encKey, encVal); err != nil {
-			return
-		}
-
-		e := storage.NewEntryWithTxn(encKey, encVal, nil, consts.List, consts.ListLPush, tx.id)
-		if err = tx.putEntry(e); err != nil {
-			return
-		}
+	if offset < 0 {
+		offset = 0
 	}
-	return
-}
-
-// RPush see db_list.go:RPush
-func (tx *Txn) RPush(key interface{}, values ...interface{}) (err error) {
-	encKey, err := utils.EncodeKey(key)
-	if err != nil {
-		return err
+	if err = tx.db.checkKeyValue([]byte(prefix), nil); err != nil {
+		return
 	}
 
-	for _, v := range values {
-		var encVal []byte
-		if encVal, err = utils.EncodeValue(v); err != nil {
-			return
+	keys := tx.scanStrKeys([]byte(prefix), func(key []byte) bool {
+		return bytes.HasPrefix(key, []byte(prefix))
+	})
+	for _, key := range keys {
+		if limit == 0 {
+			break
 		}
-		if err = tx.db.checkKeyValue(encKey, encVal); err != nil {
-			return
+		value, err := tx.strGet(key)
+		if err == dberror.ErrKeyNotExist || err == dberror.ErrKeyExpired {
+			continue
 		}
-
-		e := storage.NewEntryWithTxn(encKey, encVal, nil, consts.List, consts.ListRPush, tx.id)
-		if err = tx.putEntry(e); err != nil {
-			return
+		if err != nil {
+			return nil, err
 		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		val = append(val, value)
+		limit--
 	}
 	return
 }
 
-// HSet see db_hash.go:HSet
-func (tx *Txn) HSet(key, field, value interface{}) (err error) {
-	encKey, encVal, err := tx.db.encode(key, value)
-	if err != nil {
-		return err
-	}
-	if err = tx.db.checkKeyValue(encKey, encVal); err != nil {
-		return
-	}
-
-	encField, err := utils.EncodeValue(field)
-	if err != nil {
-		return err
-	}
-
-	// compare to the old val.
-	oldVal, err := tx.hGetVal(key, field)
-	if err != nil {
-		return err
-	}
-	if bytes.Compare(encVal, oldVal) == 0 {
-		return
-	}
-
-	e := storage.NewEntryWithTxn(encKey, encVal, encField, consts.Hash, consts.HashHSet, tx.id)
-	if err = tx.putEntry(e); err != nil {
-		return
-	}
-
-	eKey := tx.encodeKey(encVal, encField, consts.Hash)
-	tx.keysMap[eKey] = len(tx.writeEntries) - 1
-	return
-}
-
-// HSetNx see db_hash.go:HSetNx
-func (tx *Txn) HSetNx(key, field, value interface{}) (err error) {
-	if oldVal, err := tx.hGetVal(key, field); err == nil && len(oldVal) > 0 {
-		return err
-	}
-
-	encKey, encVal, err := tx.db.encode(key, value)
-	if err != nil {
-		return err
-	}
-	if err = tx.db.checkKeyValue(encKey, encVal); err != nil {
-		return
-	}
-
-	encField, err := utils.EncodeValue(field)
-	if err != nil {
-		return err
-	}
-
-	e := storage.NewEntryWithTxn(encKey, encVal, encField, consts.Hash, consts.HashHSet, tx.id)
-	if err = tx.putEntry(e); err != nil {
-		return
-	}
-
-	eKey := tx.encodeKey(encKey, encField, consts.Hash)
-	tx.keysMap[eKey] = len(tx.writeEntries) - 1
-	return
-}
-
-// HGet see db_hash.go:HGet
-func (tx *Txn) HGet(key, field, dest interface{}) (err error) {
-	val, err := tx.hGetVal(key, field)
-	if err != nil {
-		return err
-	}
-	if len(val) > 0 {
-		err = utils.DecodeValue(val, dest)
-	}
-	return
-}
-
-func (tx *Txn) hGetVal(key, field interface{}) (val []byte, err error) {
-	encKey, encField, err := tx.db.encode(key, field)
+// RangeScan see db_str.go:RangeScan, 返回 key 在 start 和 end 之间的所有 value
+func (tx *Txn) RangeScan(start, end interface{}) (val []interface{}, err error) {
+	startKey, err := utils.EncodeKey(start)
 	if err != nil {
 		return nil, err
 	}
-
-	eKey := tx.encodeKey(encKey, encField, consts.Hash)
-	if idx, ok := tx.keysMap[eKey]; ok {
-		entry := tx.writeEntries[idx]
-		//only set and del will be putted into keysmap.
-		//it it set ,if not del. just get the set val to return
-		if entry.GetMark() == consts.HashHDel {
-			return
-		}
-
-		val = entry.Meta.Value
-		return
-	}
-	if tx.db.checkExpired(encKey, consts.Hash) {
-		return
-	}
-
-	val = tx.db.hashIndex.indexes.HGet(string(encKey), string(encField))
-	return
-}
-
-// HDel see db_hash.go:HDel
-func (tx *Txn) HDel(key interface{}, fields ...interface{}) (err error) {
-	if key == nil || len(fields) == 0 {
-		return
-	}
-
-	encKey, err := utils.EncodeKey(key)
+	endKey, err := utils.EncodeKey(end)
 	if err != nil {
-		return err
-	}
-	if tx.db.checkExpired(encKey, consts.Hash) {
-		return
+		return nil, err
 	}
 
-	for _, field := range fields {
-		var encField []byte
-		if encField, err = utils.EncodeValue(field); err != nil {
-			return
+	keys := tx.scanStrKeys(startKey, func(key []byte) bool {
+		return bytes.Compare(key, startKey) >= 0 && bytes.Compare(key, endKey) <= 0
+	})
+	for _, key := range keys {
+		value, err := tx.strGet(key)
+		if err == dberror.ErrKeyNotExist || err == dberror.ErrKeyExpired {
+			continue
 		}
-
-		eKey := tx.encodeKey(encKey, encField, consts.Hash)
-		// del don't need do twice , set then del don't need to set.
-		if idx, ok := tx.keysMap[eKey]; ok {
-			tx.skipIds[idx] = struct{}{}
-		}
-
-		e := storage.NewEntryWithTxn(encKey, nil, encField, consts.Hash, consts.HashHDel, tx.id)
-		if err = tx.putEntry(e); err != nil {
-			return
+		if err != nil {
+			return nil, err
 		}
-		tx.keysMap[eKey] = len(tx.writeEntries) - 1
+		val = append(val, value)
 	}
 	return
 }
 
-// HExists see db_hash.go:HExists
-func (tx *Txn) HExists(key, field interface{}) (ok bool) {
-	encKey, encFiled, err := tx.db.encode(key, field)
-	if err != nil {
-		return false
+// 按顺序返回事务中可能满足 match 的 string key：index 中从 start 开始连续满足 match 的 key，
+// 快照之后被修改过的 key（可能已经从 index 中删除）以及事务写入的 key，key 是否存在由调用者判断
+func (tx *Txn) scanStrKeys(start []byte, match func(key []byte) bool) (keys [][]byte) {
+	set := make(map[string]struct{})
+	unlock := tx.db.lockMgr.RLock(consts.String)
+	for e := tx.db.strIndex.idxList.FindPrefix(start); e != nil && match(e.Key()); e = e.Next() {
+		set[string(e.Key())] = struct{}{}
+	}
+	for _, key := range tx.db.changedKeys(consts.String, tx.id) {
+		if match([]byte(key)) {
+			set[key] = struct{}{}
+		}
 	}
+	unlock()
 
-	eKey := tx.encodeKey(encKey, encFiled, consts.Hash)
-	if idx, exist := tx.keysMap[eKey]; exist {
-		if tx.writeEntries[idx].GetMark() == consts.HashHDel {
-			return
+	for key := range tx.strEntries {
+		if match([]byte(key)) {
+			set[key] = struct{}{}
 		}
-		return true
 	}
-
-	if tx.db.checkExpired(encKey, consts.Hash) {
-		return
+	for key := range set {
+		keys = append(keys, []byte(key))
 	}
-	ok = tx.db.hashIndex.indexes.HExists(string(encKey), string(encFiled))
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
 	return
 }
 
-// SAdd see db_set.go:SAdd
-func (tx *Txn) SAdd(key interface{}, members ...interface{}) (err error) {
+// Expire see db_str.go:Expire
+func (tx *Txn) Expire(key interface{}, duration int64) (err error) {
 	encKey, err := utils.EncodeKey(key)
 	if err != nil {
 		return err
 	}
-
-	for _, mem := range members {
-		var encMem []byte
-		if encMem, err = utils.EncodeValue(mem); err != nil {
-			return
-		}
-		if err = tx.db.checkKeyValue(encKey, encMem); err != nil {
-			return
-		}
-
-		if !tx.SIsMember(key, mem) {
-			e := storage.NewEntryWithTxn(encKey, encMem, nil, consts.Set, consts.SetSAdd, tx.id)
-			if err = tx.putEntry(e); err != nil {
-				return
-			}
-
-			encKey := tx.encodeKey(encKey, encMem, consts.Set)
-			tx.keysMap[encKey] = len(tx.writeEntries) - 1
-		}
+	if duration <= 0 {
+		return dberror.ErrInvalidTTL
 	}
-	return
-}
 
-// SIsMember see db_set.go:SIsMember
-func (tx *Txn) SIsMember(key, member interface{}) (ok bool) {
-	encKey, encMem, err := tx.db.encode(key, member)
+	value, err := tx.strGet(encKey)
 	if err != nil {
 		return
 	}
-
-	eKey := tx.encodeKey(encKey, encMem, consts.Set)
-	if idx, exist := tx.keysMap[eKey]; exist {
-		entry := tx.writeEntries[idx]
-		if entry.GetMark() == consts.SetSRem {
-			return
-		}
-		if bytes.Compare(entry.Meta.Value, encMem) == 0 {
-			return true
-		}
-	}
-	if tx.db.checkExpired(encKey, consts.Set) {
-		return
-	}
-
-	ok = tx.db.setIndex.indexes.SIsMember(string(encKey), encMem)
-	return
+	e := storage.NewEntryWithTxn(encKey, value, nil, consts.String, consts.StringExpire, tx.id)
+	e.Expire = uint64(time.Now().Unix() + duration)
+	return tx.putEntry(e)
 }
 
-// SRem see db_set.go:SRem
-func (tx *Txn) SRem(key interface{}, members ...[]interface{}) (err error) {
+// Persist see db_str.go:Persist
+func (tx *Txn) Persist(key interface{}) (err error) {
 	encKey, err := utils.EncodeKey(key)
 	if err != nil {
 		return err
 	}
 
-	if tx.db.checkExpired(encKey, consts.Set) {
-		return
-	}
-	for _, mem := range members {
-		var encMem []byte
-		if encMem, err = utils.EncodeValue(mem); err != nil {
-			return
-		}
-
-		eKey := tx.encodeKey(encKey, encMem, consts.Set)
-		if idx, ok := tx.keysMap[eKey]; ok {
-			tx.skipIds[idx] = struct{}{}
-		}
-		e := storage.NewEntryWithTxn(encKey, encMem, nil, consts.Set, consts.SetSRem, tx.id)
-		if err = tx.putEntry(e); err != nil {
-			return
-		}
-		tx.keysMap[eKey] = len(tx.writeEntries) - 1
-	}
-	return
-}
-
-// ZScore see db_zset.go/ZAdd
-func (tx *Txn) ZAdd(key interface{}, score float64, member interface{}) (err error) {
-	encKey, encMember, err := tx.db.encode(key, member)
-	if err != nil {
-		return err
-	}
-
-	ok, oldScore, err := tx.ZScore(key, member)
-	if err != nil {
-		return err
-	}
-	if ok && oldScore == score {
-		return
-	}
-
-	extra := []byte(utils.Float64ToStr(score))
-	e := storage.NewEntryWithTxn(encKey, encMember, extra, consts.ZSet, consts.ZSetZAdd, tx.id)
-	if err = tx.putEntry(e); err != nil {
-		return
-	}
-
-	eKey := tx.encodeKey(encKey, encMember, consts.ZSet)
-	tx.keysMap[eKey] = len(tx.writeEntries) - 1
-	return
-}
-
-// ZScore see db_zset.go/ZScore
-func (tx *Txn) ZScore(key, member interface{}) (exist bool, score float64, err error) {
-	encKey, encMember, err := tx.db.encode(key, member)
+	value, err := tx.strGet(encKey)
 	if err != nil {
-		return false, 0, err
-	}
-
-	eKey := tx.encodeKey(encKey, encMember, consts.ZSet)
-	if idx, ok := tx.keysMap[eKey]; ok {
-		entry := tx.writeEntries[idx]
-		if entry.GetMark() == consts.ZSetZRem {
-			return
-		}
-		score, err = utils.StrToFloat64(string(entry.Meta.Extra))
-		if err != nil {
-			return
-		}
-	}
-	if tx.db.checkExpired(encKey, consts.ZSet) {
-		err = dberror.ErrKeyExpired
 		return
 	}
-
-	exist, score = tx.db.zsetIndex.indexes.ZScore(string(encKey), string(encMember))
-	return
+	e := storage.NewEntryWithTxn(encKey, value, nil, consts.String, consts.StringPersist, tx.id)
+	return tx.putEntry(e)
 }
 
-// ZRem see db_zset.go/ZRem
-func (tx *Txn) ZRem(key, member interface{}) (err error) {
-	encKey, encMember, err := tx.db.encode(key, member)
+// TTL see db_str.go:TTL
+func (tx *Txn) TTL(key interface{}) (ttl int64) {
+	encKey, err := utils.EncodeKey(key)
 	if err != nil {
-		return err
-	}
-	if tx.db.checkExpired(encKey, consts.ZSet) {
 		return
 	}
 
-	eKey := tx.encodeKey(encKey, encMember, consts.ZSet)
-	if idx, ok := tx.keysMap[eKey]; ok {
-		tx.skipIds[idx] = struct{}{}
+	e, ok := tx.strEntries[string(encKey)]
+	if !ok {
+		return tx.ttl(consts.String, encKey)
 	}
-
-	e := storage.NewEntryWithTxn(encKey, encMember, nil, consts.ZSet, consts.ZSetZRem, tx.id)
-	if err = tx.putEntry(e); err != nil {
-		return
+	// Set 和 Persist 清除过期时间
+	if e.GetMark() == consts.StringExpire && time.Now().Unix() <= int64(e.Expire) {
+		ttl = int64(e.Expire) - time.Now().Unix()
 	}
-	tx.keysMap[eKey] = len(tx.writeEntries) - 1
 	return
 }
 
+// keysMap 中 key 和集合中元素的组合，key 的长度写在前面，不同的 key 和元素不会得到相同的结果
 func (tx *Txn) encodeKey(key, extra []byte, dType consts.DataType) string {
 	keyLen, extraLen := len(key), len(extra)
-	buf := make([]byte, keyLen+extraLen+2)
+	buf := make([]byte, keyLen+extraLen+6)
 
 	binary.BigEndian.PutUint16(buf[:2], dType)
-	copy(buf[2:keyLen+2], key)
-	if extraLen > 0 {
-		copy(buf[keyLen:keyLen+extraLen+2], extra)
-	}
+	binary.BigEndian.PutUint32(buf[2:6], uint32(keyLen))
+	copy(buf[6:keyLen+6], key)
+	copy(buf[keyLen+6:], extra)
 	return string(buf)
 }
@@ -0,0 +1,310 @@
+package db
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"zeroDB/global/consts"
+	"zeroDB/global/dberror"
+	"zeroDB/storage"
+)
+
+// 事务的提交标记
+// 事务的 entry 写入之后，在写入的每种类型的 dbfile 中追加一个提交标记，标记中记录事务写入的所有类型
+// 提交时持有这些类型的写锁，同一种类型中事务的 entry 和它的提交标记是连续的，重放时：
+//   - 事务的 entry 先缓存，读到同一个事务的提交标记时才生效，标记之前出现其他 entry 或者读到末尾时事务没有提交
+//   - 写入多种类型的事务在每种类型中都有提交标记才算提交，提交标记按照类型的顺序写入，崩溃时只有某些类型最后的提交标记
+//     需要检查其他类型，其他类型中这个事务的 entry 没有提交标记时截断这个标记，下次打开时得到相同的结果
+//
+// 是否提交只由 dbfile 中的顺序决定，内存中不需要记录已经提交的 tx id，只记录失败的事务
+// 重写单个文件时保留 tx id 和提交标记，事务可能跨越两个文件；Reclaim 重写所有归档文件，其中的 entry 不再需要 tx id
+
+const (
+	txIdLen = 8
+
+	// 加密的 txn meta file 以 txnFileMagic 开头，之后每个 tx id 单独加密
+	txnFileMagic = "ZTXE"
+)
+
+type (
+	// 重放一种类型的 entry 时缓存的事务
+	txnReplay struct {
+		legacy  map[uint64]struct{}                                       // 旧版本 DB.TX.META 中已经提交的事务，entry 直接生效
+		apply   func(e *storage.Entry, fileId uint32, offset int64) error // 使 entry 生效
+		discard func(e *storage.Entry, fileId uint32, offset int64)       // 没有提交的 entry 以及提交标记
+
+		txId    uint64 // 正在缓存的事务，为 0 时没有
+		pending []*replayEntry
+		marker  *replayEntry        // 写入多种类型的事务的提交标记，等待之后的 entry 确认
+		torn    map[uint64]struct{} // 没有提交标记的事务
+		maxTxId uint64
+	}
+
+	// 缓存的 entry 和它的位置
+	replayEntry struct {
+		e      *storage.Entry
+		df     *storage.DBFile
+		offset int64
+	}
+)
+
+func newTxnReplay(legacy map[uint64]struct{}, apply func(e *storage.Entry, fileId uint32, offset int64) error,
+	discard func(e *storage.Entry, fileId uint32, offset int64)) *txnReplay {
+	return &txnReplay{
+		legacy:  legacy,
+		apply:   apply,
+		discard: discard,
+		torn:    make(map[uint64]struct{}),
+	}
+}
+
+// 按照 dbfile 中的顺序读到一个 entry
+func (r *txnReplay) add(e *storage.Entry, df *storage.DBFile, offset int64) error {
+	if e.TxId > r.maxTxId {
+		r.maxTxId = e.TxId
+	}
+	// 缓存的事务之后出现了其他 entry，已经读到提交标记时事务的所有提交标记都已经写完
+	if r.txId != 0 && (r.marker != nil || e.TxId != r.txId) {
+		if err := r.end(r.marker != nil); err != nil {
+			return err
+		}
+	}
+
+	re := &replayEntry{e: e, df: df, offset: offset}
+	if e.IsCommit() {
+		// 之前没有这个事务的 entry，例如 entry 在 checkpoint 之前
+		if e.TxId != r.txId {
+			r.discard(e, df.Id, offset)
+			return nil
+		}
+		r.marker = re
+		if e.CommitTypes() == 1<<e.GetType() {
+			return r.end(true)
+		}
+		return nil
+	}
+	if e.TxId == 0 {
+		return r.apply(e, df.Id, offset)
+	}
+	if _, ok := r.legacy[e.TxId]; ok {
+		return r.apply(e, df.Id, offset)
+	}
+	r.txId = e.TxId
+	r.pending = append(r.pending, re)
+	return nil
+}
+
+// 缓存的事务结束，committed 为 false 时 entry 被丢弃
+func (r *txnReplay) end(committed bool) error {
+	pending, marker := r.pending, r.marker
+	if !committed {
+		r.torn[r.txId] = struct{}{}
+	}
+	r.txId, r.pending, r.marker = 0, nil, nil
+
+	for _, re := range pending {
+		if !committed {
+			r.discard(re.e, re.df.Id, re.offset)
+			continue
+		}
+		if err := r.apply(re.e, re.df.Id, re.offset); err != nil {
+			return err
+		}
+	}
+	if marker != nil {
+		r.discard(marker.e, marker.df.Id, marker.offset)
+	}
+	return nil
+}
+
+// 该类型的 entry 已经全部读完，末尾没有提交标记的事务被丢弃
+// 末尾是多种类型的事务的提交标记时，需要等待所有类型都读完，见 resolveTxnReplays
+func (r *txnReplay) finish() error {
+	if r.txId != 0 && r.marker == nil {
+		return r.end(false)
+	}
+	return nil
+}
+
+// 所有类型都读完之后，检查停在提交标记的事务，它写入的某个类型中有 entry 没有提交标记时不提交
+// 返回没有提交的事务的提交标记，replays 中不需要重放的类型为 nil
+func resolveTxnReplays(replays []*txnReplay) (aborted []*replayEntry, err error) {
+	for _, r := range replays {
+		if r == nil || r.marker == nil {
+			continue
+		}
+		committed := true
+		types := r.marker.e.CommitTypes()
+		for dType, other := range replays {
+			if other == nil || other == r || types&(1<<dType) == 0 {
+				continue
+			}
+			if _, ok := other.torn[r.txId]; ok {
+				committed = false
+			}
+		}
+		marker := r.marker
+		if err = r.end(committed); err != nil {
+			return
+		}
+		if !committed {
+			aborted = append(aborted, marker)
+		}
+	}
+	return
+}
+
+// 在事务写入的每种类型的 entry 之后追加提交标记，调用者持有这些类型的写锁
+// 写入失败时截断已经写入的标记，事务在所有类型中都没有提交标记，重启后被丢弃
+func (tx *Txn) writeCommit() (err error) {
+	dTypes, types := tx.writtenTypes()
+	written := make([]*replayEntry, 0, len(dTypes))
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, re := range written {
+			re.df.Truncate(re.offset)
+		}
+	}()
+
+	for _, dType := range dTypes {
+		e := storage.NewCommitEntry(dType, tx.id, types)
+		if err = tx.db.write(e, false); err != nil {
+			return
+		}
+		var df *storage.DBFile
+		if df, err = tx.db.getActiveFile(dType); err != nil {
+			return
+		}
+		written = append(written, &replayEntry{e: e, df: df, offset: df.Offset - int64(e.Size())})
+	}
+	return
+}
+
+// 事务实际写入 entry 的类型，按照类型的顺序，以及它们组成的位图
+func (tx *Txn) writtenTypes() (dTypes []uint16, types uint16) {
+	if len(tx.strEntries) > 0 {
+		types |= 1 << consts.String
+	}
+	for i, e := range tx.writeEntries {
+		if _, ok := tx.skipIds[i]; !ok {
+			types |= 1 << e.GetType()
+		}
+	}
+	for dType := uint16(0); dType < consts.DataStructureNum; dType++ {
+		if types&(1<<dType) != 0 {
+			dTypes = append(dTypes, dType)
+		}
+	}
+	return
+}
+
+// 打开时不同类型并发加载，记录出现过的最大的 tx id，之后的事务从它开始分配
+func (tm *TxnMeta) observe(txId uint64) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if txId > tm.MaxTxId {
+		tm.MaxTxId = txId
+	}
+}
+
+// 记录没有提交的事务，它的 entry 还在 dbfile 中
+func (tm *TxnMeta) abort(txId uint64) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	if tm.aborted == nil {
+		tm.aborted = make(map[uint64]struct{})
+	}
+	tm.aborted[txId] = struct{}{}
+}
+
+// 事务是否没有提交，重写文件时删除它的 entry
+func (tm *TxnMeta) isAborted(txId uint64) bool {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	_, ok := tm.aborted[txId]
+	return ok
+}
+
+// 读取旧版本的 DB.TX.META，其中是已经提交的 tx id，文件不存在时 legacyTxIds 为 nil
+// 文件末尾不完整的 tx id 被忽略，只读打开和迁移之前都不修改文件
+func loadTxnMeta(fs storage.FileSystem, path string, keyring *storage.Keyring, report *RecoveryReport) (*TxnMeta, error) {
+	txnMeta := new(TxnMeta)
+	buf, err := fs.ReadFile(path)
+	if os.IsNotExist(err) {
+		return txnMeta, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// 判断文件是否加密
+	start, recordSize := 0, txIdLen
+	if len(buf) >= len(txnFileMagic) && string(buf[:len(txnFileMagic)]) == txnFileMagic {
+		if keyring == nil {
+			return nil, dberror.ErrEncryptionKeyMissing
+		}
+		start, recordSize = len(txnFileMagic), txIdLen+storage.SealedSize
+	} else {
+		keyring = nil
+	}
+	if len(buf) < start {
+		start = 0
+	}
+	// 崩溃时最后一个 tx id 可能没有写完整
+	if partial := (len(buf) - start) % recordSize; partial != 0 {
+		report.add(TruncatedFile{
+			Path:      path,
+			ValidSize: int64(len(buf) - partial),
+			Discarded: int64(partial),
+			Reason:    io.ErrUnexpectedEOF,
+		})
+		buf = buf[:len(buf)-partial]
+	}
+
+	txnMeta.legacyTxIds = make(map[uint64]struct{})
+	for offset := start; offset < len(buf); offset += recordSize {
+		record := buf[offset : offset+recordSize]
+		if keyring != nil {
+			if record, err = keyring.Open(record, []byte(txnFileMagic)); err != nil {
+				return nil, err
+			}
+		}
+		txId := binary.BigEndian.Uint64(record)
+		if txId > txnMeta.MaxTxId {
+			txnMeta.MaxTxId = txId
+		}
+		txnMeta.legacyTxIds[txId] = struct{}{}
+	}
+	return txnMeta, nil
+}
+
+// 旧版本的事务没有提交标记，是否提交记录在 DB.TX.META 中
+// 打开时归档活跃文件并重写所有归档文件，重写后的 entry 不再有 tx id，之后删除 DB.TX.META
+// 迁移中断时 DB.TX.META 还在，下次打开时重新迁移
+func (db *DB) migrateTxnMeta() error {
+	if db.txnMeta.legacyTxIds == nil {
+		return nil
+	}
+	if len(db.txnMeta.legacyTxIds) > 0 {
+		for dType := uint16(0); dType < consts.DataStructureNum; dType++ {
+			df, err := db.getActiveFile(dType)
+			if err != nil {
+				return err
+			}
+			if df.Offset > df.DataOffset() {
+				if _, err = db.rotateActiveFile(df); err != nil {
+					return err
+				}
+			}
+			if err = db.reclaimType(dType); err != nil && err != dberror.ErrReclaimUnreached {
+				return err
+			}
+		}
+		if _, err := db.fileOpts.Blobs.Collect(); err != nil {
+			return err
+		}
+	}
+	db.txnMeta.legacyTxIds = nil
+	return db.fileOpts.FS.Remove(db.config.DirPath + consts.DbTxMetaSaveFile)
+}
@@ -0,0 +1,373 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"zeroDB/global/config"
+	"zeroDB/global/consts"
+	"zeroDB/global/dberror"
+	"zeroDB/storage"
+)
+
+func openTxnTestDB(t *testing.T, dir string, blockSize int64) (*DB, *RecoveryReport) {
+	return openTxnTestDBWithFS(t, dir, blockSize, "", nil)
+}
+
+// fs 不为 nil 时使用它，用于在多次打开之间共享内存文件系统
+func openTxnTestConfig(dir string, blockSize int64) config.Config {
+	return config.Config{
+		DirPath:      dir,
+		BlockSize:    blockSize,
+		MaxKeySize:   1 << 10,
+		MaxValueSize: 1 << 20,
+	}
+}
+
+func openTxnTestDBWithFS(t *testing.T, dir string, blockSize int64, fsName string, fs storage.FileSystem) (*DB, *RecoveryReport) {
+	cfg := openTxnTestConfig(dir, blockSize)
+	cfg.FileSystem, cfg.FS = fsName, fs
+	db, report, err := OpenWithRecovery(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, report
+}
+
+// df 中从 offset 开始的提交标记的位置
+func commitOffsets(t *testing.T, df *storage.DBFile, offset int64) (offsets []int64) {
+	for offset < df.Offset {
+		e, err := df.Read(offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.IsCommit() {
+			offsets = append(offsets, offset)
+		}
+		offset += int64(e.Size())
+	}
+	return
+}
+
+func activeFile(t *testing.T, db *DB, dType consts.DataType) *storage.DBFile {
+	df, err := db.getActiveFile(dType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return df
+}
+
+func txnValue(i int) string {
+	return fmt.Sprintf("%0200d", i)
+}
+
+// 检查 keys 的 string value 是否存在并且等于 txnValue
+func checkTxnKeys(t *testing.T, db *DB, keys []string, exist bool) {
+	t.Helper()
+	for i, key := range keys {
+		var val string
+		err := db.Get([]byte(key), &val)
+		if !exist {
+			if err != dberror.ErrKeyNotExist {
+				t.Fatalf("%s: got %q, err %v, want not exist", key, val, err)
+			}
+			continue
+		}
+		if err != nil || val != txnValue(i) {
+			t.Fatalf("%s: got %q, err %v", key, val, err)
+		}
+	}
+}
+
+// 写入多种类型的事务在某个类型中没有提交标记时整个事务被丢弃，其他类型中的提交标记被截断
+func TestTxnMissingMarkerInOneType(t *testing.T) {
+	dir := t.TempDir() + "/"
+	db, _ := openTxnTestDB(t, dir, 8<<20)
+	if err := db.Set([]byte("base"), []byte("v0")); err != nil {
+		t.Fatal(err)
+	}
+	strFile, hashFile := activeFile(t, db, consts.String), activeFile(t, db, consts.Hash)
+	strStart, hashStart := strFile.Offset, hashFile.Offset
+
+	keys := []string{"k0", "k1"}
+	err := db.Txn(func(tx *Txn) error {
+		for i, key := range keys {
+			if err := tx.Set([]byte(key), txnValue(i)); err != nil {
+				return err
+			}
+		}
+		return tx.HSet([]byte("h"), []byte("f"), []byte("v1"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	strMarkers, hashMarkers := commitOffsets(t, strFile, strStart), commitOffsets(t, hashFile, hashStart)
+	if len(strMarkers) != 1 || len(hashMarkers) != 1 {
+		t.Fatalf("got %d string markers and %d hash markers, want 1 and 1", len(strMarkers), len(hashMarkers))
+	}
+	strPath, hashPath := strFile.File.Name(), hashFile.File.Name()
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 崩溃时 string 的提交标记已经写入，hash 的还没有
+	if err = os.Truncate(hashPath, hashMarkers[0]); err != nil {
+		t.Fatal(err)
+	}
+	db, report := openTxnTestDB(t, dir, 8<<20)
+	checkTxnKeys(t, db, keys, false)
+	if val := db.HGet([]byte("h"), []byte("f")); val != nil {
+		t.Fatalf("hash field of the aborted txn: got %q", val)
+	}
+	var base string
+	if err = db.Get([]byte("base"), &base); err != nil || base != "v0" {
+		t.Fatalf("base: got %q, err %v", base, err)
+	}
+	if len(report.Truncated) != 1 {
+		t.Fatalf("got %d truncated files, want 1: %v", len(report.Truncated), report.Truncated)
+	}
+	if tf := report.Truncated[0]; tf.Path != strPath || tf.ValidSize != strMarkers[0] || tf.Reason != dberror.ErrTxnIncomplete {
+		t.Fatalf("unexpected truncation %v", tf)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 截断之后再次打开得到相同的结果，不需要再修复
+	db, report = openTxnTestDB(t, dir, 8<<20)
+	defer db.Close()
+	checkTxnKeys(t, db, keys, false)
+	if report.HasTruncated() {
+		t.Fatalf("unexpected truncation on reopen: %v", report.Truncated)
+	}
+}
+
+// 事务的 entry 跨越两个文件时，提交标记在新的活跃文件中，加载时归档文件中的 entry 也要等待这个标记
+func TestTxnSpanningRotation(t *testing.T) {
+	const blockSize = 4 << 10
+	keys := make([]string, 40)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k%d", i)
+	}
+	setKeys := func(tx *Txn) error {
+		for i, key := range keys {
+			if err := tx.Set([]byte(key), txnValue(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	t.Run("committed", func(t *testing.T) {
+		dir := t.TempDir() + "/"
+		db, _ := openTxnTestDB(t, dir, blockSize)
+		if err := db.Txn(setKeys); err != nil {
+			t.Fatal(err)
+		}
+		if len(db.archFiles[consts.String]) == 0 {
+			t.Fatal("txn did not span a rotation")
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		db, report := openTxnTestDB(t, dir, blockSize)
+		defer db.Close()
+		checkTxnKeys(t, db, keys, true)
+		if report.HasTruncated() {
+			t.Fatalf("unexpected truncation: %v", report.Truncated)
+		}
+	})
+
+	t.Run("marker lost", func(t *testing.T) {
+		dir := t.TempDir() + "/"
+		db, _ := openTxnTestDB(t, dir, blockSize)
+		if err := db.Txn(setKeys); err != nil {
+			t.Fatal(err)
+		}
+		if len(db.archFiles[consts.String]) == 0 {
+			t.Fatal("txn did not span a rotation")
+		}
+		df := activeFile(t, db, consts.String)
+		markers := commitOffsets(t, df, df.DataOffset())
+		if len(markers) != 1 {
+			t.Fatalf("got %d markers in the active file, want 1", len(markers))
+		}
+		path := df.File.Name()
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// 归档文件中的 entry 从 hint 文件加载，同样因为没有提交标记而被丢弃
+		if err := os.Truncate(path, markers[0]); err != nil {
+			t.Fatal(err)
+		}
+		db, _ = openTxnTestDB(t, dir, blockSize)
+		defer db.Close()
+		checkTxnKeys(t, db, keys, false)
+	})
+}
+
+// 重写单个归档文件时保留事务的 tx id 和提交标记，重新打开之后事务仍然是提交的
+func TestTxnMarkerSurvivesCompactFile(t *testing.T) {
+	dir := t.TempDir() + "/"
+	db, _ := openTxnTestDB(t, dir, 8<<20)
+	// 之后被覆盖，使归档文件中有 dead bytes
+	for i := 0; i < 10; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("old%d", i)), []byte("stale")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := []string{"k0", "k1", "k2"}
+	err := db.Txn(func(tx *Txn) error {
+		for i, key := range keys {
+			if err := tx.Set([]byte(key), txnValue(i)); err != nil {
+				return err
+			}
+		}
+		return tx.HSet([]byte("h"), []byte("f"), []byte("v1"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlock := db.lockMgr.Lock(consts.String)
+	df := activeFile(t, db, consts.String)
+	if _, err = db.rotateActiveFile(df); err != nil {
+		unlock()
+		t.Fatal(err)
+	}
+	unlock()
+	for i := 0; i < 10; i++ {
+		if err = db.Set([]byte(fmt.Sprintf("old%d", i)), []byte("fresh")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = db.compactFile(df); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.archFiles[consts.String]) != 1 {
+		t.Fatalf("got %d archived files, want 1", len(db.archFiles[consts.String]))
+	}
+	for _, compacted := range db.archFiles[consts.String] {
+		if compacted.Id == df.Id {
+			t.Fatal("archived file was not replaced")
+		}
+		if compacted.Offset >= df.Offset {
+			t.Fatalf("compacted file is %d bytes, original %d bytes", compacted.Offset, df.Offset)
+		}
+		if markers := commitOffsets(t, compacted, compacted.DataOffset()); len(markers) != 1 {
+			t.Fatalf("got %d markers in the compacted file, want 1", len(markers))
+		}
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, report := openTxnTestDB(t, dir, 8<<20)
+	defer db.Close()
+	checkTxnKeys(t, db, keys, true)
+	if val := db.HGet([]byte("h"), []byte("f")); string(val) != "v1" {
+		t.Fatalf("hash field: got %q", val)
+	}
+	var old string
+	if err = db.Get([]byte("old0"), &old); err != nil || old != "fresh" {
+		t.Fatalf("old0: got %q, err %v", old, err)
+	}
+	if report.HasTruncated() {
+		t.Fatalf("unexpected truncation: %v", report.Truncated)
+	}
+}
+
+// 截断文件系统中的文件，模拟崩溃时没有写入的部分
+func truncateTestFile(t *testing.T, fs storage.FileSystem, path string, size int64) {
+	t.Helper()
+	f, err := fs.OpenFile(path, os.O_RDWR, storage.FilePerPm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = f.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 提交标记在最后一个归档文件的末尾时，归档文件是只读打开的，截断标记时需要重新以读写方式打开
+func TestTxnMissingMarkerAtArchivedFileEnd(t *testing.T) {
+	for _, fsName := range []string{"os", "mmap", "memory"} {
+		t.Run(fsName, func(t *testing.T) {
+			dir := t.TempDir() + "/"
+			var fs storage.FileSystem = storage.OSFS{}
+			if fsName == "memory" {
+				fs = storage.NewMemFS()
+			}
+			open := func() (*DB, *RecoveryReport) {
+				return openTxnTestDBWithFS(t, dir, 8<<20, fsName, fs)
+			}
+
+			db, _ := open()
+			if err := db.Set([]byte("base"), []byte("v0")); err != nil {
+				t.Fatal(err)
+			}
+			strFile, hashFile := activeFile(t, db, consts.String), activeFile(t, db, consts.Hash)
+			strStart, hashStart := strFile.Offset, hashFile.Offset
+			keys := []string{"k0", "k1"}
+			err := db.Txn(func(tx *Txn) error {
+				for i, key := range keys {
+					if err := tx.Set([]byte(key), txnValue(i)); err != nil {
+						return err
+					}
+				}
+				return tx.HSet([]byte("h"), []byte("f"), []byte("v1"))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			strMarkers, hashMarkers := commitOffsets(t, strFile, strStart), commitOffsets(t, hashFile, hashStart)
+			if len(strMarkers) != 1 || len(hashMarkers) != 1 {
+				t.Fatalf("got %d string markers and %d hash markers, want 1 and 1", len(strMarkers), len(hashMarkers))
+			}
+			// string 的提交标记成为归档文件的最后一个 entry
+			unlock := db.lockMgr.Lock(consts.String)
+			_, err = db.rotateActiveFile(strFile)
+			unlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+			strPath, hashPath := strFile.File.Name(), hashFile.File.Name()
+			if err = db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			truncateTestFile(t, fs, hashPath, hashMarkers[0])
+			db, report := open()
+			checkTxnKeys(t, db, keys, false)
+			if val := db.HGet([]byte("h"), []byte("f")); val != nil {
+				t.Fatalf("hash field of the aborted txn: got %q", val)
+			}
+			if len(report.Truncated) != 1 {
+				t.Fatalf("got %d truncated files, want 1: %v", len(report.Truncated), report.Truncated)
+			}
+			if tf := report.Truncated[0]; tf.Path != strPath || tf.ValidSize != strMarkers[0] || tf.Reason != dberror.ErrTxnIncomplete {
+				t.Fatalf("unexpected truncation %v", tf)
+			}
+			// 截断之后归档文件仍然可以读取
+			var base string
+			if err = db.Get([]byte("base"), &base); err != nil || base != "v0" {
+				t.Fatalf("base: got %q, err %v", base, err)
+			}
+			if err = db.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			db, report = open()
+			defer db.Close()
+			checkTxnKeys(t, db, keys, false)
+			if report.HasTruncated() {
+				t.Fatalf("unexpected truncation on reopen: %v", report.Truncated)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package db
+
+import (
+	"bytes"
+	"time"
+	"zeroDB/global/consts"
+	"zeroDB/global/dberror"
+	"zeroDB/global/utils"
+	"zeroDB/storage"
+)
+
+// HSet see db_hash.go:HSet
+func (tx *Txn) HSet(key, field, value interface{}) (err error) {
+	encKey, encVal, err := tx.db.encode(key, value)
+	if err != nil {
+		return err
+	}
+	if err = tx.db.checkKeyValue(encKey, encVal); err != nil {
+		return
+	}
+
+	encField, err := utils.EncodeValue(field)
+	if err != nil {
+		return err
+	}
+
+	// compare to the old val.
+	oldVal, err := tx.hGetVal(key, field)
+	if err != nil {
+		return err
+	}
+	if oldVal != nil && bytes.Compare(encVal, oldVal) == 0 {
+		return
+	}
+	return tx.hSet(encKey, encField, encVal)
+}
+
+// HSetNx see db_hash.go:HSetNx
+func (tx *Txn) HSetNx(key, field, value interface{}) (err error) {
+	if oldVal, err := tx.hGetVal(key, field); err != nil || oldVal != nil {
+		return err
+	}
+
+	encKey, encVal, err := tx.db.encode(key, value)
+	if err != nil {
+		return err
+	}
+	if err = tx.db.checkKeyValue(encKey, encVal); err != nil {
+		return
+	}
+
+	encField, err := utils.EncodeValue(field)
+	if err != nil {
+		return err
+	}
+	return tx.hSet(encKey, encField, encVal)
+}
+
+func (tx *Txn) hSet(encKey, encField, encVal []byte) (err error) {
+	if err = tx.prepareWrite(consts.Hash, encKey); err != nil {
+		return
+	}
+
+	tx.view.hash.HSet(string(encKey), string(encField), encVal)
+	e := storage.NewEntryWithTxn(encKey, encVal, encField, consts.Hash, consts.HashHSet, tx.id)
+	if err = tx.putEntry(e); err != nil {
+		return
+	}
+
+	eKey := tx.encodeKey(encKey, encField, consts.Hash)
+	tx.keysMap[eKey] = len(tx.writeEntries) - 1
+	return
+}
+
+// HGet see db_hash.go:HGet
+func (tx *Txn) HGet(key, field, dest interface{}) (err error) {
+	val, err := tx.hGetVal(key, field)
+	if err != nil {
+		return err
+	}
+	if len(val) > 0 {
+		err = utils.DecodeValue(val, dest)
+	}
+	return
+}
+
+// field 在事务中的 value，不存在时返回 nil
+func (tx *Txn) hGetVal(key, field interface{}) (val []byte, err error) {
+	encKey, encField, err := tx.db.encode(key, field)
+	if err != nil {
+		return nil, err
+	}
+
+	index, unlock := tx.hashReader(encKey)
+	defer unlock()
+	if index == nil {
+		return
+	}
+	val = index.HGet(string(encKey), string(encField))
+	return
+}
+
+// HGetAll see db_hash.go:HGetAll
+func (tx *Txn) HGetAll(key interface{}) [][]byte {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return nil
+	}
+
+	index, unlock := tx.hashReader(encKey)
+	defer unlock()
+	if index == nil {
+		return nil
+	}
+	return index.HGetAll(string(encKey))
+}
+
+// HDel see db_hash.go:HDel
+func (tx *Txn) HDel(key interface{}, fields ...interface{}) (err error) {
+	if key == nil || len(fields) == 0 {
+		return
+	}
+
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	if tx.keyExpired(consts.Hash, encKey) {
+		return
+	}
+	if err = tx.prepareWrite(consts.Hash, encKey); err != nil {
+		return
+	}
+
+	for _, field := range fields {
+		var encField []byte
+		if encField, err = utils.EncodeValue(field); err != nil {
+			return
+		}
+		if tx.view.hash.HDel(string(encKey), string(encField)) != 1 {
+			continue
+		}
+
+		eKey := tx.encodeKey(encKey, encField, consts.Hash)
+		// del don't need do twice , set then del don't need to set.
+		if idx, ok := tx.keysMap[eKey]; ok {
+			tx.skipIds[idx] = struct{}{}
+		}
+
+		e := storage.NewEntryWithTxn(encKey, nil, encField, consts.Hash, consts.HashHDel, tx.id)
+		if err = tx.putEntry(e); err != nil {
+			return
+		}
+		tx.keysMap[eKey] = len(tx.writeEntries) - 1
+	}
+	return
+}
+
+// HKeyExists see db_hash.go:HKeyExists
+func (tx *Txn) HKeyExists(key interface{}) (ok bool) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return
+	}
+
+	index, unlock := tx.hashReader(encKey)
+	defer unlock()
+	if index == nil {
+		return
+	}
+	return index.HKeyExists(string(encKey))
+}
+
+// HExists see db_hash.go:HExists
+func (tx *Txn) HExists(key, field interface{}) (ok bool) {
+	encKey, encField, err := tx.db.encode(key, field)
+	if err != nil {
+		return false
+	}
+
+	index, unlock := tx.hashReader(encKey)
+	defer unlock()
+	if index == nil {
+		return
+	}
+	return index.HExists(string(encKey), string(encField))
+}
+
+// HLen see db_hash.go:HLen
+func (tx *Txn) HLen(key interface{}) int {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return 0
+	}
+
+	index, unlock := tx.hashReader(encKey)
+	defer unlock()
+	if index == nil {
+		return 0
+	}
+	return index.HLen(string(encKey))
+}
+
+// HKeys see db_hash.go:HKeys
+func (tx *Txn) HKeys(key interface{}) (val []string) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return
+	}
+
+	index, unlock := tx.hashReader(encKey)
+	defer unlock()
+	if index == nil {
+		return
+	}
+	return index.HKeys(string(encKey))
+}
+
+// HVals see db_hash.go:HVals
+func (tx *Txn) HVals(key interface{}) (val [][]byte) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return
+	}
+
+	index, unlock := tx.hashReader(encKey)
+	defer unlock()
+	if index == nil {
+		return
+	}
+	return index.HVals(string(encKey))
+}
+
+// HClear see db_hash.go:HClear
+func (tx *Txn) HClear(key interface{}) (err error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	if !tx.HKeyExists(encKey) {
+		return dberror.ErrKeyNotExist
+	}
+	if err = tx.prepareWrite(consts.Hash, encKey); err != nil {
+		return
+	}
+
+	tx.view.hash.HClear(string(encKey))
+	delete(tx.view.expires[consts.Hash], string(encKey))
+	e := storage.NewEntryWithTxn(encKey, nil, nil, consts.Hash, consts.HashHClear, tx.id)
+	return tx.putEntry(e)
+}
+
+// HExpire see db_hash.go:HExpire
+func (tx *Txn) HExpire(key interface{}, duration int64) (err error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	if duration <= 0 {
+		return dberror.ErrInvalidTTL
+	}
+	if !tx.HKeyExists(encKey) {
+		return dberror.ErrKeyNotExist
+	}
+	if err = tx.prepareWrite(consts.Hash, encKey); err != nil {
+		return
+	}
+
+	deadline := time.Now().Unix() + duration
+	tx.view.expires[consts.Hash][string(encKey)] = deadline
+	e := storage.NewEntryWithTxn(encKey, nil, nil, consts.Hash, consts.HashHExpire, tx.id)
+	e.Expire = uint64(deadline)
+	return tx.putEntry(e)
+}
+
+// HTTL see db_hash.go:HTTL
+func (tx *Txn) HTTL(key interface{}) (ttl int64) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return
+	}
+	return tx.ttl(consts.Hash, encKey)
+}
@@ -0,0 +1,332 @@
+package db
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+	"zeroDB/datastructure/list"
+	"zeroDB/global/consts"
+	"zeroDB/global/dberror"
+	"zeroDB/global/utils"
+	"zeroDB/storage"
+)
+
+// LPush see db_list.go:LPush
+func (tx *Txn) LPush(key interface{}, values ...interface{}) (err error) {
+	return tx.push(key, true, values...)
+}
+
+// RPush see db_list.go:RPush
+func (tx *Txn) RPush(key interface{}, values ...interface{}) (err error) {
+	return tx.push(key, false, values...)
+}
+
+func (tx *Txn) push(key interface{}, front bool, values ...interface{}) (err error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	if err = tx.db.checkKeyValue(encKey, nil); err != nil {
+		return
+	}
+	if err = tx.prepareWrite(consts.List, encKey); err != nil {
+		return
+	}
+
+	for _, v := range values {
+		var encVal []byte
+		if encVal, err = utils.EncodeValue(v); err != nil {
+			return
+		}
+		if err = tx.db.checkKeyValue(encKey, encVal); err != nil {
+			return
+		}
+
+		mark := consts.ListRPush
+		if front {
+			mark = consts.ListLPush
+			tx.view.list.LPush(string(encKey), encVal)
+		} else {
+			tx.view.list.RPush(string(encKey), encVal)
+		}
+		e := storage.NewEntryWithTxn(encKey, encVal, nil, consts.List, mark, tx.id)
+		if err = tx.putEntry(e); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// LPop see db_list.go:LPop
+func (tx *Txn) LPop(key interface{}) ([]byte, error) {
+	return tx.pop(key, true)
+}
+
+// RPop see db_list.go:RPop
+func (tx *Txn) RPop(key interface{}) ([]byte, error) {
+	return tx.pop(key, false)
+}
+
+func (tx *Txn) pop(key interface{}, front bool) (val []byte, err error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.db.checkKeyValue(encKey, nil); err != nil {
+		return
+	}
+	if tx.keyExpired(consts.List, encKey) {
+		return nil, dberror.ErrKeyExpired
+	}
+	if err = tx.prepareWrite(consts.List, encKey); err != nil {
+		return
+	}
+
+	mark := consts.ListRPop
+	if front {
+		mark = consts.ListLPop
+		val = tx.view.list.LPop(string(encKey))
+	} else {
+		val = tx.view.list.RPop(string(encKey))
+	}
+	if val != nil {
+		e := storage.NewEntryWithTxn(encKey, val, nil, consts.List, mark, tx.id)
+		err = tx.putEntry(e)
+	}
+	return
+}
+
+// LIndex see db_list.go:LIndex
+func (tx *Txn) LIndex(key interface{}, idx int) []byte {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return nil
+	}
+
+	index, unlock := tx.listReader(encKey)
+	defer unlock()
+	if index == nil {
+		return nil
+	}
+	return index.LIndex(string(encKey), idx)
+}
+
+// LRem see db_list.go:LRem
+func (tx *Txn) LRem(key, value interface{}, count int) (res int, err error) {
+	encKey, encVal, err := tx.db.encode(key, value)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.db.checkKeyValue(encKey, encVal); err != nil {
+		return
+	}
+	if tx.keyExpired(consts.List, encKey) {
+		return 0, dberror.ErrKeyExpired
+	}
+	if err = tx.prepareWrite(consts.List, encKey); err != nil {
+		return
+	}
+
+	if res = tx.view.list.LRem(string(encKey), encVal, count); res > 0 {
+		c := strconv.Itoa(count)
+		e := storage.NewEntryWithTxn(encKey, encVal, []byte(c), consts.List, consts.ListLRem, tx.id)
+		err = tx.putEntry(e)
+	}
+	return
+}
+
+// LInsert see db_list.go:LInsert
+func (tx *Txn) LInsert(key interface{}, option list.InsertOption, pivot, value interface{}) (count int, err error) {
+	encKey, encVal, err := tx.db.encode(key, value)
+	if err != nil {
+		return 0, err
+	}
+	encPivot, err := utils.EncodeValue(pivot)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.db.checkKeyValue(encKey, encVal); err != nil {
+		return
+	}
+	if strings.Contains(string(encPivot), consts.ExtraSeparator) {
+		return 0, dberror.ErrExtraContainsSeparator
+	}
+	if err = tx.prepareWrite(consts.List, encKey); err != nil {
+		return
+	}
+
+	if count = tx.view.list.LInsert(string(encKey), option, encPivot, encVal); count != -1 {
+		var buf bytes.Buffer
+		buf.Write(encPivot)
+		buf.Write([]byte(consts.ExtraSeparator))
+		buf.Write([]byte(strconv.Itoa(int(option))))
+
+		e := storage.NewEntryWithTxn(encKey, encVal, buf.Bytes(), consts.List, consts.ListLInsert, tx.id)
+		err = tx.putEntry(e)
+	}
+	return
+}
+
+// LSet see db_list.go:LSet
+func (tx *Txn) LSet(key interface{}, idx int, value interface{}) (ok bool, err error) {
+	encKey, encVal, err := tx.db.encode(key, value)
+	if err != nil {
+		return false, err
+	}
+	if err = tx.db.checkKeyValue(encKey, encVal); err != nil {
+		return
+	}
+	if err = tx.prepareWrite(consts.List, encKey); err != nil {
+		return
+	}
+
+	if ok = tx.view.list.LSet(string(encKey), idx, encVal); ok {
+		i := strconv.Itoa(idx)
+		e := storage.NewEntryWithTxn(encKey, encVal, []byte(i), consts.List, consts.ListLSet, tx.id)
+		err = tx.putEntry(e)
+	}
+	return
+}
+
+// LTrim see db_list.go:LTrim
+func (tx *Txn) LTrim(key interface{}, start, end int) (err error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	if err = tx.db.checkKeyValue(encKey, nil); err != nil {
+		return
+	}
+	if tx.keyExpired(consts.List, encKey) {
+		return dberror.ErrKeyExpired
+	}
+	if err = tx.prepareWrite(consts.List, encKey); err != nil {
+		return
+	}
+
+	if res := tx.view.list.LTrim(string(encKey), start, end); res {
+		var buf bytes.Buffer
+		buf.Write([]byte(strconv.Itoa(start)))
+		buf.Write([]byte(consts.ExtraSeparator))
+		buf.Write([]byte(strconv.Itoa(end)))
+
+		e := storage.NewEntryWithTxn(encKey, nil, buf.Bytes(), consts.List, consts.ListLTrim, tx.id)
+		err = tx.putEntry(e)
+	}
+	return
+}
+
+// LRange see db_list.go:LRange
+func (tx *Txn) LRange(key interface{}, start, end int) ([][]byte, error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.db.checkKeyValue(encKey, nil); err != nil {
+		return nil, err
+	}
+
+	index, unlock := tx.listReader(encKey)
+	defer unlock()
+	if index == nil {
+		return nil, nil
+	}
+	return index.LRange(string(encKey), start, end), nil
+}
+
+// LLen see db_list.go:LLen
+func (tx *Txn) LLen(key interface{}) int {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return 0
+	}
+
+	index, unlock := tx.listReader(encKey)
+	defer unlock()
+	if index == nil {
+		return 0
+	}
+	return index.LLen(string(encKey))
+}
+
+// LKeyExists see db_list.go:LKeyExists
+func (tx *Txn) LKeyExists(key interface{}) (ok bool) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return
+	}
+
+	index, unlock := tx.listReader(encKey)
+	defer unlock()
+	if index == nil {
+		return
+	}
+	return index.LKeyExists(string(encKey))
+}
+
+// LValExists see db_list.go:LValExists
+func (tx *Txn) LValExists(key, value interface{}) (ok bool) {
+	encKey, encVal, err := tx.db.encode(key, value)
+	if err != nil {
+		return
+	}
+
+	index, unlock := tx.listReader(encKey)
+	defer unlock()
+	if index == nil {
+		return
+	}
+	return index.LValExists(string(encKey), encVal)
+}
+
+// LClear see db_list.go:LClear
+func (tx *Txn) LClear(key interface{}) (err error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	if !tx.LKeyExists(encKey) {
+		return dberror.ErrKeyNotExist
+	}
+	if err = tx.prepareWrite(consts.List, encKey); err != nil {
+		return
+	}
+
+	tx.view.list.LClear(string(encKey))
+	delete(tx.view.expires[consts.List], string(encKey))
+	e := storage.NewEntryWithTxn(encKey, nil, nil, consts.List, consts.ListLClear, tx.id)
+	return tx.putEntry(e)
+}
+
+// LExpire see db_list.go:LExpire
+func (tx *Txn) LExpire(key interface{}, duration int64) (err error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	if duration <= 0 {
+		return dberror.ErrInvalidTTL
+	}
+	if !tx.LKeyExists(encKey) {
+		return dberror.ErrKeyNotExist
+	}
+	if err = tx.prepareWrite(consts.List, encKey); err != nil {
+		return
+	}
+
+	deadline := time.Now().Unix() + duration
+	tx.view.expires[consts.List][string(encKey)] = deadline
+	e := storage.NewEntryWithTxn(encKey, nil, nil, consts.List, consts.ListLExpire, tx.id)
+	e.Expire = uint64(deadline)
+	return tx.putEntry(e)
+}
+
+// LTTL see db_list.go:LTTL
+func (tx *Txn) LTTL(key interface{}) (ttl int64) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return
+	}
+	return tx.ttl(consts.List, encKey)
+}
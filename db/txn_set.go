@@ -0,0 +1,311 @@
+package db
+
+import (
+	"time"
+	"zeroDB/global/consts"
+	"zeroDB/global/dberror"
+	"zeroDB/global/utils"
+	"zeroDB/storage"
+)
+
+// SAdd see db_set.go:SAdd
+func (tx *Txn) SAdd(key interface{}, members ...interface{}) (err error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	if err = tx.db.checkKeyValue(encKey, nil); err != nil {
+		return
+	}
+	if err = tx.prepareWrite(consts.Set, encKey); err != nil {
+		return
+	}
+
+	for _, mem := range members {
+		var encMem []byte
+		if encMem, err = utils.EncodeValue(mem); err != nil {
+			return
+		}
+		if err = tx.db.checkKeyValue(encKey, encMem); err != nil {
+			return
+		}
+		if err = tx.sAdd(encKey, encMem); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (tx *Txn) sAdd(encKey, encMem []byte) (err error) {
+	if tx.view.set.SIsMember(string(encKey), encMem) {
+		return
+	}
+
+	tx.view.set.SAdd(string(encKey), encMem)
+	e := storage.NewEntryWithTxn(encKey, encMem, nil, consts.Set, consts.SetSAdd, tx.id)
+	if err = tx.putEntry(e); err != nil {
+		return
+	}
+
+	eKey := tx.encodeKey(encKey, encMem, consts.Set)
+	tx.keysMap[eKey] = len(tx.writeEntries) - 1
+	return
+}
+
+// SPop see db_set.go:SPop
+func (tx *Txn) SPop(key interface{}, count int) (values [][]byte, err error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.db.checkKeyValue(encKey, nil); err != nil {
+		return
+	}
+	if tx.keyExpired(consts.Set, encKey) {
+		return nil, dberror.ErrKeyExpired
+	}
+	if err = tx.prepareWrite(consts.Set, encKey); err != nil {
+		return
+	}
+
+	values = tx.view.set.SPop(string(encKey), count)
+	for _, v := range values {
+		if err = tx.sRemEntry(encKey, v); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// SIsMember see db_set.go:SIsMember
+func (tx *Txn) SIsMember(key, member interface{}) (ok bool) {
+	encKey, encMem, err := tx.db.encode(key, member)
+	if err != nil {
+		return
+	}
+
+	index, unlock := tx.setReader(encKey)
+	defer unlock()
+	if index == nil {
+		return
+	}
+	return index.SIsMember(string(encKey), encMem)
+}
+
+// SRandMember see db_set.go:SRandMember
+func (tx *Txn) SRandMember(key interface{}, count int) [][]byte {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return nil
+	}
+
+	index, unlock := tx.setReader(encKey)
+	defer unlock()
+	if index == nil {
+		return nil
+	}
+	return index.SRandMember(string(encKey), count)
+}
+
+// SRem see db_set.go:SRem
+func (tx *Txn) SRem(key interface{}, members ...interface{}) (err error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	if tx.keyExpired(consts.Set, encKey) {
+		return
+	}
+	if err = tx.prepareWrite(consts.Set, encKey); err != nil {
+		return
+	}
+	for _, mem := range members {
+		var encMem []byte
+		if encMem, err = utils.EncodeValue(mem); err != nil {
+			return
+		}
+		if !tx.view.set.SRem(string(encKey), encMem) {
+			continue
+		}
+		if err = tx.sRemEntry(encKey, encMem); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// 记录删除 member 的 entry，事务中之前添加这个 member 的 entry 不再需要写入
+func (tx *Txn) sRemEntry(encKey, encMem []byte) (err error) {
+	eKey := tx.encodeKey(encKey, encMem, consts.Set)
+	if idx, ok := tx.keysMap[eKey]; ok {
+		tx.skipIds[idx] = struct{}{}
+	}
+	e := storage.NewEntryWithTxn(encKey, encMem, nil, consts.Set, consts.SetSRem, tx.id)
+	if err = tx.putEntry(e); err != nil {
+		return
+	}
+	tx.keysMap[eKey] = len(tx.writeEntries) - 1
+	return
+}
+
+// SMove see db_set.go:SMove
+// 事务中记录为 src 的 SRem 和 dst 的 SAdd，两个 key 都参与冲突检测
+func (tx *Txn) SMove(src, dst, member interface{}) (err error) {
+	encSrc, err := utils.EncodeKey(src)
+	if err != nil {
+		return err
+	}
+	encDst, encMem, err := tx.db.encode(dst, member)
+	if err != nil {
+		return err
+	}
+	if err = tx.db.checkKeyValue(encDst, encMem); err != nil {
+		return
+	}
+	if tx.keyExpired(consts.Set, encSrc) || tx.keyExpired(consts.Set, encDst) {
+		return dberror.ErrKeyExpired
+	}
+	if err = tx.prepareWrite(consts.Set, encSrc, encDst); err != nil {
+		return
+	}
+
+	if !tx.view.set.SRem(string(encSrc), encMem) {
+		return
+	}
+	if err = tx.sRemEntry(encSrc, encMem); err != nil {
+		return
+	}
+	return tx.sAdd(encDst, encMem)
+}
+
+// SCard see db_set.go:SCard
+func (tx *Txn) SCard(key interface{}) int {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return 0
+	}
+
+	index, unlock := tx.setReader(encKey)
+	defer unlock()
+	if index == nil {
+		return 0
+	}
+	return index.SCard(string(encKey))
+}
+
+// SMembers see db_set.go:SMembers
+func (tx *Txn) SMembers(key interface{}) (val [][]byte) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return
+	}
+
+	index, unlock := tx.setReader(encKey)
+	defer unlock()
+	if index == nil {
+		return
+	}
+	return index.SMembers(string(encKey))
+}
+
+// SUnion see db_set.go:SUnion
+func (tx *Txn) SUnion(keys ...interface{}) (val [][]byte) {
+	seen := make(map[string]struct{})
+	for _, key := range keys {
+		for _, m := range tx.SMembers(key) {
+			if _, ok := seen[string(m)]; !ok {
+				seen[string(m)] = struct{}{}
+				val = append(val, m)
+			}
+		}
+	}
+	return
+}
+
+// SDiff see db_set.go:SDiff
+func (tx *Txn) SDiff(keys ...interface{}) (val [][]byte) {
+	if len(keys) == 0 {
+		return
+	}
+
+	for _, m := range tx.SMembers(keys[0]) {
+		diff := true
+		for _, key := range keys[1:] {
+			if tx.SIsMember(key, m) {
+				diff = false
+				break
+			}
+		}
+		if diff {
+			val = append(val, m)
+		}
+	}
+	return
+}
+
+// SKeyExists see db_set.go:SKeyExists
+func (tx *Txn) SKeyExists(key interface{}) (ok bool) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return
+	}
+
+	index, unlock := tx.setReader(encKey)
+	defer unlock()
+	if index == nil {
+		return
+	}
+	return index.SKeyExists(string(encKey))
+}
+
+// SClear see db_set.go:SClear
+func (tx *Txn) SClear(key interface{}) (err error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	if !tx.SKeyExists(encKey) {
+		return dberror.ErrKeyNotExist
+	}
+	if err = tx.prepareWrite(consts.Set, encKey); err != nil {
+		return
+	}
+
+	tx.view.set.SClear(string(encKey))
+	delete(tx.view.expires[consts.Set], string(encKey))
+	e := storage.NewEntryWithTxn(encKey, nil, nil, consts.Set, consts.SetSClear, tx.id)
+	return tx.putEntry(e)
+}
+
+// SExpire see db_set.go:SExpire
+func (tx *Txn) SExpire(key interface{}, duration int64) (err error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	if duration <= 0 {
+		return dberror.ErrInvalidTTL
+	}
+	if !tx.SKeyExists(encKey) {
+		return dberror.ErrKeyNotExist
+	}
+	if err = tx.prepareWrite(consts.Set, encKey); err != nil {
+		return
+	}
+
+	deadline := time.Now().Unix() + duration
+	tx.view.expires[consts.Set][string(encKey)] = deadline
+	e := storage.NewEntryWithTxn(encKey, nil, nil, consts.Set, consts.SetSExpire, tx.id)
+	e.Expire = uint64(deadline)
+	return tx.putEntry(e)
+}
+
+// STTL see db_set.go:STTL
+func (tx *Txn) STTL(key interface{}) (ttl int64) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return
+	}
+	return tx.ttl(consts.Set, encKey)
+}
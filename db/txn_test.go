@@ -0,0 +1,63 @@
+package db
+
+import (
+	"testing"
+)
+
+// Unwatch 之后事务中的读取仍然能看到事务自己的写入，并且和提交的结果一致
+func TestTxnReadsAfterUnwatch(t *testing.T) {
+	db, _ := openTxnTestDB(t, t.TempDir()+"/", 8<<20)
+	defer db.Close()
+	if _, err := db.RPush([]byte("l"), []byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := db.Txn(func(tx *Txn) error {
+		if err := tx.Watch([]byte("w")); err != nil {
+			return err
+		}
+		if err := tx.HSet([]byte("h"), []byte("f"), []byte("v")); err != nil {
+			return err
+		}
+		if err := tx.RPush([]byte("l"), []byte("c")); err != nil {
+			return err
+		}
+		if err := tx.SAdd([]byte("s"), []byte("m")); err != nil {
+			return err
+		}
+		tx.Unwatch()
+
+		var val []byte
+		if err := tx.HGet([]byte("h"), []byte("f"), &val); err != nil || string(val) != "v" {
+			t.Errorf("hget after unwatch: got %q, err %v", val, err)
+		}
+		if !tx.SIsMember([]byte("s"), []byte("m")) {
+			t.Error("sismember after unwatch: member of the txn not found")
+		}
+		if n := tx.LLen([]byte("l")); n != 3 {
+			t.Errorf("llen after unwatch: got %d, want 3", n)
+		}
+		popped, err := tx.LPop([]byte("l"))
+		if err != nil || string(popped) != "a" {
+			t.Errorf("lpop after unwatch: got %q, err %v", popped, err)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val := db.HGet([]byte("h"), []byte("f")); string(val) != "v" {
+		t.Fatalf("hget: got %q", val)
+	}
+	if !db.SIsMember([]byte("s"), []byte("m")) {
+		t.Fatal("sismember: member not committed")
+	}
+	vals, err := db.LRange([]byte("l"), 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 2 || string(vals[0]) != "b" || string(vals[1]) != "c" {
+		t.Fatalf("lrange: got %q, want [b c]", vals)
+	}
+}
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"time"
+	"zeroDB/datastructure/hash"
+	"zeroDB/datastructure/list"
+	"zeroDB/datastructure/set"
+	"zeroDB/datastructure/zset"
+	"zeroDB/global/consts"
+	"zeroDB/storage"
+)
+
+// 事务中写入过的 list、hash、set、zset 的 key 的当前状态
+// 第一次写入 key 时从快照中复制，之后事务的写入先修改 view 再记录 entry，和非事务的写入先修改 index 再写入 entry 相同
+// 提交时按顺序把 entry 应用到 index 上得到的就是 view 中的状态，view 只在事务中访问，不需要加锁
+type txnView struct {
+	keys    [consts.DataStructureNum]map[string]struct{} // 已经加载到 view 中的 key
+	expires [consts.DataStructureNum]map[string]int64
+
+	list *list.List
+	hash *hash.Hash
+	set  *set.Set
+	zset *zset.SortedSet
+}
+
+func newTxnView() *txnView {
+	v := &txnView{
+		list: list.New(),
+		hash: hash.New(),
+		set:  set.New(),
+		zset: zset.New(),
+	}
+	for i := 0; i < consts.DataStructureNum; i++ {
+		v.keys[i] = make(map[string]struct{})
+		v.expires[i] = make(map[string]int64)
+	}
+	return v
+}
+
+// key 是否已经加载到 view 中
+func (v *txnView) loaded(dType consts.DataType, key []byte) bool {
+	if v == nil {
+		return false
+	}
+	_, ok := v.keys[dType][string(key)]
+	return ok
+}
+
+// view 中的 key 是否已经过期
+func (v *txnView) expired(dType consts.DataType, key []byte) bool {
+	deadline, ok := v.expires[dType][string(key)]
+	return ok && time.Now().Unix() > deadline
+}
+
+// 第一次写入 key 之前把它在快照中的内容复制到 view
+// 快照中 key 已经过期时 view 中是空的，并且先记录一个 clear，提交时删除 index 中过期的内容和过期时间
+func (tx *Txn) loadView(dType consts.DataType, key []byte) error {
+	if tx.view == nil {
+		tx.view = newTxnView()
+	}
+	if tx.view.loaded(dType, key) {
+		return nil
+	}
+	tx.view.keys[dType][string(key)] = struct{}{}
+
+	unlock := tx.db.lockMgr.RLock(dType)
+	expired := tx.expired(dType, key)
+	if !expired {
+		if deadline, ok := tx.deadline(dType, key); ok {
+			tx.view.expires[dType][string(key)] = deadline
+		}
+		k := string(key)
+		switch dType {
+		case consts.List:
+			copyList(tx.view.list, tx.listIndex(key), k)
+		case consts.Hash:
+			copyHash(tx.view.hash, tx.hashIndex(key), k)
+		case consts.Set:
+			copySet(tx.view.set, tx.setIndex(key), k)
+		case consts.ZSet:
+			copyZSet(tx.view.zset, tx.zsetIndex(key), k)
+		}
+	}
+	unlock()
+
+	if !expired {
+		return nil
+	}
+	var mark uint16
+	switch dType {
+	case consts.List:
+		mark = consts.ListLClear
+	case consts.Hash:
+		mark = consts.HashHClear
+	case consts.Set:
+		mark = consts.SetSClear
+	case consts.ZSet:
+		mark = consts.ZSetZClear
+	}
+	return tx.putEntry(storage.NewEntryWithTxn(key, nil, nil, dType, mark, tx.id))
+}
+
+// 集合类型的写入修改 view 之前调用，检查事务是否可以写入并加载 keys
+func (tx *Txn) prepareWrite(dType consts.DataType, keys ...[]byte) error {
+	if err := tx.writable(); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := tx.loadView(dType, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 事务中 key 的过期时间，写入过时使用 view，否则使用快照
+func (tx *Txn) ttl(dType consts.DataType, key []byte) (ttl int64) {
+	var (
+		deadline int64
+		ok       bool
+	)
+	if tx.view.loaded(dType, key) {
+		deadline, ok = tx.view.expires[dType][string(key)]
+	} else {
+		unlock := tx.db.lockMgr.RLock(dType)
+		deadline, ok = tx.deadline(dType, key)
+		unlock()
+	}
+	if !ok || time.Now().Unix() > deadline {
+		return
+	}
+	return deadline - time.Now().Unix()
+}
+
+// 事务中读取 list 的 key 使用的 index：写入过 key 时是 view，否则是快照，key 已经过期时为 nil，读取之后调用 unlock
+func (tx *Txn) listReader(key []byte) (idx *list.List, unlock func()) {
+	if tx.view.loaded(consts.List, key) {
+		if !tx.view.expired(consts.List, key) {
+			idx = tx.view.list
+		}
+		return idx, func() {}
+	}
+	unlock = tx.db.lockMgr.RLock(consts.List)
+	if !tx.expired(consts.List, key) {
+		idx = tx.listIndex(key)
+	}
+	return
+}
+
+// 和 listReader 相同，读取 hash
+func (tx *Txn) hashReader(key []byte) (idx *hash.Hash, unlock func()) {
+	if tx.view.loaded(consts.Hash, key) {
+		if !tx.view.expired(consts.Hash, key) {
+			idx = tx.view.hash
+		}
+		return idx, func() {}
+	}
+	unlock = tx.db.lockMgr.RLock(consts.Hash)
+	if !tx.expired(consts.Hash, key) {
+		idx = tx.hashIndex(key)
+	}
+	return
+}
+
+// 和 listReader 相同，读取 set
+func (tx *Txn) setReader(key []byte) (idx *set.Set, unlock func()) {
+	if tx.view.loaded(consts.Set, key) {
+		if !tx.view.expired(consts.Set, key) {
+			idx = tx.view.set
+		}
+		return idx, func() {}
+	}
+	unlock = tx.db.lockMgr.RLock(consts.Set)
+	if !tx.expired(consts.Set, key) {
+		idx = tx.setIndex(key)
+	}
+	return
+}
+
+// 和 listReader 相同，读取 zset
+func (tx *Txn) zsetReader(key []byte) (idx *zset.SortedSet, unlock func()) {
+	if tx.view.loaded(consts.ZSet, key) {
+		if !tx.view.expired(consts.ZSet, key) {
+			idx = tx.view.zset
+		}
+		return idx, func() {}
+	}
+	unlock = tx.db.lockMgr.RLock(consts.ZSet)
+	if !tx.expired(consts.ZSet, key) {
+		idx = tx.zsetIndex(key)
+	}
+	return
+}
+
+// 把 src 中 key 的内容复制到 dst
+func copyList(dst, src *list.List, key string) {
+	if values := src.LRange(key, 0, -1); len(values) > 0 {
+		dst.RPush(key, values...)
+	}
+}
+
+func copyHash(dst, src *hash.Hash, key string) {
+	values := src.HGetAll(key)
+	for i := 0; i+1 < len(values); i += 2 {
+		dst.HSet(key, string(values[i]), values[i+1])
+	}
+}
+
+func copySet(dst, src *set.Set, key string) {
+	for _, member := range src.SMembers(key) {
+		dst.SAdd(key, member)
+	}
+}
+
+func copyZSet(dst, src *zset.SortedSet, key string) {
+	values := src.ZRangeWithScores(key, 0, -1)
+	for i := 0; i+1 < len(values); i += 2 {
+		dst.ZAdd(key, values[i+1].(float64), values[i].(string))
+	}
+}
@@ -0,0 +1,297 @@
+package db
+
+import (
+	"time"
+	"zeroDB/datastructure/zset"
+	"zeroDB/global/consts"
+	"zeroDB/global/dberror"
+	"zeroDB/global/utils"
+	"zeroDB/storage"
+)
+
+// ZAdd see db_zset.go/ZAdd
+func (tx *Txn) ZAdd(key interface{}, score float64, member interface{}) (err error) {
+	encKey, encMember, err := tx.db.encode(key, member)
+	if err != nil {
+		return err
+	}
+	if err = tx.db.checkKeyValue(encKey, encMember); err != nil {
+		return
+	}
+
+	// 快照中已经过期的 key 当作不存在
+	ok, oldScore, err := tx.ZScore(key, member)
+	if err != nil && err != dberror.ErrKeyExpired {
+		return err
+	}
+	if ok && oldScore == score {
+		return nil
+	}
+	return tx.zAdd(encKey, encMember, score)
+}
+
+func (tx *Txn) zAdd(encKey, encMember []byte, score float64) (err error) {
+	if err = tx.prepareWrite(consts.ZSet, encKey); err != nil {
+		return
+	}
+
+	tx.view.zset.ZAdd(string(encKey), score, string(encMember))
+	extra := []byte(utils.Float64ToStr(score))
+	e := storage.NewEntryWithTxn(encKey, encMember, extra, consts.ZSet, consts.ZSetZAdd, tx.id)
+	if err = tx.putEntry(e); err != nil {
+		return
+	}
+
+	eKey := tx.encodeKey(encKey, encMember, consts.ZSet)
+	tx.keysMap[eKey] = len(tx.writeEntries) - 1
+	return
+}
+
+// ZScore see db_zset.go/ZScore
+func (tx *Txn) ZScore(key, member interface{}) (exist bool, score float64, err error) {
+	encKey, encMember, err := tx.db.encode(key, member)
+	if err != nil {
+		return false, 0, err
+	}
+
+	index, unlock := tx.zsetReader(encKey)
+	defer unlock()
+	if index == nil {
+		err = dberror.ErrKeyExpired
+		return
+	}
+	exist, score = index.ZScore(string(encKey), string(encMember))
+	return
+}
+
+// ZCard see db_zset.go/ZCard
+func (tx *Txn) ZCard(key interface{}) int {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return 0
+	}
+
+	index, unlock := tx.zsetReader(encKey)
+	defer unlock()
+	if index == nil {
+		return 0
+	}
+	return index.ZCard(string(encKey))
+}
+
+// ZRank see db_zset.go/ZRank
+func (tx *Txn) ZRank(key, member interface{}) int64 {
+	return tx.zRank(key, member, false)
+}
+
+// ZRevRank see db_zset.go/ZRevRank
+func (tx *Txn) ZRevRank(key, member interface{}) int64 {
+	return tx.zRank(key, member, true)
+}
+
+func (tx *Txn) zRank(key, member interface{}, reverse bool) int64 {
+	encKey, encMember, err := tx.db.encode(key, member)
+	if err != nil {
+		return -1
+	}
+	if err = tx.db.checkKeyValue(encKey, encMember); err != nil {
+		return -1
+	}
+
+	index, unlock := tx.zsetReader(encKey)
+	defer unlock()
+	if index == nil {
+		return -1
+	}
+	if reverse {
+		return index.ZRevRank(string(encKey), string(encMember))
+	}
+	return index.ZRank(string(encKey), string(encMember))
+}
+
+// ZIncrBy see db_zset.go/ZIncrBy
+func (tx *Txn) ZIncrBy(key interface{}, increment float64, member interface{}) (float64, error) {
+	encKey, encMember, err := tx.db.encode(key, member)
+	if err != nil {
+		return increment, err
+	}
+	if err = tx.db.checkKeyValue(encKey, encMember); err != nil {
+		return increment, err
+	}
+	if err = tx.prepareWrite(consts.ZSet, encKey); err != nil {
+		return increment, err
+	}
+
+	// 在 view 中计算新的 score，记录为 ZAdd
+	increment = tx.view.zset.ZIncrBy(string(encKey), increment, string(encMember))
+	return increment, tx.zAdd(encKey, encMember, increment)
+}
+
+// ZRange see db_zset.go/ZRange
+func (tx *Txn) ZRange(key interface{}, start, stop int) []interface{} {
+	return tx.zRange(key, func(index *zset.SortedSet, k string) []interface{} {
+		return index.ZRange(k, start, stop)
+	})
+}
+
+// ZRangeWithScores see db_zset.go/ZRangeWithScores
+func (tx *Txn) ZRangeWithScores(key interface{}, start, stop int) []interface{} {
+	return tx.zRange(key, func(index *zset.SortedSet, k string) []interface{} {
+		return index.ZRangeWithScores(k, start, stop)
+	})
+}
+
+// ZRevRange see db_zset.go/ZRevRange
+func (tx *Txn) ZRevRange(key interface{}, start, stop int) []interface{} {
+	return tx.zRange(key, func(index *zset.SortedSet, k string) []interface{} {
+		return index.ZRevRange(k, start, stop)
+	})
+}
+
+// ZRevRangeWithScores see db_zset.go/ZRevRangeWithScores
+func (tx *Txn) ZRevRangeWithScores(key interface{}, start, stop int) []interface{} {
+	return tx.zRange(key, func(index *zset.SortedSet, k string) []interface{} {
+		return index.ZRevRangeWithScores(k, start, stop)
+	})
+}
+
+// ZGetByRank see db_zset.go/ZGetByRank
+func (tx *Txn) ZGetByRank(key interface{}, rank int) []interface{} {
+	return tx.zRange(key, func(index *zset.SortedSet, k string) []interface{} {
+		return index.ZGetByRank(k, rank)
+	})
+}
+
+// ZRevGetByRank see db_zset.go/ZRevGetByRank
+func (tx *Txn) ZRevGetByRank(key interface{}, rank int) []interface{} {
+	return tx.zRange(key, func(index *zset.SortedSet, k string) []interface{} {
+		return index.ZRevGetByRank(k, rank)
+	})
+}
+
+// ZScoreRange see db_zset.go/ZScoreRange
+func (tx *Txn) ZScoreRange(key interface{}, min, max float64) []interface{} {
+	return tx.zRange(key, func(index *zset.SortedSet, k string) []interface{} {
+		return index.ZScoreRange(k, min, max)
+	})
+}
+
+// ZRevScoreRange see db_zset.go/ZRevScoreRange
+func (tx *Txn) ZRevScoreRange(key interface{}, max, min float64) []interface{} {
+	return tx.zRange(key, func(index *zset.SortedSet, k string) []interface{} {
+		return index.ZRevScoreRange(k, max, min)
+	})
+}
+
+// 在事务中 key 所在的 index 上执行一个范围查询
+func (tx *Txn) zRange(key interface{}, fn func(index *zset.SortedSet, k string) []interface{}) []interface{} {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return nil
+	}
+	if err = tx.db.checkKeyValue(encKey, nil); err != nil {
+		return nil
+	}
+
+	index, unlock := tx.zsetReader(encKey)
+	defer unlock()
+	if index == nil {
+		return nil
+	}
+	return fn(index, string(encKey))
+}
+
+// ZRem see db_zset.go/ZRem
+func (tx *Txn) ZRem(key, member interface{}) (err error) {
+	encKey, encMember, err := tx.db.encode(key, member)
+	if err != nil {
+		return err
+	}
+	if tx.keyExpired(consts.ZSet, encKey) {
+		return
+	}
+	if err = tx.prepareWrite(consts.ZSet, encKey); err != nil {
+		return
+	}
+	if !tx.view.zset.ZRem(string(encKey), string(encMember)) {
+		return
+	}
+
+	eKey := tx.encodeKey(encKey, encMember, consts.ZSet)
+	if idx, ok := tx.keysMap[eKey]; ok {
+		tx.skipIds[idx] = struct{}{}
+	}
+
+	e := storage.NewEntryWithTxn(encKey, encMember, nil, consts.ZSet, consts.ZSetZRem, tx.id)
+	if err = tx.putEntry(e); err != nil {
+		return
+	}
+	tx.keysMap[eKey] = len(tx.writeEntries) - 1
+	return
+}
+
+// ZKeyExists see db_zset.go/ZKeyExists
+func (tx *Txn) ZKeyExists(key interface{}) (ok bool) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return
+	}
+
+	index, unlock := tx.zsetReader(encKey)
+	defer unlock()
+	if index == nil {
+		return
+	}
+	return index.ZKeyExists(string(encKey))
+}
+
+// ZClear see db_zset.go/ZClear
+func (tx *Txn) ZClear(key interface{}) (err error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	if !tx.ZKeyExists(encKey) {
+		return dberror.ErrKeyNotExist
+	}
+	if err = tx.prepareWrite(consts.ZSet, encKey); err != nil {
+		return
+	}
+
+	tx.view.zset.ZClear(string(encKey))
+	delete(tx.view.expires[consts.ZSet], string(encKey))
+	e := storage.NewEntryWithTxn(encKey, nil, nil, consts.ZSet, consts.ZSetZClear, tx.id)
+	return tx.putEntry(e)
+}
+
+// ZExpire see db_zset.go/ZExpire
+func (tx *Txn) ZExpire(key interface{}, duration int64) (err error) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	if duration <= 0 {
+		return dberror.ErrInvalidTTL
+	}
+	if !tx.ZKeyExists(encKey) {
+		return dberror.ErrKeyNotExist
+	}
+	if err = tx.prepareWrite(consts.ZSet, encKey); err != nil {
+		return
+	}
+
+	deadline := time.Now().Unix() + duration
+	tx.view.expires[consts.ZSet][string(encKey)] = deadline
+	e := storage.NewEntryWithTxn(encKey, nil, nil, consts.ZSet, consts.ZSetZExpire, tx.id)
+	e.Expire = uint64(deadline)
+	return tx.putEntry(e)
+}
+
+// ZTTL see db_zset.go/ZTTL
+func (tx *Txn) ZTTL(key interface{}) (ttl int64) {
+	encKey, err := utils.EncodeKey(key)
+	if err != nil {
+		return
+	}
+	return tx.ttl(consts.ZSet, encKey)
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"zeroDB/global/dberror"
+	"zeroDB/global/utils"
+)
+
+// Watcher 记录监视的 key 在开始监视时的版本，不创建事务的快照，不影响其他写入
+// 使用 Txn.AddWatcher 的事务提交时，这些 key 在开始监视之后在任何类型中被修改过则返回 ErrTxnConflict
+// 监视期间这些 key 的版本不会被清除，使用后必须 Unwatch
+type Watcher struct {
+	db       *DB
+	versions map[string]uint64
+}
+
+// NewWatcher create a new watcher without any key.
+func (db *DB) NewWatcher() *Watcher {
+	return &Watcher{db: db, versions: make(map[string]uint64)}
+}
+
+// Watch 开始监视 keys，已经监视的 key 保留第一次监视时的版本
+func (w *Watcher) Watch(keys ...interface{}) error {
+	encKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		encKey, err := utils.EncodeKey(key)
+		if err != nil {
+			return err
+		}
+		if len(encKey) == 0 {
+			return dberror.ErrEmptyKey
+		}
+		encKeys = append(encKeys, string(encKey))
+	}
+
+	m := w.db.mvcc
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range encKeys {
+		if _, ok := w.versions[key]; ok {
+			continue
+		}
+		w.versions[key] = m.version(key)
+		m.watched[key]++
+	}
+	return nil
+}
+
+// Unwatch 取消监视所有的 key
+func (w *Watcher) Unwatch() {
+	m := w.db.mvcc
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range w.versions {
+		if m.watched[key]--; m.watched[key] > 0 {
+			continue
+		}
+		delete(m.watched, key)
+		// 没有活跃的事务时版本只是为了监视而保留的
+		if len(m.active) == 0 {
+			for dType := range m.versions {
+				delete(m.versions[dType], key)
+			}
+		}
+	}
+	w.versions = make(map[string]uint64)
+}
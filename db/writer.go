@@ -0,0 +1,180 @@
+package db
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"zeroDB/global/dberror"
+)
+
+const (
+	// 一个批次默认最多包含的 fsync 请求数量
+	defaultGroupCommitMaxBatch = 128
+)
+
+type (
+	// groupWriter 合并并发写入的 fsync
+	// 调用者持有该类型的写锁写入 entry 并更新 index，释放锁之后再等待 fsync，同一批次的等待者只 fsync 一次
+	groupWriter struct {
+		db       *DB
+		reqs     chan *syncRequest
+		maxBatch int
+		maxWait  time.Duration
+		mu       sync.RWMutex // 关闭时等待正在提交的请求进入队列
+		closed   bool
+		stop     chan struct{}
+		done     chan struct{}
+
+		// 已经写入的需要持久化的 entry 数量，以及其中已经 fsync 的数量
+		appended uint64
+		synced   uint64
+
+		// 批次的统计信息
+		batches      uint64
+		entries      uint64
+		maxBatchSize uint64
+	}
+
+	syncRequest struct {
+		seq uint64 // 需要持久化的 appended
+		err chan error
+	}
+)
+
+func newGroupWriter(db *DB, maxBatch int, maxWait time.Duration) *groupWriter {
+	if maxBatch <= 0 {
+		maxBatch = defaultGroupCommitMaxBatch
+	}
+	w := &groupWriter{
+		db:       db,
+		reqs:     make(chan *syncRequest, maxBatch),
+		maxBatch: maxBatch,
+		maxWait:  maxWait,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// 记录写入了一个需要持久化的 entry，调用者持有该类型的写锁
+func (w *groupWriter) append() {
+	atomic.AddUint64(&w.appended, 1)
+}
+
+// 等待之前写入的 entry 都持久化之后返回，调用时不能持有类型的锁
+func (w *groupWriter) wait() error {
+	seq := atomic.LoadUint64(&w.appended)
+	if seq <= atomic.LoadUint64(&w.synced) {
+		return nil
+	}
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		// 关闭时会 fsync 之前的所有写入
+		if seq <= atomic.LoadUint64(&w.synced) {
+			return nil
+		}
+		return dberror.ErrDBIsClosed
+	}
+	req := &syncRequest{seq: seq, err: make(chan error, 1)}
+	w.reqs <- req
+	w.mu.RUnlock()
+	return <-req.err
+}
+
+func (w *groupWriter) run() {
+	defer close(w.done)
+	for {
+		var req *syncRequest
+		select {
+		case req = <-w.reqs:
+		case <-w.stop:
+			// 处理关闭前已经提交的请求，最后持久化所有的写入
+			for {
+				select {
+				case req = <-w.reqs:
+					w.commit(w.collect(req))
+				default:
+					w.commit(nil)
+					return
+				}
+			}
+		}
+		w.commit(w.collect(req))
+	}
+}
+
+// 从第一个请求开始收集一个批次，达到 maxBatch 或者等待超过 maxWait 时结束
+func (w *groupWriter) collect(first *syncRequest) []*syncRequest {
+	batch := []*syncRequest{first}
+	var timeout <-chan time.Time
+	if w.maxWait > 0 {
+		timer := time.NewTimer(w.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for len(batch) < w.maxBatch {
+		select {
+		case req := <-w.reqs:
+			batch = append(batch, req)
+			continue
+		default:
+		}
+		if timeout == nil {
+			break
+		}
+		select {
+		case req := <-w.reqs:
+			batch = append(batch, req)
+			continue
+		case <-timeout:
+		}
+		break
+	}
+	return batch
+}
+
+// fsync 一次，然后通知批次中的所有等待者
+func (w *groupWriter) commit(batch []*syncRequest) {
+	// 在 fsync 之前读取，批次中的请求在入队之前已经写入，都会被这次 fsync 持久化
+	target := atomic.LoadUint64(&w.appended)
+	synced := atomic.LoadUint64(&w.synced)
+
+	var err error
+	if target > synced {
+		start := time.Now()
+		if err = w.db.syncActive(); err == nil {
+			atomic.StoreUint64(&w.synced, target)
+			w.db.markSynced(start)
+		}
+	}
+	for _, req := range batch {
+		req.err <- err
+	}
+
+	if err != nil || target <= synced {
+		return
+	}
+	size := target - synced
+	atomic.AddUint64(&w.batches, 1)
+	atomic.AddUint64(&w.entries, size)
+	for {
+		max := atomic.LoadUint64(&w.maxBatchSize)
+		if size <= max || atomic.CompareAndSwapUint64(&w.maxBatchSize, max, size) {
+			break
+		}
+	}
+}
+
+// 停止写入，等待已经提交的请求完成
+func (w *groupWriter) close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.stop)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
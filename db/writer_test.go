@@ -0,0 +1,85 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"zeroDB/global/config"
+)
+
+func openGroupCommitDB(t testing.TB) *DB {
+	cfg := config.Config{
+		DirPath:            t.TempDir() + "/",
+		BlockSize:          8 << 20,
+		MaxKeySize:         1 << 10,
+		MaxValueSize:       1 << 20,
+		FsyncPolicy:        "always",
+		GroupCommitMaxWait: time.Millisecond,
+	}
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// 同一类型的并发写入在释放锁之后等待 fsync，可以合并到同一个批次中
+func TestGroupCommitSameTypeBatches(t *testing.T) {
+	db := openGroupCommitDB(t)
+	defer db.Close()
+
+	const workers, writes = 16, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				if err := db.Set([]byte(fmt.Sprintf("key-%d-%d", i, j)), []byte("value")); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	stats := db.Stats()
+	if stats.GroupCommitEntries != workers*writes {
+		t.Fatalf("group commit entries: got %d, want %d", stats.GroupCommitEntries, workers*writes)
+	}
+	if stats.GroupCommitMaxBatchSize <= 1 {
+		t.Fatalf("concurrent writes of the same type were not batched, max batch size %d", stats.GroupCommitMaxBatchSize)
+	}
+	if stats.GroupCommitBatches >= workers*writes {
+		t.Fatalf("%d batches for %d writes", stats.GroupCommitBatches, workers*writes)
+	}
+}
+
+func BenchmarkGroupCommitSameType(b *testing.B) {
+	db := openGroupCommitDB(b)
+	defer db.Close()
+
+	var n int64
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			key := fmt.Sprintf("key-%d", atomic.AddInt64(&n, 1))
+			if _, err := db.HSet([]byte("hash"), []byte(key), []byte("value")); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	b.ReportMetric(db.Stats().GroupCommitAvgBatchSize, "entries/batch")
+}
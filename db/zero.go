@@ -7,19 +7,18 @@ import (
 	"io"
 	"log"
 	"os"
-	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 	"zeroDB/datastructure/hash"
 	"zeroDB/datastructure/list"
+	"zeroDB/datastructure/lru"
 	"zeroDB/datastructure/set"
 	str "zeroDB/datastructure/string"
 	"zeroDB/datastructure/zset"
 	"zeroDB/global/config"
 	"zeroDB/global/consts"
 	"zeroDB/global/dberror"
-	"zeroDB/global/utils"
 	"zeroDB/storage"
 )
 
@@ -47,20 +46,42 @@ type (
 	}
 
 	DB struct {
-		activeFile   *sync.Map
-		archFiles    ArchivedFiles // The archived files.
-		strIndex     *StrIdx       // String indexes
-		listIndex    *ListIdx      // List indexes
-		hashIndex    *HashIdx      // Hash indexes
-		setIndex     *SetIdx       // Set indexes
-		zsetIndex    *ZsetIdx      // Sorted set indexes
-		config       config.Config
-		mu           sync.RWMutex
-		lockMgr      *LockMgr // lockMgr controls isolation of read and write.
-		txnMeta      *TxnMeta // Txn meta info used in transaction.
-		expires      Expires
-		isReclaiming bool // Indicates whether the db is reclaiming, see Reclaim
-		closed       uint32
+		activeFile  *sync.Map
+		archFiles   ArchivedFiles     // The archived files.
+		manifest    *storage.Manifest // 每种类型有效的 dbfile 和它们的加载顺序
+		strIndex    *StrIdx           // String indexes
+		listIndex   *ListIdx          // List indexes
+		hashIndex   *HashIdx          // Hash indexes
+		setIndex    *SetIdx           // Set indexes
+		zsetIndex   *ZsetIdx          // Sorted set indexes
+		config      config.Config
+		mu          sync.RWMutex
+		lockMgr     *LockMgr // lockMgr controls isolation of read and write.
+		txnMeta     *TxnMeta // Txn meta info used in transaction.
+		mvcc        *mvcc    // 活跃事务的快照和快照之后被修改的 key
+		expires     Expires
+		reclaimMu   sync.Mutex
+		reclaiming  map[consts.DataType]bool // 正在 reclaim 的数据类型，见 Reclaim 和 ReclaimType
+		reclaimWg   sync.WaitGroup           // 关闭时等待正在进行的 reclaim
+		reclaimStop bool                     // 正在关闭，不再开始新的 reclaim
+		closed      uint32
+		recovery    *RecoveryReport  // 启动时对活跃文件的修复记录
+		fileOpts    *storage.Options // dbfile 的读写选项
+		writer      *groupWriter     // fsync policy 为 always 时合并并发写入的 fsync，为 nil 时不需要等待
+		fsyncPolicy FsyncPolicy
+		readOnly    bool                         // 以只读方式打开，见 OpenReadOnly
+		dirLock     io.Closer                    // 数据目录的锁，关闭时释放
+		syncer      *syncer                      // fsync policy 为 everysec 时在后台 fsync
+		lastSync    int64                        // 最近一次成功 fsync 的时间，UnixNano，原子操作
+		valueCache  *lru.Cache                   // key-only 模式下 string value 的缓存，为 nil 时不缓存
+		garbage     map[consts.DataType]*garbage // 每个 dbfile 的 dead bytes
+		reclaimer   *reclaimer                   // 后台回收 dead bytes 比例超过阈值的文件
+		hints       *hintWriter                  // 在后台为归档的文件生成 hint 文件，只读打开时为 nil
+
+		// 后台定时生成集合类型的 checkpoint，没有开启时为 nil
+		checkpointer *checkpointer
+		// 每种类型最近一次保存或者启动时加载的 checkpoint 的位置，没有变化时不需要重新生成
+		checkpointed [consts.DataStructureNum]checkpointPos
 	}
 	//存档的文件，只读不写
 	ArchivedFiles map[consts.DataType]map[uint32]*storage.DBFile
@@ -99,75 +120,312 @@ func newStrIdx() *StrIdx {
 }
 
 // 开启一个db实例. 用后必须关闭
+// 活跃文件末尾因崩溃而不完整的数据会被丢弃，丢弃的内容会打印到日志中
 func Open(config config.Config) (*DB, error) {
+	db, report, err := OpenWithRecovery(config)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range report.Truncated {
+		log.Printf("zerokv recovered from torn write, %s", t)
+	}
+	return db, nil
+}
+
+// OpenWithRecovery 和 Open 相同，同时返回启动时对文件的修复记录
+// 活跃文件末尾不完整或损坏的 entry 会被截断，归档文件中间的损坏会返回 ErrCorruptedFile
+func OpenWithRecovery(config config.Config) (*DB, *RecoveryReport, error) {
+	return openDB(config, false)
+}
+
+// OpenReadOnly 以只读方式打开 db，对数据目录加共享锁，可以和其他只读的实例同时打开，但是不能和正常打开的实例同时打开
+// 写入、事务提交、Reclaim 和 Checkpoint 返回 ErrReadOnly，不启动后台任务，关闭时不保存配置
+// 不创建或者修改目录中的任何文件，活跃文件末尾不完整的 entry 和过期的 key 只是被忽略
+func OpenReadOnly(config config.Config) (*DB, error) {
+	db, _, err := openDB(config, true)
+	return db, err
+}
+
+// 对数据目录加锁之后打开 db，正常打开时加排他锁，只读打开时加共享锁
+// 目录已经被其他实例锁住时返回 ErrDirLocked
+func openDB(config config.Config, readOnly bool) (*DB, *RecoveryReport, error) {
+	fs, err := configFS(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	//创建文件储存的路径。如果不存在
-	if !utils.Exist(config.DirPath) {
-		if err := os.MkdirAll(config.DirPath, os.ModePerm); err != nil {
-			return nil, err
+	if !readOnly {
+		if err := fs.MkdirAll(config.DirPath, os.ModePerm); err != nil {
+			return nil, nil, err
 		}
 	}
 
-	//从磁盘中加载文件
-	archFiles, activeFileIds, err := storage.Build(config.DirPath, config.BlockSize)
+	dirLock, err := storage.LockDir(fs, config.DirPath, readOnly)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, report, err := loadDB(config, fs, readOnly)
+	if err != nil {
+		dirLock.Close()
+		return nil, nil, err
+	}
+	db.dirLock = dirLock
+	return db, report, nil
+}
+
+// 配置中指定的文件系统，config.FS 不为空时直接使用
+func configFS(config config.Config) (storage.FileSystem, error) {
+	if config.FS != nil {
+		return config.FS, nil
+	}
+	return storage.NewFileSystem(config.FileSystem)
+}
+
+// FileOptions 根据配置返回读写 dbfile 的选项，和 Open 使用的相同，包括压缩、加密和数据目录中的 blob 文件
+// 供直接读写 dbfile 的工具使用，调用者需要对目录加锁，使用完之后关闭 Blobs
+func FileOptions(config config.Config) (*storage.Options, error) {
+	fs, err := configFS(config)
 	if err != nil {
 		return nil, err
 	}
+	return newFileOptions(config, fs)
+}
 
-	// set active files for writing.
-	activeFiles := new(sync.Map)
-	for dataType, fileId := range activeFileIds {
-		file, err := storage.NewDBFile(config.DirPath, fileId, dataType)
-		if err != nil {
+func newFileOptions(config config.Config, fs storage.FileSystem) (fileOpts *storage.Options, err error) {
+	codec, err := storage.ParseCodec(config.Compression)
+	if err != nil {
+		return nil, err
+	}
+	fileOpts = &storage.Options{
+		Codec:             codec,
+		CompressThreshold: config.CompressThreshold,
+		FS:                fs,
+		Stats:             new(storage.Stats),
+	}
+	if config.EncryptionKeyFile != "" {
+		if fileOpts.Keyring, err = storage.LoadKeyring(config.EncryptionKeyFile); err != nil {
 			return nil, err
 		}
-		activeFiles.Store(dataType, file)
 	}
+	if fileOpts.Blobs, err = storage.OpenBlobStore(config.DirPath, config.BlockSize, config.BlobThreshold, fileOpts); err != nil {
+		return nil, err
+	}
+	return fileOpts, nil
+}
 
-	// load txn meta info for transaction.
-	txnMeta, err := LoadTxnMeta(config.DirPath + consts.DbTxMetaSaveFile)
+// 从数据目录中加载文件并建立 index，调用者已经对目录加锁
+// 失败时关闭已经打开的所有文件，调用者负责释放目录的锁
+func loadDB(config config.Config, fs storage.FileSystem, readOnly bool) (_ *DB, _ *RecoveryReport, err error) {
+	fsyncPolicy, err := parseFsyncPolicy(config)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	fileOpts, err := newFileOptions(config, fs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		archFiles   map[uint16]map[uint32]*storage.DBFile
+		manifest    *storage.Manifest
+		activeFiles = new(sync.Map)
+		txnMeta     *TxnMeta
+		hints       *hintWriter
+	)
+	defer func() {
+		if err == nil {
+			return
+		}
+		if hints != nil {
+			hints.close()
+		}
+		activeFiles.Range(func(key, value interface{}) bool {
+			value.(*storage.DBFile).Close(false)
+			return true
+		})
+		storage.CloseDBFiles(archFiles)
+		fileOpts.Blobs.Close(false)
+	}()
+	if readOnly {
+		// 只读打开时不修改目录中的任何文件，不存在的活跃文件也不创建
+		var files map[uint16]*storage.DBFile
+		if archFiles, files, manifest, err = storage.BuildReadOnly(config.DirPath, fileOpts); err != nil {
+			return nil, nil, err
+		}
+		for dataType, file := range files {
+			activeFiles.Store(dataType, file)
+		}
+	} else {
+		// 删除中断的 reclaim 留下的临时文件，它们不在 manifest 中，不会被加载
+		for _, tmpPath := range []string{consts.ReclaimPath, consts.CompactPath} {
+			if err = fs.RemoveAll(config.DirPath + tmpPath); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		//从磁盘中加载文件
+		if archFiles, manifest, err = storage.Build(config.DirPath, config.BlockSize, fileOpts); err != nil {
+			return nil, nil, err
+		}
+
+		// set active files for writing.
+		for dataType := uint16(0); dataType < consts.DataStructureNum; dataType++ {
+			file, err := storage.NewDBFile(config.DirPath, manifest.ActiveFileId(dataType), dataType, fileOpts)
+			if err != nil {
+				return nil, nil, err
+			}
+			activeFiles.Store(dataType, file)
+		}
+	}
+
+	// 旧版本记录已经提交的事务的 DB.TX.META，迁移之前需要使用，见 migrateTxnMeta
+	report := new(RecoveryReport)
+	if txnMeta, err = loadTxnMeta(fs, config.DirPath+consts.DbTxMetaSaveFile, fileOpts.Keyring, report); err != nil {
+		return nil, nil, err
 	}
 	//创建db实例
 	db := &DB{
-		activeFile: activeFiles,
-		archFiles:  archFiles,
-		config:     config,
-		strIndex:   newStrIdx(),
-		listIndex:  newListIdx(),
-		hashIndex:  newHashIdx(),
-		setIndex:   newSetIdx(),
-		zsetIndex:  newZsetIdx(),
-		expires:    make(Expires),
-		txnMeta:    txnMeta,
+		activeFile:  activeFiles,
+		archFiles:   archFiles,
+		manifest:    manifest,
+		config:      config,
+		strIndex:    newStrIdx(),
+		listIndex:   newListIdx(),
+		hashIndex:   newHashIdx(),
+		setIndex:    newSetIdx(),
+		zsetIndex:   newZsetIdx(),
+		expires:     make(Expires),
+		garbage:     make(map[consts.DataType]*garbage),
+		reclaiming:  make(map[consts.DataType]bool),
+		txnMeta:     txnMeta,
+		mvcc:        newMVCC(),
+		recovery:    report,
+		fileOpts:    fileOpts,
+		fsyncPolicy: fsyncPolicy,
+		readOnly:    readOnly,
 	}
 	//初始化内存中的过期map
 	for i := 0; i < consts.DataStructureNum; i++ {
 		db.expires[uint16(i)] = make(map[string]int64)
+		db.garbage[uint16(i)] = newGarbage()
 	}
 	db.lockMgr = newLockMgr(db)
+	if config.KeyOnlyIndex && config.ValueCacheSize > 0 {
+		db.valueCache = lru.New(config.ValueCacheSize)
+	}
+
+	if db.reclaimer, err = newReclaimer(db, config); err != nil {
+		return nil, nil, err
+	}
+	db.checkpointer = newCheckpointer(db, config)
 
 	//以dbfile中的文件创建内存中的数据索引
 	if err := db.loadIdxFromFiles(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return db, nil
+	// 只读打开时不需要写入和后台任务
+	if readOnly {
+		db.reclaimer, db.checkpointer = nil, nil
+		return db, report, nil
+	}
+	hints = newHintWriter()
+	db.hints = hints
+
+	// 旧版本的活跃文件不能追加当前格式的 entry，归档后写入新的活跃文件
+	for dataType := uint16(0); dataType < consts.DataStructureNum; dataType++ {
+		df, err := db.getActiveFile(dataType)
+		if err != nil {
+			return nil, nil, err
+		}
+		if df.Version() != storage.FileVersionCurrent {
+			if _, err = db.rotateActiveFile(df); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if err = db.migrateTxnMeta(); err != nil {
+		return nil, nil, err
+	}
+
+	// 每次写入都需要持久化时，合并并发的写入，一个批次只 fsync 一次
+	switch db.fsyncPolicy {
+	case FsyncAlways:
+		db.writer = newGroupWriter(db, config.GroupCommitMaxBatch, config.GroupCommitMaxWait)
+	case FsyncEverySec:
+		db.syncer = newSyncer(db)
+	}
+
+	if db.reclaimer != nil {
+		go db.reclaimer.run()
+	}
+	if db.checkpointer != nil {
+		go db.checkpointer.run()
+	}
+
+	return db, report, nil
 }
 
 // Close db and save relative configs.
 func (db *DB) Close() (err error) {
+	// 后台 fsync 和后台回收需要持有 db.mu，先停止它们，后台 checkpoint 也在这里停止
+	if db.syncer != nil {
+		db.syncer.close()
+		db.syncer = nil
+	}
+	if db.reclaimer != nil {
+		db.reclaimer.close()
+		db.reclaimer = nil
+	}
+	if db.checkpointer != nil {
+		db.checkpointer.close()
+		db.checkpointer = nil
+	}
+	// reclaim 不持有 db.mu，等待正在进行的 reclaim 完成
+	db.reclaimMu.Lock()
+	db.reclaimStop = true
+	db.reclaimMu.Unlock()
+	db.reclaimWg.Wait()
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if err = db.saveConfig(); err != nil {
+	// 等待已经提交的写入完成
+	if db.writer != nil {
+		db.writer.close()
+	}
+	// 写入和 reclaim 都已经停止，生成剩余的 hint 文件
+	if db.hints != nil {
+		db.hints.close()
+		db.hints = nil
+	}
+
+	// 开启 checkpoint 时关闭前再保存一次，下次启动时不需要重放
+	if db.config.CheckpointInterval > 0 && !db.readOnly {
+		for _, dType := range checkpointTypes {
+			if err := db.writeCheckpoint(dType); err != nil {
+				log.Printf("write checkpoint of %s err: %+v", storage.DBFileSuffixName[dType], err)
+			}
+		}
+	}
+
+	if !db.readOnly {
+		if err = db.saveConfig(); err != nil {
+			return err
+		}
+	}
+
+	// close and sync the blob files before the db files which point to them.
+	// 只读打开的文件不需要 sync
+	if err = db.fileOpts.Blobs.Close(!db.readOnly); err != nil {
 		return err
 	}
 
 	// close and sync the active file.
 	db.activeFile.Range(func(key, value interface{}) bool {
 		if dbFile, ok := value.(*storage.DBFile); ok {
-			if err = dbFile.Close(true); err != nil {
+			if err = dbFile.Close(!db.readOnly); err != nil {
 				return false
 			}
 		}
@@ -180,6 +438,9 @@ func (db *DB) Close() (err error) {
 	// close the archived files.
 	for _, archFile := range db.archFiles {
 		for _, file := range archFile {
+			if db.readOnly {
+				continue
+			}
 			if err = file.Sync(); err != nil {
 				return err
 			}
@@ -187,16 +448,21 @@ func (db *DB) Close() (err error) {
 	}
 
 	atomic.StoreUint32(&db.closed, 1)
-	return
+
+	// 所有文件都关闭之后才释放目录的锁
+	return db.dirLock.Close()
 }
 
 // save config before closing db.
 func (db *DB) saveConfig() (err error) {
 	path := db.config.DirPath + consts.ConfigSaveFile
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	file, err := db.fileOpts.FS.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return
+	}
 
 	b, err := json.Marshal(db.config)
-	_, err = file.Write(b)
+	_, err = file.WriteAt(b, 0)
 	err = file.Close()
 
 	return
@@ -216,202 +482,371 @@ func (db *DB) getActiveFile(dType consts.DataType) (file *storage.DBFile, err er
 }
 
 // Reclaim 对dbfile进行重写，回收消耗的多余的磁盘空间
-// Reclaim 会遍历所有存档的dbfile，扎到 valid entry ， 将其写进dbfile
-// reclaim 需要的时间取决于entry的数量， 最好选在低流量时使用
+// Reclaim 会遍历所有存档的dbfile，找到 valid entry ， 将其写进新的 dbfile
+// 归档文件数量达到 ReclaimThreshold 的类型才会被重写，不同类型在不同的 goroutine 中进行
+// 重写时不阻塞读写，只在替换文件时短暂持有该类型的写锁，见 ReclaimType
 func (db *DB) Reclaim() (err error) {
-	// if single reclaiming is in progress, the reclaim operation can`t be executed.
-	// if db.isSingleReclaiming {
-	// 	return ErrDBisReclaiming
-	// }
-	var reclaimable bool
-	for _, archFiles := range db.archFiles {
-		if len(archFiles) >= db.config.ReclaimThreshold {
-			reclaimable = true
-			break
-		}
-	}
-	if !reclaimable {
+	if db.isClosed() {
+		return dberror.ErrDBIsClosed
+	}
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
+	allTypes := []consts.DataType{consts.String, consts.List, consts.Hash, consts.Set, consts.ZSet}
+	if err = db.startReclaim(allTypes...); err != nil {
+		return
+	}
+	defer db.finishReclaim(allTypes...)
+
+	// 需要回收的 blob 文件，其中有效的 value 会在重写 entry 时复制到新的 blob 文件
+	blobCandidates := db.blobGCCandidates()
+
+	// 如果某类型的 archived file < ReclaimThreshold，不需要重写
+	// 有文件需要使用当前密钥重新加密时，不论数量多少都要 reclaim
+	// 有 blob 文件需要回收时，引用它们的 entry 也需要重写
+	var dTypes []consts.DataType
+	for _, dType := range allTypes {
+		unlock := db.lockMgr.RLock(dType)
+		archFiles := db.archFiles[dType]
+		reclaimable := len(archFiles) > 0 && (len(archFiles) >= db.config.ReclaimThreshold || needsRekey(archFiles) ||
+			(len(blobCandidates) > 0 && storage.IsBlobType(dType)))
+		unlock()
+		if reclaimable {
+			dTypes = append(dTypes, dType)
+		}
+	}
+	if len(dTypes) == 0 {
 		return dberror.ErrReclaimUnreached
 	}
+	db.fileOpts.Blobs.Relocate(blobCandidates)
 
-	// 创建临时文件储存新的dbfile
-	reclaimPath := db.config.DirPath + consts.ReclaimPath
-	if err := os.MkdirAll(reclaimPath, os.ModePerm); err != nil {
-		return err
+	// processing the different types of files in different goroutines.
+	errs := make([]error, consts.DataStructureNum)
+	wg := sync.WaitGroup{}
+	wg.Add(len(dTypes))
+	for _, dType := range dTypes {
+		go func(dType consts.DataType) {
+			defer wg.Done()
+			errs[dType] = db.reclaimType(dType)
+		}(dType)
+	}
+	wg.Wait()
+	for _, err = range errs {
+		if err != nil {
+			return
+		}
 	}
-	defer os.RemoveAll(reclaimPath)
 
-	db.mu.Lock()
-	defer func() {
-		db.isReclaiming = false
-		db.mu.Unlock()
-	}()
-	db.isReclaiming = true
+	// 删除不再被引用的 blob 文件
+	if _, err = db.fileOpts.Blobs.Collect(); err != nil {
+		return
+	}
+	return
+}
 
-	// 接下来就是reclaim的操作了
+// ReclaimType 重写一种数据类型的所有归档文件，不受 ReclaimThreshold 的限制
+// 重写时该类型的读写可以正常进行，只在替换文件和更新 string index 时短暂持有该类型的写锁
+// 同一种类型正在 reclaim 时返回 ErrDBisReclaiming
+func (db *DB) ReclaimType(dType consts.DataType) (err error) {
+	if dType >= consts.DataStructureNum {
+		return dberror.ErrInvalidDataType
+	}
+	if db.isClosed() {
+		return dberror.ErrDBIsClosed
+	}
+	if db.readOnly {
+		return dberror.ErrReadOnly
+	}
+	if err = db.startReclaim(dType); err != nil {
+		return
+	}
+	defer db.finishReclaim(dType)
 
-	// processing the different types of files in different goroutines.
-	newArchivedFiles := sync.Map{}
-	reclaimedTypes := sync.Map{}
+	if err = db.reclaimType(dType); err != nil {
+		return
+	}
+	_, err = db.fileOpts.Blobs.Collect()
+	return
+}
 
-	wg := sync.WaitGroup{}
-	wg.Add(consts.DataStructureNum)
-	for i := 0; i < consts.DataStructureNum; i++ {
-		go func(dType uint16) {
-			defer func() {
-				wg.Done()
-			}()
-			// 如果某类型的 archvied filed < ReclaimThreshold , 直接将其存进 newarchviedfiles，退出此函数
-			if len(db.archFiles[dType]) < db.config.ReclaimThreshold {
-				newArchivedFiles.Store(dType, db.archFiles[dType])
-				return
-			}
+// 标记 dTypes 正在 reclaim，任意一个类型已经在 reclaim 时返回 ErrDBisReclaiming
+func (db *DB) startReclaim(dTypes ...consts.DataType) error {
+	db.reclaimMu.Lock()
+	defer db.reclaimMu.Unlock()
 
-			var (
-				df        *storage.DBFile
-				fileId    uint32
-				archFiles = make(map[uint32]*storage.DBFile)
-				fileIds   []int
-			)
+	if db.reclaimStop {
+		return dberror.ErrDBIsClosed
+	}
+	for _, dType := range dTypes {
+		if db.reclaiming[dType] {
+			return dberror.ErrDBisReclaiming
+		}
+	}
+	for _, dType := range dTypes {
+		db.reclaiming[dType] = true
+	}
+	db.reclaimWg.Add(1)
+	return nil
+}
+
+func (db *DB) finishReclaim(dTypes ...consts.DataType) {
+	db.reclaimMu.Lock()
+	defer db.reclaimMu.Unlock()
 
-			// 把此类型的所有 archived file 的 id 存到 fileIds里，并从小到大排序
-			for _, file := range db.archFiles[dType] {
-				fileIds = append(fileIds, int(file.Id))
+	for _, dType := range dTypes {
+		delete(db.reclaiming, dType)
+	}
+	db.reclaimWg.Done()
+}
+
+// 重写一种数据类型在开始时已经归档的文件，之后归档的文件不受影响
+// 读取旧文件和写入新文件时不持有锁，判断 entry 是否有效时持有读锁，替换文件时持有写锁
+// 集合类型按照内存中的状态重写，见 reclaimCollection
+func (db *DB) reclaimType(dType consts.DataType) (err error) {
+	if dType != consts.String {
+		return db.reclaimCollection(dType)
+	}
+
+	unlock := db.lockMgr.RLock(dType)
+	oldFiles := make(map[uint32]*storage.DBFile, len(db.archFiles[dType]))
+	for id, f := range db.archFiles[dType] {
+		oldFiles[id] = f
+	}
+	unlock()
+	if len(oldFiles) == 0 {
+		return dberror.ErrReclaimUnreached
+	}
+
+	// 每种类型使用单独的临时目录，不同类型可以同时 reclaim
+	fs := db.fileOpts.FS
+	reclaimPath := db.config.DirPath + consts.ReclaimPath + storage.PathSeparator + storage.DBFileSuffixName[dType]
+	if err = fs.MkdirAll(reclaimPath, os.ModePerm); err != nil {
+		return
+	}
+	defer fs.RemoveAll(reclaimPath)
+
+	var (
+		df       *storage.DBFile
+		newFiles []*storage.DBFile
+		// 旧的 dbfile 中每个 blob 文件被引用的次数，删除旧文件后释放
+		refs = make(map[uint32]int64)
+		// 被保留的 entry 从旧位置到新位置的映射
+		relocs  = make(map[entryLoc]entryLoc)
+		moves   []strMove
+		swapped bool
+	)
+	defer func() {
+		if err != nil && !swapped {
+			for _, f := range newFiles {
+				f.Close(false)
 			}
-			sort.Ints(fileIds)
-
-			for _, fid := range fileIds {
-				file := db.archFiles[dType][uint32(fid)]
-				var offset int64 = 0
-				var reclaimEntries []*storage.Entry
-
-				// 读取dbfile中的所有entry，找到valid entry.
-				for {
-					if e, err := file.Read(offset); err == nil {
-						if db.validEntry(e, offset, file.Id) {
-							reclaimEntries = append(reclaimEntries, e)
-						}
-						offset += int64(e.Size())
-					} else {
-						if err == io.EOF {
-							break
-						}
-						log.Fatalf("err occurred when read the entry: %+v", err)
-						return
-					}
+		}
+	}()
+
+	// 按照加载顺序重写，新文件使用新的 id，在 manifest 中替换旧文件的位置，仍然在之后归档的文件之前加载
+	var fileIds []uint32
+	for _, id := range db.manifest.FileIds(dType) {
+		if _, ok := oldFiles[id]; ok {
+			fileIds = append(fileIds, id)
+		}
+	}
+	for _, id := range fileIds {
+		file := oldFiles[id]
+		offset := file.DataOffset()
+		for {
+			var e *storage.Entry
+			if e, err = file.ReadUnresolved(offset); err != nil {
+				if err == io.EOF {
+					err = nil
+					break
 				}
+				return
+			}
+			if blobId, ok := e.BlobFileId(); ok {
+				refs[blobId]++
+			}
+			size := int64(e.Size())
+
+			unlock := db.lockMgr.RLock(dType)
+			valid := db.validEntry(e, offset, file.Id)
+			unlock()
+			if !valid {
+				offset += size
+				continue
+			}
 
-				// 将有效的 entry 写入到新的 dbfile
-				for _, entry := range reclaimEntries {
-					if df == nil || int64(entry.Size())+df.Offset > db.config.BlockSize {
-						df, err = storage.NewDBFile(reclaimPath, fileId, dType)
-						if err != nil {
-							log.Fatalf("err occurred when create new db file: %+v", err)
-							return
-						}
-						//add dbfile into new archfiles
-						archFiles[fileId] = df
-						fileId += 1
-					}
-
-					if err = df.Write(entry); err != nil {
-						log.Fatalf("err occurred when write the entry: %+v", err)
-						return
-					}
-
-					// Since the str types value will be read from db file, so should update the index info.
-					if dType == consts.String {
-						item := db.strIndex.idxList.Get(entry.Meta.Key)
-						idx := item.Value().(*str.StrData)
-						idx.Offset = df.Offset - int64(entry.Size())
-						idx.FileId = fileId
-						db.strIndex.idxList.Put(idx.Meta.Key, idx)
-					}
+			if df == nil || size+df.Offset > db.config.BlockSize {
+				if df, err = storage.NewDBFile(reclaimPath, db.manifest.NextFileId(dType), dType, db.fileOpts); err != nil {
+					return
 				}
+				newFiles = append(newFiles, df)
+			}
+			if err = df.Write(e); err != nil {
+				return
+			}
+			from := entryLoc{fileId: file.Id, offset: offset}
+			to := entryLoc{fileId: df.Id, offset: df.Offset - int64(e.Size())}
+			relocs[from] = to
+			if dType == consts.String {
+				moves = append(moves, strMove{key: e.Meta.Key, from: from, to: to, size: e.Size()})
 			}
-			reclaimedTypes.Store(dType, struct{}{})
-			newArchivedFiles.Store(dType, archFiles)
-		}(uint16(i))
+			offset += size
+		}
 	}
-	wg.Wait()
-	// 已经 reclaime dbfile 了
-	dbArchivedFiles := make(ArchivedFiles)
-	for i := 0; i < consts.DataStructureNum; i++ {
-		dType := uint16(i)
-		value, ok := newArchivedFiles.Load(dType)
-		if !ok {
-			log.Printf("one type of data(%d) is missed after reclaiming.", dType)
+	for _, f := range newFiles {
+		if err = f.Sync(); err != nil {
 			return
 		}
-		dbArchivedFiles[dType] = value.(map[uint32]*storage.DBFile)
 	}
 
-	// 删除之前的 dbfile
-	for dataType, files := range db.archFiles {
-		if _, exist := reclaimedTypes.Load(dataType); exist {
-			for _, f := range files {
-				// close file before remove it.
-				if err = f.File.Close(); err != nil {
-					log.Println("close old db file err: ", err)
-					return
-				}
-				if err = os.Remove(f.File.Name()); err != nil {
-					log.Println("remove old db file err: ", err)
-					return
+	// 替换文件，只在这里持有写锁
+	unlock = db.lockMgr.Lock(dType)
+	defer unlock()
+
+	if err = db.swapFiles(dType, reclaimPath, fileIds, newFiles); err != nil {
+		return
+	}
+	swapped = true
+
+	db.moveStrIndex(dType, moves)
+	db.garbage[dType].relocate(fileIdSet(oldFiles), relocs)
+	db.fileOpts.Blobs.Release(dType, refs)
+
+	// 重写后的文件需要重新生成 hint 文件
+	for _, f := range newFiles {
+		db.writeHintFile(f)
+	}
+	return db.removeDBFiles(oldFiles)
+}
+
+// 使用 tmpPath 中已经 sync 的新文件替换 oldIds，调用者持有该类型的写锁
+// 新文件先 rename 到数据目录，更新 manifest 之后才会被加载，在这之前崩溃时会在启动时被删除
+func (db *DB) swapFiles(dType consts.DataType, tmpPath string, oldIds []uint32, newFiles []*storage.DBFile) (err error) {
+	fs := db.fileOpts.FS
+	defer func() {
+		if err != nil {
+			for _, f := range newFiles {
+				if f.Path == db.config.DirPath {
+					fs.Remove(f.Path + dbFileName(dType, f.Id))
 				}
 			}
 		}
+	}()
+
+	newIds := make([]uint32, 0, len(newFiles))
+	for _, f := range newFiles {
+		if err = fs.Rename(tmpPath+dbFileName(dType, f.Id), db.config.DirPath+dbFileName(dType, f.Id)); err != nil {
+			return
+		}
+		f.Path = db.config.DirPath
+		newIds = append(newIds, f.Id)
+	}
+	if err = db.manifest.ReplaceFiles(dType, oldIds, newIds); err != nil {
+		return
 	}
 
-	// 复制临时 reclaim directory 作为 new db files.
-	for dataType, files := range dbArchivedFiles {
-		if _, exist := reclaimedTypes.Load(dataType); exist {
-			for _, f := range files {
-				name := storage.PathSeparator + fmt.Sprintf(storage.DBFileFormatNames[dataType], f.Id)
-				os.Rename(reclaimPath+name, db.config.DirPath+name)
-			}
+	for _, id := range oldIds {
+		delete(db.archFiles[dType], id)
+	}
+	for _, f := range newFiles {
+		db.archFiles[dType][f.Id] = f
+	}
+	return
+}
+
+// 关闭并删除已经被替换的文件和它们的 hint 文件
+// 它们已经不在 manifest 中，删除失败时会在下次启动时删除
+func (db *DB) removeDBFiles(files map[uint32]*storage.DBFile) error {
+	if db.hints != nil {
+		db.hints.forget(files)
+	}
+	for id, f := range files {
+		// close file before remove it.
+		if err := f.File.Close(); err != nil {
+			return err
+		}
+		if err := db.fileOpts.FS.Remove(f.Path + dbFileName(f.Type, id)); err != nil {
+			return err
+		}
+		if err := storage.RemoveHintFile(db.config.DirPath, id, f.Type, db.fileOpts); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	db.archFiles = dbArchivedFiles
+// 重写文件后 string index 需要更新的位置
+type strMove struct {
+	key      []byte
+	from, to entryLoc
+	size     uint32
+}
 
-	// 移除 txn meta file ，创建一个新的
-	if err = db.txnMeta.txnFile.File.Close(); err != nil {
-		log.Println("close txn file err: ", err)
+// 更新仍然指向旧位置的 string index，调用者持有 string 的写锁
+// 先找出需要更新的 index 再更新，避免新的位置和其他 entry 的旧位置相同时被重复更新
+func (db *DB) moveStrIndex(dType consts.DataType, moves []strMove) {
+	if dType != consts.String {
 		return
 	}
-	if err = os.Remove(db.config.DirPath + consts.DbTxMetaSaveFile); err == nil {
-		var txnMeta *TxnMeta
-		activeTxIds := db.txnMeta.ActiveTxIds
-		txnMeta, err = LoadTxnMeta(db.config.DirPath + consts.DbTxMetaSaveFile)
-		if err != nil {
-			return err
+	var moved []*str.StrData
+	for _, m := range moves {
+		node := db.strIndex.idxList.Get(m.key)
+		if node == nil {
+			continue
 		}
+		idx := node.Value().(*str.StrData)
+		if idx.FileId == m.from.fileId && idx.Offset == m.from.offset {
+			newIdx := *idx
+			newIdx.FileId, newIdx.Offset, newIdx.Size = m.to.fileId, m.to.offset, m.size
+			moved = append(moved, &newIdx)
+		}
+	}
+	for _, idx := range moved {
+		db.strIndex.idxList.Put(idx.Meta.Key, idx)
+	}
 
-		db.txnMeta = txnMeta
-		// write active tx ids.
-		activeTxIds.Range(func(key, value interface{}) bool {
-			if txId, ok := key.(uint64); ok {
-				if err = db.MarkCommit(txId); err != nil {
-					return false
-				}
-			}
+	// entry 的位置已经改变，缓存中的 value 不再可用
+	if db.valueCache != nil {
+		db.valueCache.Purge()
+	}
+}
+
+// dbfile 在目录中的文件名，以路径分隔符开头
+func dbFileName(dType consts.DataType, fileId uint32) string {
+	return storage.PathSeparator + fmt.Sprintf(storage.DBFileFormatNames[dType], fileId)
+}
+
+func fileIdSet(files map[uint32]*storage.DBFile) map[uint32]struct{} {
+	ids := make(map[uint32]struct{}, len(files))
+	for id := range files {
+		ids[id] = struct{}{}
+	}
+	return ids
+}
+
+// 是否有归档文件需要使用当前密钥重新加密
+func needsRekey(files map[uint32]*storage.DBFile) bool {
+	for _, file := range files {
+		if file.NeedsRekey() {
 			return true
-		})
+		}
 	}
-	return
+	return false
 }
 
 // validEntry 检查 entry 是否有效，过期了的会被筛除
 // expired entry will be filtered.
+// 直接读取 index，不会删除过期的 key，调用者持有该类型的读锁
+// 只用于 string，集合类型按照内存中的状态重写，见 reclaimCollection
 func (db *DB) validEntry(e *storage.Entry, offset int64, fileId uint32) bool {
 	if e == nil {
 		return false
 	}
 
 	// uncommitted entry is invalid.
+	// 所有归档文件都会被重写，已经提交的事务的 entry 不再需要 tx id
 	if e.TxId != 0 {
-		if _, ok := db.txnMeta.CommittedTxIds[e.TxId]; !ok {
+		if db.txnMeta.isAborted(e.TxId) {
 			return false
 		}
 		e.TxId = 0
@@ -446,62 +881,6 @@ func (db *DB) validEntry(e *storage.Entry, offset int64, fileId uint32) bool {
 				}
 			}
 		}
-	case consts.List:
-		if mark == consts.ListLExpire {
-			deadline, exist := db.expires[consts.List][string(e.Meta.Key)]
-			if exist && deadline > time.Now().Unix() {
-				return true
-			}
-		}
-		if mark == consts.ListLPush || mark == consts.ListRPush || mark == consts.ListLInsert || mark == consts.ListLSet {
-			if db.LValExists(e.Meta.Key, e.Meta.Value) {
-				return true
-			}
-		}
-	case consts.Hash:
-		if mark == consts.HashHExpire {
-			deadline, exist := db.expires[consts.Hash][string(e.Meta.Key)]
-			if exist && deadline > time.Now().Unix() {
-				return true
-			}
-		}
-		if mark == consts.HashHSet {
-			if val := db.HGet(e.Meta.Key, e.Meta.Extra); string(val) == string(e.Meta.Value) {
-				return true
-			}
-		}
-	case consts.Set:
-		if mark == consts.SetSExpire {
-			deadline, exist := db.expires[consts.Set][string(e.Meta.Key)]
-			if exist && deadline > time.Now().Unix() {
-				return true
-			}
-		}
-		if mark == consts.SetSMove {
-			if db.SIsMember(e.Meta.Extra, e.Meta.Value) {
-				return true
-			}
-		}
-		if mark == consts.SetSAdd {
-			if db.SIsMember(e.Meta.Key, e.Meta.Value) {
-				return true
-			}
-		}
-	case consts.ZSet:
-		if mark == consts.ZSetZExpire {
-			deadline, exist := db.expires[consts.ZSet][string(e.Meta.Key)]
-			if exist && deadline > time.Now().Unix() {
-				return true
-			}
-		}
-		if mark == consts.ZSetZAdd {
-			if val, err := utils.StrToFloat64(string(e.Meta.Extra)); err == nil {
-				ok, score := db.ZScore(e.Meta.Key, e.Meta.Value)
-				if ok && score == val {
-					return true
-				}
-			}
-		}
 	}
 	return false
 }
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"zeroDB/global/consts"
+	"zeroDB/global/dberror"
+	"zeroDB/storage"
+)
+
+// 当前进程打开的文件数量
+func openFDs(t *testing.T) int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("/proc/self/fd is not available")
+	}
+	return len(fds)
+}
+
+// 写入足够多的 string，返回第一个归档文件的 id
+func fillStrings(t *testing.T, dir string) uint32 {
+	db, _ := openTxnTestDB(t, dir, 4<<10)
+	for i := 0; i < 200; i++ {
+		if err := db.Set(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id := db.manifest.FileIds(consts.String)[0]
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// 加载失败时关闭已经打开的文件，并释放目录的锁
+func TestOpenFailureClosesFiles(t *testing.T) {
+	dir := t.TempDir() + "/"
+	id := fillStrings(t, dir)
+
+	// 归档文件中间损坏时加载失败，删除 hint 文件使它被重放
+	path := dir + dbFileName(consts.String, id)
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[len(buf)/2] ^= 0xff
+	if err = os.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.RemoveHintFile(dir, id, consts.String, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	before := openFDs(t)
+	for _, readOnly := range []bool{false, true} {
+		cfg := openTxnTestConfig(dir, 4<<10)
+		var err error
+		if readOnly {
+			_, err = OpenReadOnly(cfg)
+		} else {
+			_, err = Open(cfg)
+		}
+		if !errors.Is(err, dberror.ErrCorruptedFile) {
+			t.Fatalf("readOnly %v: expected ErrCorruptedFile, got %v", readOnly, err)
+		}
+		if after := openFDs(t); after != before {
+			t.Fatalf("readOnly %v: %d files left open", readOnly, after-before)
+		}
+	}
+
+	lock, err := storage.LockDir(storage.OSFS{}, dir, false)
+	if err != nil {
+		t.Fatalf("lock not released: %v", err)
+	}
+	lock.Close()
+}
+
+// 只读打开不创建 LOCK 文件，但是仍然和正常打开互斥
+func TestOpenReadOnlyWithoutLockFile(t *testing.T) {
+	dir := t.TempDir() + "/"
+	fillStrings(t, dir)
+	if err := os.Remove(dir + storage.LockFileName); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenReadOnly(openTxnTestConfig(dir, 4<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir + storage.LockFileName); !os.IsNotExist(err) {
+		t.Fatalf("read-only open created the LOCK file: %v", err)
+	}
+	if _, err := Open(openTxnTestConfig(dir, 4<<10)); err != dberror.ErrDirLocked {
+		t.Fatalf("expected ErrDirLocked, got %v", err)
+	}
+	var val string
+	if err := db.Get("key-1", &val); err != nil || val != "value-1" {
+		t.Fatalf("get: got %q, err %v", val, err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(openTxnTestConfig(dir, 4<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
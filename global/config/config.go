@@ -3,8 +3,11 @@ package config
 import (
 	"log"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v2"
+
+	"zeroDB/storage"
 )
 
 // 开启服务的配置项
@@ -16,8 +19,59 @@ type Config struct {
 	MaxValueSize uint32 ` yaml:"max_value_size"`
 
 	//是否将写入从操作系统缓冲区缓存同步到实际磁盘。如果为 false，系统崩溃，会丢失最近的一些写入
+	// 只在 FsyncPolicy 为空时生效，true 相当于 always，false 相当于 no
 	Sync             bool `yaml:"sync"`
 	ReclaimThreshold int  `yaml:"reclaim_threshold"` // threshold to reclaim disk
+
+	// 是否在后台定时重写 dead bytes（被覆盖、删除或者过期的 entry）比例达到 ReclaimGarbageRatio 的归档文件
+	AutoReclaim bool `yaml:"auto_reclaim"`
+	// 归档文件被重写的 dead bytes 比例，为 0 时使用 0.5
+	ReclaimGarbageRatio float64 `yaml:"reclaim_garbage_ratio"`
+	// 后台检查 dead bytes 的间隔，为 0 时使用 1 分钟
+	ReclaimInterval time.Duration `yaml:"reclaim_interval"`
+	// 允许后台回收的时间段，格式为 "HH:MM-HH:MM"（本地时间，可以跨过零点），为空时任何时间都可以回收
+	ReclaimWindow string `yaml:"reclaim_window"`
+
+	// 定时为 list、hash、set、zset 保存内存中 index 的快照（checkpoint），启动时只需要重放之后写入的 entry
+	// 为 0 时不在后台生成，也不在关闭时生成，仍然可以调用 DB.Checkpoint
+	CheckpointInterval time.Duration `yaml:"checkpoint_interval"`
+
+	// 写入持久化到磁盘的方式：always 每次写入都 fsync，everysec 后台每秒 fsync 一次，no 不主动 fsync
+	FsyncPolicy string `yaml:"fsync_policy"`
+
+	// fsync policy 为 always 时，写入 entry 之后由一个 goroutine 按批次 fsync，同一批次的写入只 fsync 一次
+	// 一个批次最多合并的等待 fsync 的写入数量，为 0 时使用 128
+	GroupCommitMaxBatch int `yaml:"group_commit_max_batch"`
+	// 收集一个批次最多等待的时间，为 0 时不等待，只合并已经在排队的写入
+	GroupCommitMaxWait time.Duration `yaml:"group_commit_max_wait"`
+
+	// value 的压缩方式：none、flate、lz，为空时不压缩
+	Compression string `yaml:"compression"`
+	// value 大于等于该大小时才会被压缩
+	CompressThreshold uint32 `yaml:"compress_threshold"`
+
+	// 密钥文件的路径，不为空时使用 AES-GCM 加密数据文件
+	// 每行一个密钥，格式为 "id:hex"，最后一个密钥用于加密，轮换密钥后执行 Reclaim 重新加密
+	EncryptionKeyFile string `yaml:"encryption_key_file"`
+
+	// string 的 index 只保存 key 和 entry 的位置，value 从 dbfile 中读取，可以减少内存占用
+	KeyOnlyIndex bool `yaml:"key_only_index"`
+	// key-only 模式下 value 缓存的大小（字节），为 0 时不缓存
+	ValueCacheSize int64 `yaml:"value_cache_size"`
+
+	// value 大于等于该大小时写入单独的 blob 文件，dbfile 中只保存 value 的位置，为 0 时不分离
+	// 只对 string 和 hash 生效，Reclaim 时不需要复制这些 value
+	BlobThreshold uint32 `yaml:"blob_threshold"`
+	// blob 文件中无效的 value 超过该比例时，Reclaim 会回收这个 blob 文件，为 0 时使用 0.5
+	BlobGCRatio float64 `yaml:"blob_gc_ratio"`
+
+	// DB.Txn 提交时和并发的写入冲突（ErrTxnConflict）时自动重试的次数，为 0 时不重试
+	TxnMaxRetries int `yaml:"txn_max_retries"`
+
+	// 文件的读写方式：os、mmap（归档文件映射到内存中读取）、memory（所有数据保存在内存中），为空时使用 os
+	FileSystem string `yaml:"file_system"`
+	// 不为 nil 时使用该文件系统，忽略 FileSystem，用于嵌入使用时共享内存文件系统或者注入错误
+	FS storage.FileSystem `yaml:"-" json:"-"`
 }
 
 func InitConfig(path string) (cfg Config) {
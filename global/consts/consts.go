@@ -58,3 +58,6 @@ const (
 	ZSetZClear
 	ZSetZExpire
 )
+
+// 事务的提交标记，所有数据类型通用，不和各类型的操作类型冲突
+const TxnCommit uint16 = 0xff
@@ -7,6 +7,9 @@ const (
 	// reclaim（修改合并） 的文件
 	ReclaimPath = string(os.PathSeparator) + "zerokv_reclaim"
 
+	// 重写单个 dbfile 时使用的临时目录
+	CompactPath = string(os.PathSeparator) + "zerokv_compact"
+
 	//extra info 的分隔符，某些命令不能包含
 	ExtraSeparator = "\\0"
 
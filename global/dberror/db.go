@@ -29,5 +29,25 @@ var (
 
 	ErrTxIsFinished = errors.New("zerokv: transaction is finished, create a new one")
 
+	ErrTxnConflict = errors.New("zerokv: transaction conflicts with a concurrent write, retry it")
+
+	ErrTxnIncomplete = errors.New("zerokv: transaction has no commit marker in some of its data types")
+
 	ErrActiveFileIsNil = errors.New("zerokv: active file is nil")
+
+	ErrCorruptedFile = errors.New("zerokv: data file is corrupted")
+
+	ErrUnknownFsyncPolicy = errors.New("zerokv: unknown fsync policy")
+
+	ErrDBFileNotFound = errors.New("zerokv: db file of the index not found")
+
+	ErrInvalidDataType = errors.New("zerokv: invalid data type")
+
+	ErrInvalidReclaimWindow = errors.New("zerokv: invalid reclaim window, expect HH:MM-HH:MM")
+
+	ErrReadOnly = errors.New("zerokv: db is opened in read-only mode")
+
+	ErrBackupDirNotEmpty = errors.New("zerokv: backup dir is not empty")
+
+	ErrRestoreDirNotEmpty = errors.New("zerokv: restore dir is not empty")
 )
@@ -9,4 +9,52 @@ var (
 	ErrInvalidCrc = errors.New("storage/file: invalid crc")
 
 	ErrEmptyEntry = errors.New("storage/file: entry or the Key of entry is empty")
+
+	// 数据文件的文件头校验失败
+	ErrInvalidFileHeader = errors.New("storage/header: invalid file header")
+
+	// 数据文件的格式版本不支持
+	ErrUnsupportedFileVersion = errors.New("storage/header: unsupported file version")
+
+	// 旧版本的数据文件中 entry 的格式不同，不能再追加写入
+	ErrOldFileVersion = errors.New("storage/file: can not append to a db file of an older version")
+
+	// 未知的压缩方式
+	ErrUnknownCodec = errors.New("storage/compress: unknown compression codec")
+
+	// 压缩的 value 无法解压
+	ErrInvalidCompressed = errors.New("storage/compress: invalid compressed value")
+
+	// 密钥文件格式不正确
+	ErrInvalidKeyFile = errors.New("storage/cipher: invalid encryption key file")
+
+	// 解密失败，数据被篡改或者使用了错误的密钥
+	ErrDecryptFailed = errors.New("storage/cipher: decrypt failed")
+
+	// 数据被加密，但是没有对应的密钥
+	ErrEncryptionKeyMissing = errors.New("storage/cipher: encryption key missing")
+
+	// 未知的文件系统
+	ErrUnknownFileSystem = errors.New("storage/fs: unknown file system")
+
+	// 只读的文件不能写入
+	ErrReadOnlyFile = errors.New("storage/fs: file is read only")
+
+	// blob pointer 格式不正确或者指向的 blob 不存在
+	ErrInvalidBlobPointer = errors.New("storage/blob: invalid blob pointer")
+
+	// entry 的 value 保存在 blob 文件中，但是没有打开 blob 文件
+	ErrBlobStoreMissing = errors.New("storage/blob: blob store missing")
+
+	// hint 文件不完整或校验失败
+	ErrInvalidHint = errors.New("storage/hint: invalid hint file")
+
+	// manifest 文件不完整或校验失败
+	ErrInvalidManifest = errors.New("storage/manifest: invalid manifest file")
+
+	// checkpoint 文件不完整或校验失败
+	ErrInvalidCheckpoint = errors.New("storage/checkpoint: invalid checkpoint file")
+
+	// 数据目录已经被其他 db 实例打开
+	ErrDirLocked = errors.New("storage/lock: data directory is locked by another db instance")
 )
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"io"
+	"os"
+)
+
+// 复制文件时每次读取的大小
+const backupChunkSize = 1 << 20
+
+type (
+	// Linker 支持硬链接的文件系统，备份时不会再被修改的文件直接链接，不需要复制
+	Linker interface {
+		Link(oldname, newname string) error
+	}
+
+	// DirSyncer 可以持久化目录项的文件系统，新创建的文件在 sync 目录之后才不会因为崩溃丢失
+	DirSyncer interface {
+		SyncDir(path string) error
+	}
+)
+
+func (OSFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (OSFS) SyncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err = dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+	return dir.Close()
+}
+
+// SyncDir 持久化目录中新创建的文件，文件系统没有实现 DirSyncer 时什么都不做
+func SyncDir(fs FileSystem, path string) error {
+	if syncer, ok := fs.(DirSyncer); ok {
+		return syncer.SyncDir(path)
+	}
+	return nil
+}
+
+// BackupFile 将 src 的前 size 个字节复制到 dst 并 fsync，返回复制的字节数，size 小于 0 时复制整个文件
+// link 为 true 表示 src 不会再被修改，文件系统支持硬链接时直接链接，链接失败（比如跨设备）时再复制
+func BackupFile(fs FileSystem, src, dst string, size int64, link bool) (int64, error) {
+	if linker, ok := fs.(Linker); ok && link && size >= 0 {
+		if err := linker.Link(src, dst); err == nil {
+			return size, nil
+		}
+	}
+
+	srcFile, err := fs.OpenFile(src, os.O_RDONLY, FilePerPm)
+	if err != nil {
+		return 0, err
+	}
+	defer srcFile.Close()
+	if size < 0 {
+		if size, err = srcFile.Size(); err != nil {
+			return 0, err
+		}
+	}
+
+	dstFile, err := fs.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePerPm)
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, backupChunkSize)
+	for offset := int64(0); offset < size; {
+		n := int64(len(buf))
+		if size-offset < n {
+			n = size - offset
+		}
+		// 活跃文件可能在复制时继续写入，只复制到 size 为止
+		if read, err := srcFile.ReadAt(buf[:n], offset); int64(read) < n {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			dstFile.Close()
+			return 0, err
+		}
+		if _, err = dstFile.WriteAt(buf[:n], offset); err != nil {
+			dstFile.Close()
+			return 0, err
+		}
+		offset += n
+	}
+	if err = dstFile.Sync(); err != nil {
+		dstFile.Close()
+		return 0, err
+	}
+	return size, dstFile.Close()
+}
@@ -0,0 +1,421 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"zeroDB/global/dberror"
+)
+
+const (
+	// blob 文件的数据类型，文件格式与 dbfile 相同
+	BlobFileType uint16 = 5
+
+	// file id(4) + offset(8) + size(4) = 16
+	BlobPointerSize = 16
+
+	blobFileSuffix = ".blob"
+)
+
+// 只有 string 和 hash 的 value 会被分离到 blob 文件中
+var blobTypes = map[uint16]bool{0: true, 2: true}
+
+func init() {
+	DBFileFormatNames[BlobFileType] = "%09d" + blobFileSuffix
+}
+
+// BlobPointer value 在 blob 文件中的位置，代替 value 保存在 dbfile 中
+type BlobPointer struct {
+	FileId uint32
+	Offset int64
+	Size   uint32 // blob entry 的大小
+}
+
+// 编码 blob pointer
+func (p *BlobPointer) Encode() []byte {
+	buf := make([]byte, BlobPointerSize)
+	binary.BigEndian.PutUint32(buf[0:4], p.FileId)
+	binary.BigEndian.PutUint64(buf[4:12], uint64(p.Offset))
+	binary.BigEndian.PutUint32(buf[12:16], p.Size)
+	return buf
+}
+
+// 解码 blob pointer
+func DecodeBlobPointer(buf []byte) (*BlobPointer, error) {
+	if len(buf) != BlobPointerSize {
+		return nil, dberror.ErrInvalidBlobPointer
+	}
+	return &BlobPointer{
+		FileId: binary.BigEndian.Uint32(buf[0:4]),
+		Offset: int64(binary.BigEndian.Uint64(buf[4:12])),
+		Size:   binary.BigEndian.Uint32(buf[12:16]),
+	}, nil
+}
+
+// 判断数据类型的 value 是否可以分离到 blob 文件中
+func IsBlobType(typ uint16) bool {
+	return blobTypes[typ]
+}
+
+// BlobStore 管理 blob 文件，大于 threshold 的 value 写入 blob 文件，dbfile 中只保存 BlobPointer
+// 每个 blob 文件记录被 dbfile 中的多少个 entry 引用，没有引用的 blob 文件在 Reclaim 之后删除
+type BlobStore struct {
+	mu         sync.RWMutex
+	path       string
+	blockSize  int64
+	threshold  uint32                      // 为 0 时不分离，已有的 blob 仍然可以读取
+	opts       *Options                    // blob 文件使用的选项
+	active     *DBFile                     // 正在写入的 blob 文件，第一次写入时创建
+	files      map[uint32]*DBFile          // 所有 blob 文件，包括 active
+	refs       map[uint32]int64            // 每个 blob 文件被引用的次数
+	typeRefs   map[uint16]map[uint32]int64 // 每种数据类型的 entry 对每个 blob 文件的引用次数，checkpoint 需要单独保存
+	relocating map[uint32]struct{}         // 正在回收的 blob 文件，重写 entry 时 value 会被复制到新的 blob 文件
+	nextId     uint32
+}
+
+// 打开目录中所有的 blob 文件
+// 已有的 blob 文件只读打开，新的 value 总是写入新的 blob 文件
+func OpenBlobStore(path string, blockSize int64, threshold uint32, opts *Options) (*BlobStore, error) {
+	blobOpts := &Options{}
+	if opts != nil {
+		*blobOpts = *opts
+		blobOpts.Blobs = nil
+	}
+	bs := &BlobStore{
+		path:       path,
+		blockSize:  blockSize,
+		threshold:  threshold,
+		opts:       blobOpts,
+		files:      make(map[uint32]*DBFile),
+		refs:       make(map[uint32]int64),
+		typeRefs:   make(map[uint16]map[uint32]int64),
+		relocating: make(map[uint32]struct{}),
+	}
+
+	names, err := blobOpts.fileSystem().ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, blobFileSuffix) {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSuffix(name, blobFileSuffix))
+		if err != nil {
+			continue
+		}
+		df, err := openArchivedDBFile(path, uint32(id), BlobFileType, blobOpts)
+		if err != nil {
+			bs.Close(false)
+			return nil, err
+		}
+		bs.files[uint32(id)] = df
+		if uint32(id) >= bs.nextId {
+			bs.nextId = uint32(id) + 1
+		}
+	}
+	return bs, nil
+}
+
+func (o *Options) blobs() *BlobStore {
+	if o == nil {
+		return nil
+	}
+	return o.Blobs
+}
+
+// 决定 entry 的 value 是否写入 blob 文件，需要时写入并设置 FlagBlobRef
+// 从文件中读出的 entry 已经有 blob pointer，除非所在的 blob 文件正在回收，否则直接复用
+func (bs *BlobStore) separate(e *Entry) error {
+	if bs == nil || !IsBlobType(e.GetType()) {
+		e.State &^= FlagBlobRef
+		e.blobRef = nil
+		return nil
+	}
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if e.blobRef != nil {
+		if _, ok := bs.relocating[e.blobRef.FileId]; !ok {
+			e.State |= FlagBlobRef
+			return nil
+		}
+		// 使用 ReadUnresolved 读取的 entry 中 Meta.Value 是 BlobPointer，需要从正在回收的 blob 文件中读取 value
+		df, ok := bs.files[e.blobRef.FileId]
+		if !ok {
+			return fmt.Errorf("%w: blob file %09d not found", dberror.ErrInvalidBlobPointer, e.blobRef.FileId)
+		}
+		value, err := readBlob(df, e.blobRef)
+		if err != nil {
+			return err
+		}
+		e.Meta.Value = value
+	} else if bs.threshold == 0 || uint32(len(e.Meta.Value)) < bs.threshold {
+		e.State &^= FlagBlobRef
+		return nil
+	}
+
+	ptr, err := bs.put(e)
+	if err != nil {
+		return err
+	}
+	e.blobRef = ptr
+	e.State |= FlagBlobRef
+	return nil
+}
+
+// 将 value 写入 blob 文件，调用时需要持有锁
+func (bs *BlobStore) put(e *Entry) (*BlobPointer, error) {
+	be := newInternal(e.Meta.Key, e.Meta.Value, e.Meta.Extra, e.State&(typeMask<<8|0xff), e.Timestamp)
+	// 加密后 entry 会变大，按加密后的大小判断是否需要新的 blob 文件
+	size := int64(be.Size()) + SealedSize
+	if bs.active == nil || bs.active.Offset+size > bs.blockSize {
+		if bs.active != nil {
+			if err := bs.active.Sync(); err != nil {
+				return nil, err
+			}
+		}
+		df, err := NewDBFile(bs.path, bs.nextId, BlobFileType, bs.opts)
+		if err != nil {
+			return nil, err
+		}
+		bs.active = df
+		bs.files[df.Id] = df
+		bs.nextId++
+	}
+
+	offset := bs.active.Offset
+	if err := bs.active.Write(be); err != nil {
+		return nil, err
+	}
+	return &BlobPointer{FileId: bs.active.Id, Offset: offset, Size: be.Size()}, nil
+}
+
+// 读取 blob pointer 指向的 value
+func (bs *BlobStore) get(ptr *BlobPointer) ([]byte, error) {
+	bs.mu.RLock()
+	df, ok := bs.files[ptr.FileId]
+	bs.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: blob file %09d not found", dberror.ErrInvalidBlobPointer, ptr.FileId)
+	}
+	return readBlob(df, ptr)
+}
+
+func readBlob(df *DBFile, ptr *BlobPointer) ([]byte, error) {
+	e, err := df.Read(ptr.Offset)
+	if err != nil {
+		return nil, err
+	}
+	if e.Size() != ptr.Size {
+		return nil, dberror.ErrInvalidBlobPointer
+	}
+	return e.Meta.Value, nil
+}
+
+// 记录 dbfile 中的 entry 引用了 blob 文件，写入和启动时加载的每个 entry 都需要记录
+func (bs *BlobStore) AddRef(e *Entry) {
+	if bs == nil || e.blobRef == nil {
+		return
+	}
+	bs.AddRefs(e.GetType(), map[uint32]int64{e.blobRef.FileId: 1})
+}
+
+// AddRefs 增加 typ 类型的 entry 对 blob 文件的引用，counts 是每个 blob 文件增加的引用数
+func (bs *BlobStore) AddRefs(typ uint16, counts map[uint32]int64) {
+	if bs == nil {
+		return
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.typeRefs[typ] == nil {
+		bs.typeRefs[typ] = make(map[uint32]int64)
+	}
+	for id, n := range counts {
+		bs.refs[id] += n
+		bs.typeRefs[typ][id] += n
+	}
+}
+
+// 删除 typ 类型的 dbfile 之后，释放其中的 entry 对 blob 文件的引用，counts 是每个 blob 文件被释放的引用数
+func (bs *BlobStore) Release(typ uint16, counts map[uint32]int64) {
+	if bs == nil {
+		return
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	for id, n := range counts {
+		bs.refs[id] -= n
+		if bs.typeRefs[typ] != nil {
+			bs.typeRefs[typ][id] -= n
+			if bs.typeRefs[typ][id] <= 0 {
+				delete(bs.typeRefs[typ], id)
+			}
+		}
+	}
+}
+
+// Refs 返回 typ 类型的 entry 对每个 blob 文件的引用次数
+func (bs *BlobStore) Refs(typ uint16) map[uint32]int64 {
+	refs := make(map[uint32]int64)
+	if bs == nil {
+		return refs
+	}
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	for id, n := range bs.typeRefs[typ] {
+		refs[id] = n
+	}
+	return refs
+}
+
+// 除了正在写入的文件以外的 blob 文件 id，已经从小到大排序
+func (bs *BlobStore) ArchivedFileIds() []uint32 {
+	if bs == nil {
+		return nil
+	}
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	var ids []uint32
+	for id := range bs.files {
+		if bs.active == nil || id != bs.active.Id {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// 遍历 blob 文件中的所有 entry
+func (bs *BlobStore) Scan(fileId uint32, fn func(e *Entry)) error {
+	bs.mu.RLock()
+	df, ok := bs.files[fileId]
+	bs.mu.RUnlock()
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	for offset := df.DataOffset(); offset < df.Offset; {
+		e, err := df.Read(offset)
+		if err != nil {
+			return err
+		}
+		fn(e)
+		offset += int64(e.Size())
+	}
+	return nil
+}
+
+// 标记需要回收的 blob 文件，之后重写 entry 时其中的 value 会被复制到新的 blob 文件
+func (bs *BlobStore) Relocate(fileIds []uint32) {
+	if bs == nil {
+		return
+	}
+	bs.mu.Lock()
+	for _, id := range fileIds {
+		bs.relocating[id] = struct{}{}
+	}
+	bs.mu.Unlock()
+}
+
+// 删除没有被引用的 blob 文件，返回删除的文件 id
+func (bs *BlobStore) Collect() ([]uint32, error) {
+	if bs == nil {
+		return nil, nil
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	bs.relocating = make(map[uint32]struct{})
+	var removed []uint32
+	for id, df := range bs.files {
+		if (bs.active != nil && id == bs.active.Id) || bs.refs[id] > 0 {
+			continue
+		}
+		if err := df.Close(false); err != nil {
+			return removed, err
+		}
+		if err := bs.opts.fileSystem().Remove(df.File.Name()); err != nil {
+			return removed, err
+		}
+		delete(bs.files, id)
+		delete(bs.refs, id)
+		for _, refs := range bs.typeRefs {
+			delete(refs, id)
+		}
+		removed = append(removed, id)
+	}
+	return removed, nil
+}
+
+// 持久化正在写入的 blob 文件，没有新的写入时直接返回
+func (bs *BlobStore) Sync() error {
+	if bs == nil {
+		return nil
+	}
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	if bs.active == nil || !bs.active.Dirty() {
+		return nil
+	}
+	return bs.active.Sync()
+}
+
+// Sizes 返回所有 blob 文件的 id 和当前大小
+func (bs *BlobStore) Sizes() map[uint32]int64 {
+	if bs == nil {
+		return nil
+	}
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+	sizes := make(map[uint32]int64, len(bs.files))
+	for id, df := range bs.files {
+		sizes[id] = df.Offset
+	}
+	return sizes
+}
+
+// 关闭所有 blob 文件
+func (bs *BlobStore) Close(sync bool) (err error) {
+	if bs == nil {
+		return nil
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	for _, df := range bs.files {
+		if e := df.Close(sync && df == bs.active); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// entry 的 value 所在的 blob 文件，value 没有分离时返回 false
+func (e *Entry) BlobFileId() (uint32, bool) {
+	if e.blobRef == nil {
+		return 0, false
+	}
+	return e.blobRef.FileId, true
+}
+
+// BlobRefs 返回 dbfile 中的 entry 对每个 blob 文件的引用数，不读取 blob 文件中的 value
+func (df *DBFile) BlobRefs() (map[uint32]int64, error) {
+	refs := make(map[uint32]int64)
+	for offset := df.DataOffset(); offset < df.Offset; {
+		e, err := df.read(offset, false)
+		if err != nil {
+			return nil, err
+		}
+		if blobId, ok := e.BlobFileId(); ok {
+			refs[blobId]++
+		}
+		offset += int64(e.Size())
+	}
+	return refs, nil
+}
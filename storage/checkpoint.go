@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+
+	"zeroDB/global/dberror"
+)
+
+const (
+	// checkpoint 的文件名前缀，后缀是数据类型，例如 CHECKPOINT.list
+	CheckpointFileName = "CHECKPOINT"
+
+	// 写入新的 checkpoint 之前，上一个 checkpoint 被 rename 为该后缀，新的 checkpoint 无效时使用
+	checkpointPrevSuffix = ".prev"
+
+	// 写 checkpoint 时使用的临时后缀，写完后 rename
+	checkpointTmpSuffix = ".tmp"
+
+	// crc32(4) + magic(4)
+	checkpointHeaderSize = 8
+	checkpointMagic      = "ZKVC"
+
+	// 开启加密时使用的 magic，magic 之后的内容使用 keyring 加密
+	sealedCheckpointMagic = "ZKVE"
+)
+
+// 返回一种数据类型的 checkpoint 的路径
+func CheckpointPath(path string, typ uint16) string {
+	return path + PathSeparator + CheckpointFileName + "." + DBFileSuffixName[typ]
+}
+
+// CheckpointFiles 返回一种数据类型的 checkpoint 文件名，最新的在前，不包括临时文件
+func CheckpointFiles(typ uint16) []string {
+	name := CheckpointFileName + "." + DBFileSuffixName[typ]
+	return []string{name, name + checkpointPrevSuffix}
+}
+
+// WriteCheckpoint 保存一种数据类型的 checkpoint，data 的格式由调用者决定
+// 先写入临时文件，fsync 之后把当前的 checkpoint 改名为 .prev，再 rename 临时文件，崩溃时至少有一个完整的 checkpoint
+// 开启加密时 data 使用 keyring 加密，文件名作为附加数据，checkpoint 不能被换到其他数据类型使用
+func WriteCheckpoint(path string, typ uint16, data []byte, opts *Options) error {
+	magic := checkpointMagic
+	if keyring := opts.keyring(); keyring != nil {
+		var err error
+		if data, err = keyring.Seal(data, checkpointAad(typ)); err != nil {
+			return err
+		}
+		magic = sealedCheckpointMagic
+	}
+
+	buf := make([]byte, checkpointHeaderSize, checkpointHeaderSize+len(data))
+	copy(buf[4:8], magic)
+	buf = append(buf, data...)
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	fs := opts.fileSystem()
+	cpPath := CheckpointPath(path, typ)
+	tmpPath := cpPath + checkpointTmpSuffix
+	file, err := fs.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePerPm)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteAt(buf, 0); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = fs.Rename(cpPath, cpPath+checkpointPrevSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return fs.Rename(tmpPath, cpPath)
+}
+
+// LoadCheckpoints 读取一种数据类型的 checkpoint，最新的在前，校验失败的 checkpoint 会被跳过
+// 不修改目录，没有写完的临时文件在下次写入时被覆盖
+// 开启加密时只使用加密的 checkpoint，没有加密或者无法解密的和损坏的一样被跳过
+func LoadCheckpoints(path string, typ uint16, opts *Options) ([][]byte, error) {
+	fs := opts.fileSystem()
+	cpPath := CheckpointPath(path, typ)
+
+	var checkpoints [][]byte
+	for _, p := range []string{cpPath, cpPath + checkpointPrevSuffix} {
+		buf, err := fs.ReadFile(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if data, err := decodeCheckpoint(buf, typ, opts.keyring()); err == nil {
+			checkpoints = append(checkpoints, data)
+		}
+	}
+	return checkpoints, nil
+}
+
+func decodeCheckpoint(buf []byte, typ uint16, keyring *Keyring) ([]byte, error) {
+	magic := checkpointMagic
+	if keyring != nil {
+		magic = sealedCheckpointMagic
+	}
+	if len(buf) < checkpointHeaderSize || string(buf[4:8]) != magic ||
+		crc32.ChecksumIEEE(buf[4:]) != binary.BigEndian.Uint32(buf[0:4]) {
+		return nil, dberror.ErrInvalidCheckpoint
+	}
+	if keyring == nil {
+		return buf[checkpointHeaderSize:], nil
+	}
+	return keyring.Open(buf[checkpointHeaderSize:], checkpointAad(typ))
+}
+
+// 加密 checkpoint 时的附加数据
+func checkpointAad(typ uint16) []byte {
+	return []byte(sealedCheckpointMagic + CheckpointFiles(typ)[0])
+}
+
+// 删除一种数据类型的所有 checkpoint，dbfile 中 entry 的位置改变时需要删除，例如迁移数据格式
+func RemoveCheckpoint(path string, typ uint16, opts *Options) error {
+	fs := opts.fileSystem()
+	cpPath := CheckpointPath(path, typ)
+	for _, p := range []string{cpPath, cpPath + checkpointPrevSuffix, cpPath + checkpointTmpSuffix} {
+		if err := fs.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"strings"
+
+	"zeroDB/global/dberror"
+)
+
+const (
+	// 加密后的内容：key id(4) + nonce(12) + 密文 + tag(16)
+	keyIdSize  = 4
+	nonceSize  = 12
+	tagSize    = 16
+	SealedSize = keyIdSize + nonceSize + tagSize
+)
+
+// Keyring 加密使用的密钥，最后一个是当前使用的密钥，其余的只用于解密旧数据
+type Keyring struct {
+	aeads    map[uint32]cipher.AEAD
+	activeId uint32
+}
+
+// 从密钥文件中读取密钥
+// 每行一个密钥，格式为 "id:hex"，hex 为 16、24 或 32 字节的 AES 密钥，# 开头的行会被忽略
+// 轮换密钥时在文件末尾追加新的密钥，旧的密钥需要保留到 Reclaim 完成
+func LoadKeyring(path string) (*Keyring, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	kr := &Keyring{aeads: make(map[uint32]cipher.AEAD)}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, dberror.ErrInvalidKeyFile
+		}
+		id, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+		if err != nil {
+			return nil, dberror.ErrInvalidKeyFile
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, dberror.ErrInvalidKeyFile
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, dberror.ErrInvalidKeyFile
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if _, exist := kr.aeads[uint32(id)]; exist {
+			return nil, dberror.ErrInvalidKeyFile
+		}
+		kr.aeads[uint32(id)] = aead
+		kr.activeId = uint32(id)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(kr.aeads) == 0 {
+		return nil, dberror.ErrInvalidKeyFile
+	}
+	return kr, nil
+}
+
+// 当前使用的密钥 id
+func (kr *Keyring) ActiveId() uint32 {
+	return kr.activeId
+}
+
+// 使用当前密钥加密，aad 会被认证但不会被加密
+func (kr *Keyring) Seal(plaintext, aad []byte) ([]byte, error) {
+	buf := make([]byte, keyIdSize+nonceSize, SealedSize+len(plaintext))
+	binary.BigEndian.PutUint32(buf[:keyIdSize], kr.activeId)
+	if _, err := rand.Read(buf[keyIdSize:]); err != nil {
+		return nil, err
+	}
+	return kr.aeads[kr.activeId].Seal(buf, buf[keyIdSize:], plaintext, aad), nil
+}
+
+// 解密 Seal 的结果
+func (kr *Keyring) Open(sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < SealedSize {
+		return nil, dberror.ErrDecryptFailed
+	}
+	aead, ok := kr.aeads[SealedKeyId(sealed)]
+	if !ok {
+		return nil, dberror.ErrEncryptionKeyMissing
+	}
+	plaintext, err := aead.Open(nil, sealed[keyIdSize:keyIdSize+nonceSize], sealed[keyIdSize+nonceSize:], aad)
+	if err != nil {
+		return nil, dberror.ErrDecryptFailed
+	}
+	return plaintext, nil
+}
+
+// 返回加密内容使用的密钥 id
+func SealedKeyId(sealed []byte) uint32 {
+	return binary.BigEndian.Uint32(sealed[:keyIdSize])
+}
@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"zeroDB/global/dberror"
+)
+
+// Codec value 的压缩方式，记录在 entry 的 state 中
+type Codec uint16
+
+const (
+	CodecNone Codec = iota
+	CodecFlate
+	CodecLZ
+)
+
+const (
+	// codec 在 state 中的位置
+	codecShift        = 13
+	codecMask  uint16 = 0x3 << codecShift
+
+	// lz 压缩最短的匹配长度
+	lzMinMatch = 4
+	// lz 压缩使用的 hash 表大小
+	lzHashLog = 14
+	// lz 压缩最远的匹配距离
+	lzMaxOffset = 1<<16 - 1
+)
+
+// 根据名字返回压缩方式，为空表示不压缩
+func ParseCodec(name string) (Codec, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return CodecNone, nil
+	case "flate":
+		return CodecFlate, nil
+	case "lz":
+		return CodecLZ, nil
+	}
+	return CodecNone, dberror.ErrUnknownCodec
+}
+
+// 压缩 value
+func compressValue(codec Codec, src []byte) ([]byte, error) {
+	switch codec {
+	case CodecFlate:
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(src); err != nil {
+			return nil, err
+		}
+		if err = w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CodecLZ:
+		return lzCompress(src), nil
+	}
+	return src, nil
+}
+
+// 解压 value
+func decompressValue(codec Codec, src []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return src, nil
+	case CodecFlate:
+		r := flate.NewReader(bytes.NewReader(src))
+		defer r.Close()
+		dst, err := io.ReadAll(r)
+		if err != nil {
+			return nil, dberror.ErrInvalidCompressed
+		}
+		return dst, nil
+	case CodecLZ:
+		return lzDecompress(src)
+	}
+	return nil, dberror.ErrUnknownCodec
+}
+
+// lz 压缩，格式为 uvarint 的原始长度 + 若干个 sequence
+// sequence: token(高四位字面量长度，低四位匹配长度-4) + 字面量 + 2 字节匹配距离 + 匹配长度
+// 长度超过 15 时在后面追加字节，每个 255 表示加 255，直到小于 255 的字节为止
+// 最后一个 sequence 只有字面量
+func lzCompress(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)/2+16), uint64(len(src)))
+
+	var table [1 << lzHashLog]int32
+	anchor, i := 0, 0
+	for i+lzMinMatch <= len(src) {
+		cur := binary.LittleEndian.Uint32(src[i:])
+		h := (cur * 2654435761) >> (32 - lzHashLog)
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+
+		if cand < 0 || i-cand > lzMaxOffset || binary.LittleEndian.Uint32(src[cand:]) != cur {
+			i++
+			continue
+		}
+
+		matchLen := lzMinMatch
+		for i+matchLen < len(src) && src[cand+matchLen] == src[i+matchLen] {
+			matchLen++
+		}
+		dst = lzAppendSequence(dst, src[anchor:i], i-cand, matchLen)
+		i += matchLen
+		anchor = i
+	}
+	return lzAppendSequence(dst, src[anchor:], 0, 0)
+}
+
+func lzAppendSequence(dst, literals []byte, offset, matchLen int) []byte {
+	litLen, ml := len(literals), 0
+	if matchLen > 0 {
+		ml = matchLen - lzMinMatch
+	}
+	token := byte(minInt(litLen, 15)<<4 | minInt(ml, 15))
+	dst = append(dst, token)
+	if litLen >= 15 {
+		dst = lzAppendLen(dst, litLen-15)
+	}
+	dst = append(dst, literals...)
+
+	if matchLen > 0 {
+		dst = append(dst, byte(offset), byte(offset>>8))
+		if ml >= 15 {
+			dst = lzAppendLen(dst, ml-15)
+		}
+	}
+	return dst
+}
+
+func lzAppendLen(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
+
+// lz 解压，格式不正确时返回 ErrInvalidCompressed
+func lzDecompress(src []byte) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 || size > uint64(len(src))*255 {
+		return nil, dberror.ErrInvalidCompressed
+	}
+	src = src[n:]
+	dst := make([]byte, 0, size)
+
+	for len(src) > 0 {
+		token := src[0]
+		src = src[1:]
+
+		litLen := int(token >> 4)
+		if litLen == 15 {
+			var ok bool
+			if litLen, src, ok = lzReadLen(src, litLen); !ok {
+				return nil, dberror.ErrInvalidCompressed
+			}
+		}
+		if litLen > len(src) {
+			return nil, dberror.ErrInvalidCompressed
+		}
+		dst = append(dst, src[:litLen]...)
+		src = src[litLen:]
+		if len(src) == 0 {
+			break
+		}
+
+		if len(src) < 2 {
+			return nil, dberror.ErrInvalidCompressed
+		}
+		offset := int(src[0]) | int(src[1])<<8
+		src = src[2:]
+		matchLen := int(token & 0xf)
+		if matchLen == 15 {
+			var ok bool
+			if matchLen, src, ok = lzReadLen(src, matchLen); !ok {
+				return nil, dberror.ErrInvalidCompressed
+			}
+		}
+		matchLen += lzMinMatch
+
+		if offset == 0 || offset > len(dst) || uint64(len(dst)+matchLen) > size {
+			return nil, dberror.ErrInvalidCompressed
+		}
+		start := len(dst) - offset
+		for j := 0; j < matchLen; j++ {
+			dst = append(dst, dst[start+j])
+		}
+	}
+
+	if uint64(len(dst)) != size {
+		return nil, dberror.ErrInvalidCompressed
+	}
+	return dst, nil
+}
+
+func lzReadLen(src []byte, n int) (int, []byte, bool) {
+	for {
+		if len(src) == 0 {
+			return 0, nil, false
+		}
+		b := src[0]
+		src = src[1:]
+		n += int(b)
+		if b != 255 {
+			return n, src, true
+		}
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
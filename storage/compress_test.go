@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"zeroDB/global/dberror"
+)
+
+func randomBytes(n int, seed int64) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+// 两段相同内容之间隔着 gap 个 0，第二段和第一段的距离是 len(block) + gap
+func farRepeat(block []byte, gap int) []byte {
+	src := append([]byte{}, block...)
+	src = append(src, make([]byte, gap)...)
+	return append(src, block...)
+}
+
+func TestLZRoundTrip(t *testing.T) {
+	block := []byte("ABCDEFGH")
+	tests := []struct {
+		name string
+		src  []byte
+	}{
+		{"empty", nil},
+		{"shorter than min match", []byte("abc")},
+		{"no match", []byte("abcdefghijklmnop")},
+		{"incompressible", randomBytes(64<<10, 1)},
+		{"short run", bytes.Repeat([]byte("ab"), 10)},
+		// 匹配长度超过 15 + 255，需要多个 255 的长度字节
+		{"long run", bytes.Repeat([]byte("a"), 100000)},
+		// 字面量长度超过 15 + 255
+		{"long literals then run", append(randomBytes(1000, 2), bytes.Repeat([]byte("xyz"), 1000)...)},
+		{"run length boundary", bytes.Repeat([]byte("a"), lzMinMatch+15+255+1)},
+		{"repeated text", bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 500)},
+		// 第二个 block 和第一个的距离正好是最远的匹配距离
+		{"max offset", farRepeat(block, lzMaxOffset-len(block))},
+		// 距离超出最远的匹配距离，不能匹配第一个 block
+		{"beyond max offset", farRepeat(block, lzMaxOffset-len(block)+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed := lzCompress(tt.src)
+			got, err := lzDecompress(compressed)
+			if err != nil {
+				t.Fatalf("decompress: %v", err)
+			}
+			if !bytes.Equal(got, tt.src) {
+				t.Fatalf("round trip mismatch: got %d bytes, want %d bytes", len(got), len(tt.src))
+			}
+		})
+	}
+}
+
+func TestLZLongRunCompresses(t *testing.T) {
+	src := bytes.Repeat([]byte("a"), 100000)
+	if compressed := lzCompress(src); len(compressed) > 1000 {
+		t.Fatalf("long run compressed to %d bytes", len(compressed))
+	}
+}
+
+func TestLZCompressMaxOffset(t *testing.T) {
+	// 距离为最远匹配距离时第二个 block 被编码为匹配，再远一个字节时只能作为字面量
+	block := []byte("ABCDEFGH")
+	within := lzCompress(farRepeat(block, lzMaxOffset-len(block)))
+	beyond := lzCompress(farRepeat(block, lzMaxOffset-len(block)+1))
+	if len(within) >= len(beyond) {
+		t.Fatalf("match at max offset not used: %d bytes, beyond max offset %d bytes", len(within), len(beyond))
+	}
+}
+
+func TestLZDecompressMaxOffset(t *testing.T) {
+	// 手工构造一个距离为 65535 的匹配
+	literals := randomBytes(lzMaxOffset, 3)
+	size := len(literals) + lzMinMatch
+	src := binary.AppendUvarint(nil, uint64(size))
+	src = lzAppendSequence(src, literals, lzMaxOffset, lzMinMatch)
+	src = lzAppendSequence(src, nil, 0, 0)
+
+	got, err := lzDecompress(src)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	want := append(append([]byte{}, literals...), literals[:lzMinMatch]...)
+	if !bytes.Equal(got, want) {
+		t.Fatal("max offset match decoded incorrectly")
+	}
+}
+
+func TestLZDecompressMalformed(t *testing.T) {
+	withSize := func(size int, rest ...byte) []byte {
+		return append(binary.AppendUvarint(nil, uint64(size)), rest...)
+	}
+	tests := []struct {
+		name string
+		src  []byte
+	}{
+		{"empty", nil},
+		{"truncated size", []byte{0x80}},
+		{"size too large", withSize(1<<20, 0x10, 'a')},
+		{"literals truncated", withSize(4, 0x40, 'a', 'b')},
+		{"literal length truncated", withSize(20, 0xf0)},
+		{"literal length continuation truncated", withSize(300, 0xf0, 255)},
+		{"offset truncated", withSize(8, 0x10, 'a', 0x01)},
+		{"zero offset", withSize(5, 0x10, 'a', 0x00, 0x00)},
+		{"offset beyond output", withSize(6, 0x10, 'a', 0x02, 0x00)},
+		{"match length truncated", withSize(30, 0x1f, 'a', 0x01, 0x00)},
+		{"match beyond size", withSize(3, 0x10, 'a', 0x01, 0x00)},
+		{"output shorter than size", withSize(10, 0x30, 'a', 'b', 'c')},
+		{"output longer than size", withSize(1, 0x30, 'a', 'b', 'c')},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := lzDecompress(tt.src); err != dberror.ErrInvalidCompressed {
+				t.Fatalf("got err %v, want ErrInvalidCompressed", err)
+			}
+		})
+	}
+}
+
+func TestLZDecompressTruncated(t *testing.T) {
+	src := append(randomBytes(300, 4), bytes.Repeat([]byte("abcd"), 200)...)
+	compressed := lzCompress(src)
+	// 去掉最后只有空字面量的 sequence 仍然可以得到完整的内容，其他截断都必须返回错误
+	for n := 0; n < len(compressed); n++ {
+		got, err := lzDecompress(compressed[:n])
+		if err == nil && bytes.Equal(got, src) {
+			continue
+		}
+		if err != dberror.ErrInvalidCompressed {
+			t.Fatalf("prefix of %d bytes: got err %v, want ErrInvalidCompressed", n, err)
+		}
+	}
+}
+
+func TestLZDecompressCorrupted(t *testing.T) {
+	src := append(randomBytes(300, 5), bytes.Repeat([]byte("abcd"), 200)...)
+	compressed := lzCompress(src)
+	r := rand.New(rand.NewSource(6))
+	for i := 0; i < 2000; i++ {
+		buf := append([]byte{}, compressed...)
+		buf[r.Intn(len(buf))] ^= byte(r.Intn(255) + 1)
+		// 不能 panic，成功时输出的大小必须和记录的原始长度相同
+		got, err := lzDecompress(buf)
+		if err != nil && err != dberror.ErrInvalidCompressed {
+			t.Fatalf("unexpected err %v", err)
+		}
+		if size, _ := binary.Uvarint(buf); err == nil && uint64(len(got)) != size {
+			t.Fatalf("decoded %d bytes, header says %d", len(got), size)
+		}
+	}
+}
@@ -5,21 +5,47 @@ import (
 	"hash/crc32"
 	"time"
 
+	"zeroDB/global/consts"
 	"zeroDB/global/dberror"
 )
 
 const (
-	// 4 * 4 + 8 + 8 + 2 = 34
-	EntryHeaderSize = 34
+	// 4 * 4 + 2 + 8 + 8 + 8 = 42
+	EntryHeaderSize = 42
+
+	// 版本 2 之前的数据文件中 entry 的 header 没有过期时间，4 * 4 + 2 + 8 + 8 = 34
+	legacyEntryHeaderSize = 34
+
+	// state 的高八位中，低三位是数据类型，其余的位作为 entry 的标志位
+	typeMask uint16 = 0x07
+
+	// 标志位的 13、14 位是 value 的压缩方式，见 Codec
+
+	// 标志位：value 保存在 blob 文件中，dbfile 中的 value 是 BlobPointer
+	FlagBlobRef uint16 = 1 << 11
+
+	// 标志位：key、value、extra 被加密，header 作为附加数据被认证
+	FlagEncrypted uint16 = 1 << 12
+
+	// 标志位：crc 覆盖 header 和 key、value、extra 全部内容
+	// 旧格式的 entry 没有这个标志，crc 只覆盖 value
+	FlagFullCrc uint16 = 1 << 15
 )
 
 type (
 	Entry struct {
-		State     uint16 //高八位:数据类型，低八位：操作类型
+		State     uint16 //高八位:标志位和数据类型，低八位：操作类型
 		Crc32     uint32 //校验值，用来比对取出后是否错误
-		Timestamp uint64 //entry创建的时间
+		Timestamp uint64 //entry创建的时间，unix nano，旧版本文件中设置过期时间的 entry 为 0，表示未知
+		Expire    uint64 //过期时间，unix 秒，只有设置过期时间的 entry 使用
 		TxId      uint64 //事务id
 		Meta      *Meta
+
+		// value 在 blob 文件中的位置，设置了 FlagBlobRef 时不为 nil
+		blobRef *BlobPointer
+
+		// 从版本 2 之前的数据文件中读出，header 中没有过期时间
+		legacy bool
 	}
 
 	Meta struct {
@@ -27,11 +53,20 @@ type (
 		Value     []byte
 		Extra     []byte // 放 hash的fiedl 等一些除了kv的内容
 		KeySize   uint32
-		ValueSize uint32
+		ValueSize uint32 // value 在文件中的大小，value 被压缩时和 len(Value) 不同
 		ExtraSize uint32
 	}
 )
 
+// 各数据类型设置过期时间的操作，版本 2 之前的文件中这些 entry 的 Timestamp 是过期时间
+var expireMarks = map[uint16]uint16{
+	consts.String: consts.StringExpire,
+	consts.List:   consts.ListLExpire,
+	consts.Hash:   consts.HashHExpire,
+	consts.Set:    consts.SetSExpire,
+	consts.ZSet:   consts.ZSetZExpire,
+}
+
 // 返回一个新的 entry
 func newInternal(key, value, extra []byte, state uint16, timestamp uint64) *Entry {
 	return &Entry{
@@ -64,11 +99,9 @@ func NewEntryNoExtra(key, value []byte, t, mark uint16) *Entry {
 
 // 创建有过期时间的entry
 func NewEntryWithExpire(key, value []byte, deadline int64, t, mark uint16) *Entry {
-	var state uint16 = 0
-	state = state | (t << 8)
-	state = state | mark
-
-	return newInternal(key, value, nil, state, uint64(deadline))
+	e := NewEntry(key, value, nil, t, mark)
+	e.Expire = uint64(deadline)
+	return e
 }
 
 // 创建有事务信息的entry
@@ -78,16 +111,89 @@ func NewEntryWithTxn(key, value, extra []byte, t, mark uint16, txId uint64) *Ent
 	return e
 }
 
+// 创建事务的提交标记，写在事务的 entry 之后，key 为空，value 是事务写入的所有类型，第 i 位表示类型 i
+func NewCommitEntry(t uint16, txId uint64, types uint16) *Entry {
+	value := make([]byte, 2)
+	binary.BigEndian.PutUint16(value, types)
+	return NewEntryWithTxn(nil, value, nil, t, consts.TxnCommit, txId)
+}
+
+// 是否是事务的提交标记
+func (e *Entry) IsCommit() bool {
+	return e.TxId != 0 && len(e.Meta.Key) == 0 && e.GetMark() == consts.TxnCommit
+}
+
+// 提交标记中事务写入的所有类型
+func (e *Entry) CommitTypes() uint16 {
+	if len(e.Meta.Value) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(e.Meta.Value)
+}
+
+// Clone 复制 entry 的内容和时间，不包括 blob 的位置以及压缩、加密等编码相关的标志位，可以写入另一个 db
+func (e *Entry) Clone() *Entry {
+	c := newInternal(e.Meta.Key, e.Meta.Value, e.Meta.Extra, e.State&(typeMask<<8|0xff), e.Timestamp)
+	c.Expire, c.TxId = e.Expire, e.TxId
+	return c
+}
+
 // 返回entry的大小
 func (e *Entry) Size() uint32 {
-	return EntryHeaderSize + e.Meta.KeySize + e.Meta.ValueSize + e.Meta.ExtraSize
+	size := e.headerSize() + e.Meta.KeySize + e.Meta.ValueSize + e.Meta.ExtraSize
+	if e.State&FlagEncrypted != 0 {
+		size += SealedSize
+	}
+	return size
+}
+
+// entry 在文件中的 header 大小
+func (e *Entry) headerSize() uint32 {
+	if e.legacy {
+		return legacyEntryHeaderSize
+	}
+	return EntryHeaderSize
 }
 
 // 将 entry 编码
 func (e *Entry) Encode() ([]byte, error) {
-	if e == nil || e.Meta.KeySize == 0 {
+	return e.encode(nil)
+}
+
+// 将 entry 编码，根据 opts 压缩 value 以及加密 key、value、extra
+// 编码后 Meta.ValueSize 是 value 在文件中的大小，Meta.Value 保持不变
+func (e *Entry) encode(opts *Options) ([]byte, error) {
+	if e == nil || (e.Meta.KeySize == 0 && !e.IsCommit()) {
 		return nil, dberror.ErrInvalidEntry
 	}
+
+	value := e.Meta.Value
+	if e.State&FlagBlobRef != 0 {
+		value = e.blobRef.Encode()
+	}
+	e.State &^= codecMask | FlagEncrypted
+	codec, threshold := opts.codec()
+	if codec != CodecNone && len(value) > 0 && uint32(len(value)) >= threshold {
+		compressed, err := compressValue(codec, value)
+		if err != nil {
+			return nil, err
+		}
+		// 压缩后没有变小就不压缩
+		if len(compressed) < len(value) {
+			value = compressed
+			e.State |= uint16(codec) << codecShift
+		}
+	}
+	e.Meta.ValueSize = uint32(len(value))
+
+	keyring := opts.keyring()
+	if keyring != nil {
+		e.State |= FlagEncrypted
+	}
+	e.State |= FlagFullCrc
+	// 总是使用当前的格式写入
+	e.legacy = false
+
 	ks, vs := e.Meta.KeySize, e.Meta.ValueSize
 	es := e.Meta.ExtraSize
 
@@ -99,20 +205,64 @@ func (e *Entry) Encode() ([]byte, error) {
 	binary.BigEndian.PutUint16(buf[16:18], e.State)
 	binary.BigEndian.PutUint64(buf[18:26], e.Timestamp)
 	binary.BigEndian.PutUint64(buf[26:34], e.TxId)
+	binary.BigEndian.PutUint64(buf[34:42], e.Expire)
 	copy(buf[EntryHeaderSize:EntryHeaderSize+ks], e.Meta.Key)
-	copy(buf[EntryHeaderSize+ks:EntryHeaderSize+ks+vs], e.Meta.Value)
+	copy(buf[EntryHeaderSize+ks:EntryHeaderSize+ks+vs], value)
 	if es > 0 {
 		copy(buf[EntryHeaderSize+ks+vs:EntryHeaderSize+ks+vs+es], e.Meta.Extra)
 	}
+
+	// 加密 key、value、extra，header 作为附加数据一起认证
+	if keyring != nil {
+		sealed, err := keyring.Seal(buf[EntryHeaderSize:EntryHeaderSize+ks+vs+es], buf[4:EntryHeaderSize])
+		if err != nil {
+			return nil, err
+		}
+		copy(buf[EntryHeaderSize:], sealed)
+	}
+
 	// 用于取出后对比校验，查看是否出错
-	crc := crc32.ChecksumIEEE(e.Meta.Value)
+	// crc 覆盖除 crc 本身以外的所有内容，header 和 payload 的损坏都能发现
+	crc := crc32.ChecksumIEEE(buf[4:])
 	binary.BigEndian.PutUint32(buf[0:4], crc)
+	e.Crc32 = crc
 
 	return buf, nil
 }
 
-// 解码 entry
+// 解密从文件中读取的 payload，返回 key、value、extra 的明文
+func (e *Entry) decrypt(header, payload []byte, keyring *Keyring) ([]byte, error) {
+	if e.State&FlagEncrypted == 0 {
+		return payload, nil
+	}
+	if keyring == nil {
+		return nil, dberror.ErrEncryptionKeyMissing
+	}
+	return keyring.Open(payload, header[4:])
+}
+
+// value 在文件中使用的压缩方式
+func (e *Entry) Codec() Codec {
+	return Codec((e.State & codecMask) >> codecShift)
+}
+
+// 将从文件中读取的 value 解压
+func (e *Entry) decompress() (err error) {
+	if e.Codec() == CodecNone || len(e.Meta.Value) == 0 {
+		return
+	}
+	e.Meta.Value, err = decompressValue(e.Codec(), e.Meta.Value)
+	return
+}
+
+// 解码当前格式的 entry header
 func Decode(buf []byte) (*Entry, error) {
+	return decodeHeader(buf, false)
+}
+
+// 解码 entry header，legacy 为 true 时按照版本 2 之前的格式解码
+// 旧格式中设置过期时间的 entry 的 Timestamp 是过期时间，转换到 Expire，创建时间未知
+func decodeHeader(buf []byte, legacy bool) (*Entry, error) {
 	ks := binary.BigEndian.Uint32(buf[4:8])
 	vs := binary.BigEndian.Uint32(buf[8:12])
 	es := binary.BigEndian.Uint32(buf[12:16])
@@ -121,7 +271,7 @@ func Decode(buf []byte) (*Entry, error) {
 	txId := binary.BigEndian.Uint64(buf[26:34])
 	crc := binary.BigEndian.Uint32(buf[0:4])
 
-	return &Entry{
+	e := &Entry{
 		Meta: &Meta{
 			KeySize:   ks,
 			ValueSize: vs,
@@ -131,12 +281,28 @@ func Decode(buf []byte) (*Entry, error) {
 		Crc32:     crc,
 		Timestamp: timestamp,
 		TxId:      txId,
-	}, nil
+		legacy:    legacy,
+	}
+	if !legacy {
+		e.Expire = binary.BigEndian.Uint64(buf[34:42])
+	} else if mark, ok := expireMarks[e.GetType()]; ok && e.GetMark() == mark {
+		e.Expire, e.Timestamp = timestamp, 0
+	}
+	return e, nil
+}
+
+// 校验 entry 的 crc，buf 是 entry 编码后的完整内容
+// 旧格式的 entry 只校验 value，兼容旧版本写入的数据文件
+func (e *Entry) checkCrc(buf []byte) bool {
+	if e.State&FlagFullCrc != 0 {
+		return crc32.ChecksumIEEE(buf[4:]) == e.Crc32
+	}
+	return crc32.ChecksumIEEE(e.Meta.Value) == e.Crc32
 }
 
 // 从 entry 中获取该 entry 的数据类型
 func (e *Entry) GetType() uint16 {
-	return e.State >> 8
+	return (e.State >> 8) & typeMask
 }
 
 // 从 entry 中获取该 entry 的类型
@@ -3,13 +3,14 @@ package storage
 import (
 	"fmt"
 	"hash/crc32"
+	"io"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"zeroDB/global/dberror"
-	"zeroDB/global/utils"
 )
 
 const (
@@ -34,9 +35,18 @@ var (
 // 储存文件
 type DBFile struct {
 	Id     uint32
-	Path   string   //文件路径
-	File   *os.File //数据储存文件
-	Offset int64    //文件大小
+	Type   uint16      //数据类型
+	Path   string      //文件路径
+	File   File        //数据储存文件
+	Offset int64       //文件大小
+	Header *FileHeader //文件头，旧版本的文件没有文件头，为 nil
+	opts   *Options    //写入时使用的选项
+	// 文件中有没加密或者使用旧密钥加密的 entry，在读取时发现
+	needsRekey bool
+	// 只读打开的归档文件，截断时需要以读写方式重新打开
+	readOnly bool
+	// 有没有 sync 的写入，原子操作
+	dirty uint32
 	// FistMerge      bool     //是否第一次merge过
 	// LastMergeSize  int      //上次merge的文件大小
 	// FirstMergeSize int
@@ -44,28 +54,129 @@ type DBFile struct {
 }
 
 // 打开一个 dbfile 文件，如果不存在就创建
-func NewDBFile(path string, fileId uint32, typ uint16) (*DBFile, error) {
-	filepath := path + PathSeparator + fmt.Sprintf(DBFileFormatNames[typ])
-	//os.O_CREATE|os.O_RDWR
-	file, err := os.OpenFile(filepath, os.O_CREATE|os.O_RDWR, FilePerPm)
+// 新创建的文件会写入文件头，已存在的文件会校验文件头
+func NewDBFile(path string, fileId uint32, typ uint16, opts *Options) (*DBFile, error) {
+	return openDBFile(path, fileId, typ, opts, os.O_CREATE|os.O_RDWR)
+}
+
+// 只读打开一个已经归档的 dbfile，使用 MmapFS 时文件会被映射到内存中
+func openArchivedDBFile(path string, fileId uint32, typ uint16, opts *Options) (*DBFile, error) {
+	return openDBFile(path, fileId, typ, opts, os.O_RDONLY)
+}
+
+func openDBFile(path string, fileId uint32, typ uint16, opts *Options, flag int) (*DBFile, error) {
+	filepath := path + PathSeparator + fmt.Sprintf(DBFileFormatNames[typ], fileId)
+	file, err := opts.fileSystem().OpenFile(filepath, flag, FilePerPm)
 	if err != nil {
 		return nil, err
 	}
-	stat, err := file.Stat()
+	size, err := file.Size()
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 	df := &DBFile{
-		Id:     fileId,
-		Path:   path,
-		Offset: stat.Size(),
+		Id:       fileId,
+		Type:     typ,
+		Path:     path,
+		Offset:   size,
+		opts:     opts,
+		readOnly: flag&(os.O_WRONLY|os.O_RDWR) == 0,
 		// MergePercent:   int(cfg.MergePercent),
 		// FirstMergeSize: cfg.FirstMergeSize,
 	}
 	df.File = file
+
+	if err = df.loadHeader(df.readOnly); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", filepath, err)
+	}
 	return df, nil
 }
 
+// 读取并校验文件头，空文件则写入新的文件头
+// 只读的文件不写入文件头，文件头不完整时当作空文件
+func (df *DBFile) loadHeader(readOnly bool) error {
+	if df.Offset > 0 {
+		n := df.Offset
+		if n > FileHeaderSize {
+			n = FileHeaderSize
+		}
+		buf, err := df.readBuf(0, n)
+		if err != nil {
+			return err
+		}
+		// 没有魔数的是旧版本的文件，entry 从 0 开始
+		if !hasFileMagic(buf) {
+			return nil
+		}
+		// 文件头没有写完整，文件中不会有数据，重新写入
+		if df.Offset >= FileHeaderSize {
+			header, err := DecodeFileHeader(buf)
+			if err != nil {
+				return err
+			}
+			if header.DataType != df.Type {
+				return dberror.ErrInvalidFileHeader
+			}
+			df.Header = header
+			return nil
+		}
+		if readOnly {
+			df.Offset = 0
+			return nil
+		}
+		if err = df.File.Truncate(0); err != nil {
+			return err
+		}
+	}
+	if readOnly {
+		return nil
+	}
+
+	header := newFileHeader(df.Type)
+	if _, err := df.File.WriteAt(header.Encode(), 0); err != nil {
+		return err
+	}
+	df.Header = header
+	df.Offset = FileHeaderSize
+	return nil
+}
+
+// 文件格式的版本
+func (df *DBFile) Version() uint16 {
+	if df.Header == nil {
+		return FileVersionLegacy
+	}
+	return df.Header.Version
+}
+
+// 版本 2 之前的文件中 entry 的 header 没有过期时间
+func (df *DBFile) legacyEntries() bool {
+	return df.Version() < FileVersionExpire
+}
+
+// 文件中 entry 的 header 大小
+func (df *DBFile) entryHeaderSize() int64 {
+	if df.legacyEntries() {
+		return legacyEntryHeaderSize
+	}
+	return EntryHeaderSize
+}
+
+// 第一个 entry 在文件中的位置
+func (df *DBFile) DataOffset() int64 {
+	if df.Header == nil {
+		return 0
+	}
+	return FileHeaderSize
+}
+
+// 文件中是否有需要使用当前密钥重新加密的 entry，读取过整个文件后才准确
+func (df *DBFile) NeedsRekey() bool {
+	return df.needsRekey
+}
+
 // 读取dbfile中数据文件file，返回的是 encode 后的 []byte ,n 代表读取的数据长度
 func (df *DBFile) readBuf(offset int64, n int64) ([]byte, error) {
 	buf := make([]byte, n)
@@ -76,75 +187,223 @@ func (df *DBFile) readBuf(offset int64, n int64) ([]byte, error) {
 	return buf, nil
 }
 
+// 只读取并解码 entry 的 header，不校验 crc
+func (df *DBFile) ReadHeader(offset int64) (*Entry, error) {
+	buf, err := df.readBuf(offset, df.entryHeaderSize())
+	if err != nil {
+		return nil, err
+	}
+	return decodeHeader(buf, df.legacyEntries())
+}
+
+// offset 之后的内容中是否还有完整并且 crc 正确的 entry，用来区分文件末尾没有写完的 entry 和文件中间损坏的 entry
+// 只识别 crc 覆盖整个 entry 的当前格式，旧格式的 crc 只覆盖 value，无法判断
+func (df *DBFile) HasValidEntryAfter(offset int64) (bool, error) {
+	headerSize := df.entryHeaderSize()
+	if offset+headerSize > df.Offset {
+		return false, nil
+	}
+	tail, err := df.readBuf(offset, df.Offset-offset)
+	if err != nil {
+		return false, err
+	}
+	for pos := int64(0); pos+headerSize <= int64(len(tail)); pos++ {
+		e, err := decodeHeader(tail[pos:pos+headerSize], df.legacyEntries())
+		if err != nil || e.State&FlagFullCrc == 0 {
+			continue
+		}
+		end := pos + int64(e.Size())
+		if end <= int64(len(tail)) && crc32.ChecksumIEEE(tail[pos+4:end]) == e.Crc32 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // 将encode的entry读取出来并且decode
+// 读到文件末尾返回 io.EOF，entry 不完整时返回 io.ErrUnexpectedEOF
+// value 保存在 blob 文件中时会一起读取
 func (df *DBFile) Read(offset int64) (e *Entry, err error) {
+	return df.read(offset, true)
+}
+
+// 读取 entry 但不读取 blob 文件，value 在 blob 文件中时 Meta.Value 是 BlobPointer
+// 重写 dbfile 时使用，写入新文件时只复制 BlobPointer
+func (df *DBFile) ReadUnresolved(offset int64) (e *Entry, err error) {
+	return df.read(offset, false)
+}
+
+// resolve 为 false 时不读取 blob 文件，value 保存在 blob 文件中的 entry 的 Meta.Value 是 BlobPointer
+func (df *DBFile) read(offset int64, resolve bool) (e *Entry, err error) {
+	if offset >= df.Offset {
+		return nil, io.EOF
+	}
+	headerSize := df.entryHeaderSize()
+	if offset+headerSize > df.Offset {
+		return nil, io.ErrUnexpectedEOF
+	}
+
 	var buf []byte
 	// 读取 entryhead
-	if buf, err = df.readBuf(offset, int64(EntryHeaderSize)); err != nil {
+	if buf, err = df.readBuf(offset, headerSize); err != nil {
 		return
 	}
-	if e, err = Decode(buf); err != nil {
+	if e, err = decodeHeader(buf, df.legacyEntries()); err != nil {
 		return
 	}
 
-	// 读取 key
-	offset += EntryHeaderSize
-	var key []byte
-	if key, err = df.readBuf(offset, int64(e.Meta.KeySize)); err != nil {
-		return
+	// header 中记录的大小超出了文件，说明 entry 没有完整写入或者 header 已经损坏
+	ks, vs, es := e.Meta.KeySize, e.Meta.ValueSize, e.Meta.ExtraSize
+	payloadSize := int64(ks) + int64(vs) + int64(es)
+	if e.State&FlagEncrypted != 0 {
+		payloadSize += SealedSize
 	}
-	e.Meta.Key = key
-
-	// 读取 value ，如果存在
-	offset += int64(e.Meta.KeySize)
-	if e.Meta.ValueSize > 0 {
-		var value []byte
-		if value, err = df.readBuf(offset, int64(e.Meta.ValueSize)); err != nil {
-			return
-		}
-		e.Meta.Value = value
+	if offset+headerSize+payloadSize > df.Offset {
+		return nil, io.ErrUnexpectedEOF
 	}
-	// 读取 es ，如果存在
-	offset += int64(e.Meta.ValueSize)
-	if e.Meta.ExtraSize > 0 {
-		var extra []byte
-		if extra, err = df.readBuf(offset, int64(e.Meta.ExtraSize)); err != nil {
-			return
-		}
-		e.Meta.Extra = extra
+
+	// 一次读取 key、value、extra
+	var payload []byte
+	if payload, err = df.readBuf(offset+headerSize, payloadSize); err != nil {
+		return
 	}
 
 	// 进行 crc 对比校验，看是否出错
-	check := crc32.ChecksumIEEE(e.Meta.Value)
-	if e.Crc32 != check {
+	if !e.checkCrc(append(buf, payload...)) {
 		return nil, dberror.ErrInvalidCrc
 	}
+
+	// 使用旧密钥加密或者没有加密的文件，需要在 Reclaim 时重新加密
+	keyring := df.opts.keyring()
+	if keyring != nil && (e.State&FlagEncrypted == 0 || SealedKeyId(payload) != keyring.ActiveId()) {
+		df.needsRekey = true
+	}
+	if payload, err = e.decrypt(buf, payload, keyring); err != nil {
+		return nil, err
+	}
+
+	e.Meta.Key = payload[:ks]
+	if vs > 0 {
+		e.Meta.Value = payload[ks : ks+vs]
+	}
+	if es > 0 {
+		e.Meta.Extra = payload[ks+vs:]
+	}
+	if err = e.decompress(); err != nil {
+		return nil, err
+	}
+	if err = df.resolveBlob(e, resolve); err != nil {
+		return nil, err
+	}
 	return
 }
 
+// 解码 value 中的 BlobPointer，resolve 为 true 时从 blob 文件中读取 value
+func (df *DBFile) resolveBlob(e *Entry, resolve bool) (err error) {
+	if e.State&FlagBlobRef == 0 {
+		return nil
+	}
+	if e.blobRef, err = DecodeBlobPointer(e.Meta.Value); err != nil {
+		return err
+	}
+	if !resolve {
+		return nil
+	}
+	bs := df.opts.blobs()
+	if bs == nil {
+		return dberror.ErrBlobStoreMissing
+	}
+	e.Meta.Value, err = bs.get(e.blobRef)
+	return err
+}
+
 // 向 dbfile 中追加写入 entry
 func (df *DBFile) Write(e *Entry) error {
-	// key 为空 或者 空entry 无法写入
-	if e == nil || e.Meta.KeySize == 0 {
+	// key 为空 或者 空entry 无法写入，只有事务的提交标记没有 key
+	if e == nil || (e.Meta.KeySize == 0 && !e.IsCommit()) {
 		return dberror.ErrEmptyEntry
 	}
+	// entry 总是以当前格式编码，旧版本的文件只能读取
+	if df.Version() != FileVersionCurrent {
+		return dberror.ErrOldFileVersion
+	}
 	writeOffset := df.Offset
-	enVal, err := e.Encode()
+	bs := df.opts.blobs()
+	if err := bs.separate(e); err != nil {
+		return err
+	}
+	enVal, err := e.encode(df.opts)
 	if err != nil {
 		return err
 	}
+	// 分离的 value 在写入 blob 文件时已经统计过
+	if e.State&FlagBlobRef == 0 {
+		df.opts.stats().addValue(len(e.Meta.Value), int(e.Meta.ValueSize))
+	}
 
 	if _, err := df.File.WriteAt(enVal, writeOffset); err != nil {
 		return err
 	}
+	atomic.StoreUint32(&df.dirty, 1)
+	bs.AddRef(e)
 
 	//追加内容后，文件变大，offset增加
 	df.Offset += int64(e.Size())
 	return nil
 }
 
+// 将文件截断到 size 大小，丢弃之后的内容
+func (df *DBFile) Truncate(size int64) (err error) {
+	if df.readOnly {
+		return df.truncateReadOnly(size)
+	}
+	if err = df.File.Truncate(size); err != nil {
+		return
+	}
+	df.Offset = size
+	return df.File.Sync()
+}
+
+// 只读打开的文件（例如 MmapFS 映射的文件）不能截断，关闭之后以读写方式打开截断，再重新只读打开
+func (df *DBFile) truncateReadOnly(size int64) error {
+	fs, name := df.opts.fileSystem(), df.File.Name()
+	if err := df.File.Close(); err != nil {
+		return err
+	}
+	err := truncateFile(fs, name, size)
+	// 截断失败时也要重新打开，df 还会被使用
+	file, openErr := fs.OpenFile(name, os.O_RDONLY, FilePerPm)
+	if openErr != nil {
+		df.File = nil
+		return openErr
+	}
+	df.File = file
+	if err != nil {
+		return err
+	}
+	df.Offset = size
+	return nil
+}
+
+func truncateFile(fs FileSystem, name string, size int64) (err error) {
+	file, err := fs.OpenFile(name, os.O_RDWR, FilePerPm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if err = file.Truncate(size); err != nil {
+		return
+	}
+	return file.Sync()
+}
+
 // 立刻将文件保存到硬盘中
 func (df *DBFile) Sync() (err error) {
+	atomic.StoreUint32(&df.dirty, 0)
 	if df.File != nil {
 		//将文件系统的最近写入的数据在内存中的拷贝刷新到硬盘中
 		err = df.File.Sync()
@@ -152,6 +411,11 @@ func (df *DBFile) Sync() (err error) {
 	return err
 }
 
+// 是否有还没有 sync 的写入
+func (df *DBFile) Dirty() bool {
+	return atomic.LoadUint32(&df.dirty) == 1
+}
+
 // 关闭文件，sync表示是否要立刻写入磁盘
 func (df *DBFile) Close(sync bool) (err error) {
 	if sync {
@@ -163,60 +427,177 @@ func (df *DBFile) Close(sync bool) (err error) {
 	return
 }
 
-// 加载磁盘中所有dbfile，返回数据类型和dbfile的map
-func Build(path string, blockSize int64) (map[uint16]map[uint32]*DBFile, map[uint16]uint32, error) {
-	dir, err := utils.ReadDir(path)
+// 找到目录中所有的 dbfile，返回每种数据类型的 file id，已经从小到大排序
+func ListDBFiles(path string, opts *Options) (map[uint16][]int, error) {
+	return listDBFiles(path, opts, false)
+}
+
+// readOnly 为 true 时不重命名旧版本的 dbfile，遇到这样的文件返回 ErrReadOnly
+func listDBFiles(path string, opts *Options, readOnly bool) (map[uint16][]int, error) {
+	fs := opts.fileSystem()
+	names, err := fs.ReadDir(path)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	fileIdsMap := make(map[uint16][]int)
-	for _, d := range dir {
-		if strings.Contains(d.Name(), ".data") {
-			splitNames := strings.Split(d.Name(), ".")
-			id, _ := strconv.Atoi(splitNames[0])
+	for _, name := range names {
+		if strings.Contains(name, ".data") {
+			splitNames := strings.Split(name, ".")
+			if len(splitNames) != 3 {
+				continue
+			}
 
 			// find the different types of file.
-			switch splitNames[2] {
-			case DBFileSuffixName[0]:
-				fileIdsMap[0] = append(fileIdsMap[0], id)
-			case DBFileSuffixName[1]:
-				fileIdsMap[1] = append(fileIdsMap[1], id)
-			case DBFileSuffixName[2]:
-				fileIdsMap[2] = append(fileIdsMap[2], id)
-			case DBFileSuffixName[3]:
-				fileIdsMap[3] = append(fileIdsMap[3], id)
-			case DBFileSuffixName[4]:
-				fileIdsMap[4] = append(fileIdsMap[4], id)
+			var dataType uint16
+			for dataType = 0; dataType < 5; dataType++ {
+				if splitNames[2] == DBFileSuffixName[dataType] {
+					break
+				}
+			}
+			if dataType == 5 {
+				continue
+			}
+
+			id, err := strconv.Atoi(splitNames[0])
+			if err != nil {
+				// 旧版本创建文件时没有格式化 file id，所有数据都在同一个文件中，将其作为 0 号文件
+				if readOnly {
+					return nil, fmt.Errorf("%w: %s needs to be renamed, open it in read-write mode first", dberror.ErrReadOnly, name)
+				}
+				if err = fs.Rename(path+PathSeparator+name, path+PathSeparator+fmt.Sprintf(DBFileFormatNames[dataType], 0)); err != nil {
+					return nil, err
+				}
+				id = 0
+			}
+			fileIdsMap[dataType] = append(fileIdsMap[dataType], id)
+		}
+	}
+	for _, ids := range fileIdsMap {
+		sort.Ints(ids)
+	}
+	return fileIdsMap, nil
+}
+
+// 加载 manifest 中所有的 dbfile，返回每种数据类型的归档文件和 manifest，活跃文件见 Manifest.ActiveFileId
+// 不在 manifest 中的文件是崩溃时留下的，会被删除
+// 旧版本的目录没有 manifest，按照 file id 的顺序加载目录中所有的 dbfile，并生成 manifest
+func Build(path string, blockSize int64, opts *Options) (map[uint16]map[uint32]*DBFile, *Manifest, error) {
+	// 新生成的 manifest 或者新增了文件时需要持久化
+	var changed bool
+	manifest, err := LoadManifest(path, opts)
+	if os.IsNotExist(err) {
+		changed = true
+		fileIdsMap, err := ListDBFiles(path, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		files := make(map[uint16][]uint32)
+		for dataType, ids := range fileIdsMap {
+			for _, id := range ids {
+				files[dataType] = append(files[dataType], uint32(id))
 			}
 		}
+		manifest = newManifest(path, files, opts)
+	} else if err != nil {
+		return nil, nil, err
+	} else if err = manifest.removeUnlisted(); err != nil {
+		return nil, nil, err
+	}
+
+	// 没有 dbfile 的类型需要一个活跃文件，先写入 manifest 再创建
+	for dataType := uint16(0); dataType < 5; dataType++ {
+		if len(manifest.files[dataType]) == 0 {
+			manifest.files[dataType] = []uint32{manifest.nextId[dataType]}
+			manifest.nextId[dataType]++
+			changed = true
+		}
+	}
+	if changed {
+		if err = manifest.save(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	archFiles, err := openDBFiles(path, manifest, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return archFiles, manifest, nil
+}
+
+// CloseDBFiles 关闭 Build 打开的归档文件，打开 db 失败时使用，不 sync
+func CloseDBFiles(archFiles map[uint16]map[uint32]*DBFile) {
+	for _, files := range archFiles {
+		for _, f := range files {
+			f.Close(false)
+		}
 	}
+}
 
-	// load all the db files.
-	activeFileIds := make(map[uint16]uint32)
+// 只读打开 manifest 中除了活跃文件以外的所有 dbfile
+func openDBFiles(path string, manifest *Manifest, opts *Options) (map[uint16]map[uint32]*DBFile, error) {
 	archFiles := make(map[uint16]map[uint32]*DBFile)
 	var dataType uint16 = 0
 	for ; dataType < 5; dataType++ {
-		fileIDs := fileIdsMap[dataType]
-		sort.Ints(fileIDs)
+		fileIDs := manifest.files[dataType]
 		files := make(map[uint32]*DBFile)
-		var activeFileId uint32 = 0
-
-		if len(fileIDs) > 0 {
-			activeFileId = uint32(fileIDs[len(fileIDs)-1])
+		for i := 0; i < len(fileIDs)-1; i++ {
+			file, err := openArchivedDBFile(path, fileIDs[i], dataType, opts)
+			if err != nil {
+				archFiles[dataType] = files
+				CloseDBFiles(archFiles)
+				return nil, err
+			}
+			files[fileIDs[i]] = file
+		}
+		archFiles[dataType] = files
+	}
+	return archFiles, nil
+}
 
-			for i := 0; i < len(fileIDs)-1; i++ {
-				id := fileIDs[i]
+// BuildReadOnly 和 Build 相同，但是不修改目录：manifest 不存在时只在内存中生成，不删除任何文件
+// 活跃文件也只读打开，单独返回，没有 dbfile 的类型没有活跃文件
+func BuildReadOnly(path string, opts *Options) (map[uint16]map[uint32]*DBFile, map[uint16]*DBFile, *Manifest, error) {
+	manifest, err := LoadManifest(path, opts)
+	if os.IsNotExist(err) {
+		fileIdsMap, err := listDBFiles(path, opts, true)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files := make(map[uint16][]uint32)
+		for dataType, ids := range fileIdsMap {
+			for _, id := range ids {
+				files[dataType] = append(files[dataType], uint32(id))
+			}
+		}
+		manifest = newManifest(path, files, opts)
+	} else if err != nil {
+		return nil, nil, nil, err
+	}
 
-				file, err := NewDBFile(path, uint32(id), dataType)
-				if err != nil {
-					return nil, nil, err
-				}
-				files[uint32(id)] = file
+	archFiles, err := openDBFiles(path, manifest, opts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	activeFiles := make(map[uint16]*DBFile)
+	for dataType := uint16(0); dataType < 5; dataType++ {
+		fileIds := manifest.files[dataType]
+		if len(fileIds) == 0 {
+			continue
+		}
+		// 活跃文件可能已经加入 manifest 但是还没有创建
+		file, err := openArchivedDBFile(path, fileIds[len(fileIds)-1], dataType, opts)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			CloseDBFiles(archFiles)
+			for _, f := range activeFiles {
+				f.Close(false)
 			}
+			return nil, nil, nil, err
 		}
-		archFiles[dataType] = files
-		activeFileIds[dataType] = activeFileId
+		activeFiles[dataType] = file
 	}
-	return archFiles, activeFileIds, nil
+	return archFiles, activeFiles, manifest, nil
 }
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"zeroDB/global/dberror"
+)
+
+type (
+	// File dbfile、hint file 等文件的读写接口
+	File interface {
+		io.ReaderAt
+		io.WriterAt
+		Name() string
+		Size() (int64, error)
+		Truncate(size int64) error
+		Sync() error
+		Close() error
+	}
+
+	// FileSystem 打开和管理 File 的方式，可以替换为内存或者注入错误的实现
+	FileSystem interface {
+		// flag 与 os.OpenFile 相同
+		OpenFile(name string, flag int, perm os.FileMode) (File, error)
+		ReadFile(name string) ([]byte, error)
+		// 返回目录中的文件名
+		ReadDir(name string) ([]string, error)
+		Rename(oldpath, newpath string) error
+		Remove(name string) error
+		RemoveAll(path string) error
+		MkdirAll(path string, perm os.FileMode) error
+	}
+)
+
+// 根据名字返回文件系统：os、mmap、memory，为空时使用 os
+// memory 每次都会返回一个新的空文件系统
+func NewFileSystem(name string) (FileSystem, error) {
+	switch strings.ToLower(name) {
+	case "", "os":
+		return OSFS{}, nil
+	case "mmap":
+		return MmapFS{}, nil
+	case "memory":
+		return NewMemFS(), nil
+	}
+	return nil, dberror.ErrUnknownFileSystem
+}
+
+// OSFS 使用操作系统的文件
+type OSFS struct{}
+
+type osFile struct {
+	*os.File
+}
+
+func (OSFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	file, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return osFile{file}, nil
+}
+
+func (OSFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (OSFS) ReadDir(name string) ([]string, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+func (OSFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (OSFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (OSFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (f osFile) Size() (int64, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"zeroDB/global/dberror"
+)
+
+// MemFS 所有文件都保存在内存中的文件系统，关闭进程后数据丢失
+// 已经打开的文件在 rename 或 remove 之后仍然可以读写，与操作系统的行为相同
+type MemFS struct {
+	mu    sync.RWMutex
+	files map[string]*memData
+	dirs  map[string]struct{}
+	locks map[string]int // 被加锁的目录，见 LockDir
+}
+
+// 一个文件的内容
+type memData struct {
+	mu  sync.RWMutex
+	buf []byte
+}
+
+// memFile 打开的内存文件
+type memFile struct {
+	name     string
+	data     *memData
+	readOnly bool
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: make(map[string]*memData),
+		dirs:  map[string]struct{}{"/": {}, ".": {}},
+		locks: make(map[string]int),
+	}
+}
+
+func (fs *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name = path.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	data, ok := fs.files[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		if _, ok := fs.dirs[path.Dir(name)]; !ok {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		data = new(memData)
+		fs.files[name] = data
+	}
+	readOnly := flag&(os.O_WRONLY|os.O_RDWR) == 0
+	if flag&os.O_TRUNC != 0 && !readOnly {
+		data.mu.Lock()
+		data.buf = nil
+		data.mu.Unlock()
+	}
+	return &memFile{name: name, data: data, readOnly: readOnly}, nil
+}
+
+func (fs *MemFS) ReadFile(name string) ([]byte, error) {
+	fs.mu.RLock()
+	data, ok := fs.files[path.Clean(name)]
+	fs.mu.RUnlock()
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	data.mu.RLock()
+	defer data.mu.RUnlock()
+	return append([]byte(nil), data.buf...), nil
+}
+
+func (fs *MemFS) ReadDir(name string) ([]string, error) {
+	name = path.Clean(name)
+	fs.mu.RLock()
+	defer fs.mu.RUnlock()
+
+	if _, ok := fs.dirs[name]; !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	var names []string
+	for filename := range fs.files {
+		if path.Dir(filename) == name {
+			names = append(names, path.Base(filename))
+		}
+	}
+	for dir := range fs.dirs {
+		if dir != name && path.Dir(dir) == name {
+			names = append(names, path.Base(dir))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (fs *MemFS) Rename(oldpath, newpath string) error {
+	oldpath, newpath = path.Clean(oldpath), path.Clean(newpath)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	data, ok := fs.files[oldpath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if _, ok := fs.dirs[path.Dir(newpath)]; !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	delete(fs.files, oldpath)
+	fs.files[newpath] = data
+	return nil
+}
+
+func (fs *MemFS) Remove(name string) error {
+	name = path.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.files[name]; ok {
+		delete(fs.files, name)
+		return nil
+	}
+	if _, ok := fs.dirs[name]; ok {
+		for other := range fs.files {
+			if path.Dir(other) == name {
+				return &os.PathError{Op: "remove", Path: name, Err: os.ErrExist}
+			}
+		}
+		delete(fs.dirs, name)
+		return nil
+	}
+	return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+}
+
+func (fs *MemFS) RemoveAll(name string) error {
+	name = path.Clean(name)
+	prefix := name + "/"
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for filename := range fs.files {
+		if filename == name || strings.HasPrefix(filename, prefix) {
+			delete(fs.files, filename)
+		}
+	}
+	for dir := range fs.dirs {
+		if dir == name || strings.HasPrefix(dir, prefix) {
+			delete(fs.dirs, dir)
+		}
+	}
+	return nil
+}
+
+func (fs *MemFS) MkdirAll(name string, perm os.FileMode) error {
+	name = path.Clean(name)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for {
+		if _, ok := fs.files[name]; ok {
+			return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+		}
+		fs.dirs[name] = struct{}{}
+		parent := path.Dir(name)
+		if parent == name {
+			return nil
+		}
+		name = parent
+	}
+}
+
+func (f *memFile) ReadAt(b []byte, off int64) (int, error) {
+	f.data.mu.RLock()
+	defer f.data.mu.RUnlock()
+	return readAt(f.data.buf, b, off)
+}
+
+func (f *memFile) WriteAt(b []byte, off int64) (int, error) {
+	if f.readOnly {
+		return 0, dberror.ErrReadOnlyFile
+	}
+	f.data.mu.Lock()
+	defer f.data.mu.Unlock()
+
+	if end := off + int64(len(b)); end > int64(len(f.data.buf)) {
+		if end > int64(cap(f.data.buf)) {
+			buf := make([]byte, end, end*2)
+			copy(buf, f.data.buf)
+			f.data.buf = buf
+		} else {
+			// 截断后残留的内容需要清零
+			n := len(f.data.buf)
+			f.data.buf = f.data.buf[:end]
+			for i := n; int64(i) < off; i++ {
+				f.data.buf[i] = 0
+			}
+		}
+	}
+	return copy(f.data.buf[off:], b), nil
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Size() (int64, error) {
+	f.data.mu.RLock()
+	defer f.data.mu.RUnlock()
+	return int64(len(f.data.buf)), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if f.readOnly {
+		return dberror.ErrReadOnlyFile
+	}
+	f.data.mu.Lock()
+	defer f.data.mu.Unlock()
+
+	if size <= int64(len(f.data.buf)) {
+		f.data.buf = f.data.buf[:size]
+		return nil
+	}
+	buf := make([]byte, size)
+	copy(buf, f.data.buf)
+	f.data.buf = buf
+	return nil
+}
+
+func (f *memFile) Sync() error {
+	return nil
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+// 从 data 的 off 位置读取到 b 中，读不满 b 时返回 io.EOF
+func readAt(data, b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, os.ErrInvalid
+	}
+	if off >= int64(len(data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// memLock 内存文件系统中一个目录的锁
+type memLock struct {
+	fs     *MemFS
+	dir    string
+	shared bool
+}
+
+// 内存文件系统只在同一个 MemFS 中互斥
+func (fs *MemFS) LockDir(dir string, shared bool) (io.Closer, error) {
+	dir = path.Clean(dir)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// -1 表示排他锁，大于 0 是共享锁的数量
+	n := fs.locks[dir]
+	if n < 0 || (n > 0 && !shared) {
+		return nil, dberror.ErrDirLocked
+	}
+	if shared {
+		fs.locks[dir]++
+	} else {
+		fs.locks[dir] = -1
+	}
+	return &memLock{fs: fs, dir: dir, shared: shared}, nil
+}
+
+func (l *memLock) Close() error {
+	l.fs.mu.Lock()
+	defer l.fs.mu.Unlock()
+
+	if l.shared && l.fs.locks[l.dir] > 1 {
+		l.fs.locks[l.dir]--
+	} else {
+		delete(l.fs.locks, l.dir)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"os"
+
+	"zeroDB/global/dberror"
+)
+
+// MmapFS 和 OSFS 相同，只读打开的文件（归档的 dbfile）会被映射到内存中读取
+type MmapFS struct {
+	OSFS
+}
+
+func (fs MmapFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return fs.OSFS.OpenFile(name, flag, perm)
+	}
+	file, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	data, err := mmap(file, stat.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &mmapFile{file: file, data: data}, nil
+}
+
+// mmapFile 只读的内存映射文件
+type mmapFile struct {
+	file *os.File
+	data []byte
+}
+
+func (f *mmapFile) ReadAt(b []byte, off int64) (int, error) {
+	return readAt(f.data, b, off)
+}
+
+func (f *mmapFile) WriteAt(b []byte, off int64) (int, error) {
+	return 0, dberror.ErrReadOnlyFile
+}
+
+func (f *mmapFile) Name() string {
+	return f.file.Name()
+}
+
+func (f *mmapFile) Size() (int64, error) {
+	return int64(len(f.data)), nil
+}
+
+func (f *mmapFile) Truncate(size int64) error {
+	return dberror.ErrReadOnlyFile
+}
+
+// 映射的文件不会被修改，不需要 sync
+func (f *mmapFile) Sync() error {
+	return nil
+}
+
+func (f *mmapFile) Close() error {
+	if err := munmap(f.data); err != nil {
+		f.file.Close()
+		return err
+	}
+	f.data = nil
+	return f.file.Close()
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+
+	"zeroDB/global/dberror"
+)
+
+const (
+	// 4 + 2 + 2 + 8 + 4 = 20
+	FileHeaderSize = 20
+
+	// 没有文件头的旧版本数据文件
+	FileVersionLegacy uint16 = 0
+
+	// 文件头 + crc 覆盖整个 entry
+	FileVersionFullCrc uint16 = 1
+
+	// entry 的 header 中有单独的过期时间，Timestamp 只表示创建时间
+	FileVersionExpire uint16 = 2
+
+	// 当前版本
+	FileVersionCurrent = FileVersionExpire
+)
+
+// 数据文件开头的魔数
+var fileMagic = []byte("ZDBF")
+
+// FileHeader 数据文件的文件头，记录文件格式的版本，用于之后格式的演进
+type FileHeader struct {
+	Version   uint16
+	DataType  uint16
+	CreatedAt int64 // 文件创建时间，unix nano
+}
+
+// 创建当前版本的文件头
+func newFileHeader(typ uint16) *FileHeader {
+	return &FileHeader{
+		Version:   FileVersionCurrent,
+		DataType:  typ,
+		CreatedAt: time.Now().UnixNano(),
+	}
+}
+
+// 将文件头编码
+func (h *FileHeader) Encode() []byte {
+	buf := make([]byte, FileHeaderSize)
+	copy(buf[0:4], fileMagic)
+	binary.BigEndian.PutUint16(buf[4:6], h.Version)
+	binary.BigEndian.PutUint16(buf[6:8], h.DataType)
+	binary.BigEndian.PutUint64(buf[8:16], uint64(h.CreatedAt))
+	binary.BigEndian.PutUint32(buf[16:20], crc32.ChecksumIEEE(buf[:16]))
+	return buf
+}
+
+// 判断 buf 是否以魔数开头，buf 不足魔数长度时判断是否是魔数的前缀
+func hasFileMagic(buf []byte) bool {
+	if len(buf) < len(fileMagic) {
+		return bytes.HasPrefix(fileMagic, buf)
+	}
+	return bytes.Equal(buf[:len(fileMagic)], fileMagic)
+}
+
+// 解码并校验文件头
+func DecodeFileHeader(buf []byte) (*FileHeader, error) {
+	if len(buf) < FileHeaderSize || !hasFileMagic(buf) {
+		return nil, dberror.ErrInvalidFileHeader
+	}
+	if crc32.ChecksumIEEE(buf[:16]) != binary.BigEndian.Uint32(buf[16:20]) {
+		return nil, dberror.ErrInvalidFileHeader
+	}
+
+	h := &FileHeader{
+		Version:   binary.BigEndian.Uint16(buf[4:6]),
+		DataType:  binary.BigEndian.Uint16(buf[6:8]),
+		CreatedAt: int64(binary.BigEndian.Uint64(buf[8:16])),
+	}
+	if h.Version == FileVersionLegacy || h.Version > FileVersionCurrent {
+		return nil, dberror.ErrUnsupportedFileVersion
+	}
+	return h, nil
+}
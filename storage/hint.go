@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"zeroDB/global/dberror"
+)
+
+const (
+	// 4 * 4 + 2 + 8 + 8 + 8 + 8 + 4 = 54
+	HintHeaderSize = 54
+
+	// 写 hint 文件时使用的临时后缀，写完后 rename
+	hintTmpSuffix = ".tmp"
+
+	// 开启加密时 hint 文件以该 magic 开头，之后是使用 keyring 加密的所有 hint
+	sealedHintMagic = "ZKVH"
+)
+
+var (
+	// hint 文件的命名格式，和 dbfile 一一对应
+	HintFileFormatNames = map[uint16]string{
+		0: "%09d.hint.str",
+		1: "%09d.hint.list",
+		2: "%09d.hint.hash",
+		3: "%09d.hint.set",
+		4: "%09d.hint.zset",
+	}
+)
+
+// Hint 是 dbfile 中一条 entry 的索引信息，不包含 value
+// 启动时通过 hint 文件可以直接定位 entry，不需要逐条解码和校验整个 dbfile
+type Hint struct {
+	Key       []byte
+	Extra     []byte
+	State     uint16
+	Timestamp uint64 // entry 的创建时间
+	Expire    uint64 // entry 的过期时间
+	TxId      uint64
+	Offset    int64  // entry 在 dbfile 中的位置
+	ValueSize uint32 // value 的大小，为 0 时不需要读取 dbfile
+	Size      uint32 // entry 在 dbfile 中的总大小
+}
+
+// 返回 hint 文件的路径
+func HintFilePath(path string, fileId uint32, typ uint16) string {
+	return path + PathSeparator + fmt.Sprintf(HintFileFormatNames[typ], fileId)
+}
+
+// 将 hint 编码
+func (h *Hint) Encode() []byte {
+	ks, es := uint32(len(h.Key)), uint32(len(h.Extra))
+	buf := make([]byte, HintHeaderSize+ks+es)
+
+	binary.BigEndian.PutUint32(buf[4:8], ks)
+	binary.BigEndian.PutUint32(buf[8:12], es)
+	binary.BigEndian.PutUint32(buf[12:16], h.ValueSize)
+	binary.BigEndian.PutUint16(buf[16:18], h.State)
+	binary.BigEndian.PutUint64(buf[18:26], h.Timestamp)
+	binary.BigEndian.PutUint64(buf[26:34], h.TxId)
+	binary.BigEndian.PutUint64(buf[34:42], uint64(h.Offset))
+	binary.BigEndian.PutUint32(buf[42:46], h.Size)
+	binary.BigEndian.PutUint64(buf[46:54], h.Expire)
+	copy(buf[HintHeaderSize:HintHeaderSize+ks], h.Key)
+	copy(buf[HintHeaderSize+ks:], h.Extra)
+
+	crc := crc32.ChecksumIEEE(buf[4:])
+	binary.BigEndian.PutUint32(buf[0:4], crc)
+	return buf
+}
+
+// 为一个已经归档的 dbfile 生成 hint 文件
+// 先写入临时文件再 rename，保证 hint 文件要么完整要么不存在
+// 开启加密时 hint 文件使用 keyring 加密，文件中有需要用新密钥重写的 entry 时不生成，见 NeedsRekey
+func WriteHintFile(df *DBFile) (err error) {
+	var hints []byte
+	offset := df.DataOffset()
+	for offset < df.Offset {
+		e, err := df.read(offset, false)
+		if err != nil {
+			return err
+		}
+		h := &Hint{
+			Key:       e.Meta.Key,
+			Extra:     e.Meta.Extra,
+			State:     e.State,
+			Timestamp: e.Timestamp,
+			Expire:    e.Expire,
+			TxId:      e.TxId,
+			Offset:    offset,
+			ValueSize: e.Meta.ValueSize,
+			Size:      e.Size(),
+		}
+		hints = append(hints, h.Encode()...)
+		offset += int64(e.Size())
+	}
+	if keyring := df.opts.keyring(); keyring != nil {
+		// 没有加密的 entry 通过 hint 读取时不会被发现，Reclaim 重写之后再生成
+		if df.NeedsRekey() {
+			return RemoveHintFile(df.Path, df.Id, df.Type, df.opts)
+		}
+		sealed, err := keyring.Seal(hints, hintAad(df))
+		if err != nil {
+			return err
+		}
+		hints = append([]byte(sealedHintMagic), sealed...)
+	}
+
+	fs := df.opts.fileSystem()
+	path := HintFilePath(df.Path, df.Id, df.Type)
+	tmpPath := path + hintTmpSuffix
+	file, err := fs.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePerPm)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteAt(hints, 0); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return fs.Rename(tmpPath, path)
+}
+
+// 读取 dbfile 对应的 hint 文件
+// hint 文件不存在时返回 os.ErrNotExist，hint 文件损坏时返回 ErrInvalidHint
+// 开启加密时只使用加密的 hint 文件，没有加密或者无法解密时同样返回 ErrInvalidHint
+func LoadHintFile(df *DBFile) ([]*Hint, error) {
+	buf, err := df.opts.fileSystem().ReadFile(HintFilePath(df.Path, df.Id, df.Type))
+	if err != nil {
+		return nil, err
+	}
+	if keyring := df.opts.keyring(); keyring != nil {
+		if len(buf) < len(sealedHintMagic) || string(buf[:len(sealedHintMagic)]) != sealedHintMagic {
+			return nil, dberror.ErrInvalidHint
+		}
+		if buf, err = keyring.Open(buf[len(sealedHintMagic):], hintAad(df)); err != nil {
+			return nil, dberror.ErrInvalidHint
+		}
+	}
+
+	var hints []*Hint
+	for len(buf) > 0 {
+		if len(buf) < HintHeaderSize {
+			return nil, dberror.ErrInvalidHint
+		}
+		ks := binary.BigEndian.Uint32(buf[4:8])
+		es := binary.BigEndian.Uint32(buf[8:12])
+		size := HintHeaderSize + int(ks) + int(es)
+		if len(buf) < size || crc32.ChecksumIEEE(buf[4:size]) != binary.BigEndian.Uint32(buf[0:4]) {
+			return nil, dberror.ErrInvalidHint
+		}
+
+		h := &Hint{
+			Key:       buf[HintHeaderSize : HintHeaderSize+ks],
+			ValueSize: binary.BigEndian.Uint32(buf[12:16]),
+			State:     binary.BigEndian.Uint16(buf[16:18]),
+			Timestamp: binary.BigEndian.Uint64(buf[18:26]),
+			TxId:      binary.BigEndian.Uint64(buf[26:34]),
+			Offset:    int64(binary.BigEndian.Uint64(buf[34:42])),
+			Size:      binary.BigEndian.Uint32(buf[42:46]),
+			Expire:    binary.BigEndian.Uint64(buf[46:54]),
+		}
+		if es > 0 {
+			h.Extra = buf[HintHeaderSize+ks : size]
+		}
+		// hint 指向的位置必须在 dbfile 内
+		if h.Offset+int64(h.Size) > df.Offset {
+			return nil, dberror.ErrInvalidHint
+		}
+		hints = append(hints, h)
+		buf = buf[size:]
+	}
+	return hints, nil
+}
+
+// 加密 hint 文件时的附加数据，hint 文件不能被换到其他 dbfile 使用
+func hintAad(df *DBFile) []byte {
+	return []byte(sealedHintMagic + fmt.Sprintf(HintFileFormatNames[df.Type], df.Id))
+}
+
+// 删除 dbfile 对应的 hint 文件
+func RemoveHintFile(path string, fileId uint32, typ uint16, opts *Options) error {
+	err := opts.fileSystem().Remove(HintFilePath(path, fileId, typ))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// 根据 hint 还原出 entry，只有 value 需要从 dbfile 中读取
+// withValue 为 false 时不读取 value，例如 key-only 模式的 string index 只需要 entry 的位置和大小
+// 这时 value 在 blob 文件中的 entry 只读取 BlobPointer，事务的提交标记仍然读取 value
+func (df *DBFile) ReadHint(h *Hint, withValue bool) (*Entry, error) {
+	// 加密的 value 无法单独读取
+	if h.State&FlagEncrypted != 0 {
+		return df.Read(h.Offset)
+	}
+	e := &Entry{
+		State:     h.State,
+		Timestamp: h.Timestamp,
+		Expire:    h.Expire,
+		TxId:      h.TxId,
+		legacy:    df.legacyEntries(),
+		Meta: &Meta{
+			Key:       h.Key,
+			Extra:     h.Extra,
+			KeySize:   uint32(len(h.Key)),
+			ValueSize: h.ValueSize,
+			ExtraSize: uint32(len(h.Extra)),
+		},
+	}
+	if !withValue && e.State&FlagBlobRef == 0 && !e.IsCommit() {
+		return e, nil
+	}
+	if h.ValueSize > 0 {
+		value, err := df.readBuf(h.Offset+df.entryHeaderSize()+int64(e.Meta.KeySize), int64(h.ValueSize))
+		if err != nil {
+			if err == io.EOF {
+				return nil, dberror.ErrInvalidHint
+			}
+			return nil, err
+		}
+		e.Meta.Value = value
+		if err = e.decompress(); err != nil {
+			return nil, err
+		}
+		if err = df.resolveBlob(e, withValue); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"io"
+	"os"
+)
+
+const (
+	// 数据目录中用于加锁的文件名
+	LockFileName = "LOCK"
+)
+
+// DirLocker 可以给数据目录加锁的文件系统，避免多个 db 实例同时打开同一个目录
+type DirLocker interface {
+	// LockDir 给目录加锁，shared 为 true 时加共享锁，目录已经被其他实例锁住时返回 ErrDirLocked
+	// 关闭返回的 io.Closer 释放锁
+	LockDir(path string, shared bool) (io.Closer, error)
+}
+
+type nopLock struct{}
+
+func (nopLock) Close() error { return nil }
+
+// LockDir 给数据目录加锁，排他锁和共享锁互斥，共享锁之间不互斥
+// 没有实现 DirLocker 的文件系统不加锁
+func LockDir(fs FileSystem, path string, shared bool) (io.Closer, error) {
+	if locker, ok := fs.(DirLocker); ok {
+		return locker.LockDir(path, shared)
+	}
+	return nopLock{}, nil
+}
+
+// 使用 flock 锁住目录中的 LOCK 文件，进程退出时锁会被操作系统释放
+// 同一个进程中重复打开同一个目录同样会失败
+// 排他锁同时锁住目录本身；共享锁只读打开 LOCK 文件，不存在时（目录没有被正常打开过，例如备份）锁住目录本身，不修改目录
+func (OSFS) LockDir(path string, shared bool) (io.Closer, error) {
+	if shared {
+		file, err := os.OpenFile(path+PathSeparator+LockFileName, os.O_RDONLY, FilePerPm)
+		if os.IsNotExist(err) {
+			file, err = os.Open(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err = flock(file, true); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return file, nil
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = flock(dir, false); err != nil {
+		dir.Close()
+		return nil, err
+	}
+	file, err := os.OpenFile(path+PathSeparator+LockFileName, os.O_CREATE|os.O_RDWR, FilePerPm)
+	if err != nil {
+		dir.Close()
+		return nil, err
+	}
+	if err = flock(file, false); err != nil {
+		file.Close()
+		dir.Close()
+		return nil, err
+	}
+	return dirLocks{file, dir}, nil
+}
+
+// 排他锁同时持有的 LOCK 文件和目录，关闭时都释放
+type dirLocks []io.Closer
+
+func (l dirLocks) Close() (err error) {
+	for _, c := range l {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return
+}
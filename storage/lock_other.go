@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// 不支持 flock 的平台不加锁
+func flock(file *os.File, shared bool) error {
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package storage
+
+import (
+	"os"
+	"syscall"
+
+	"zeroDB/global/dberror"
+)
+
+func flock(file *os.File, shared bool) error {
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+	err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return dberror.ErrDirLocked
+	}
+	return err
+}
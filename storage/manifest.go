@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"zeroDB/global/dberror"
+)
+
+const (
+	// manifest 的文件名
+	ManifestFileName = "MANIFEST"
+
+	// 写 manifest 时使用的临时后缀，写完后 rename
+	manifestTmpSuffix = ".tmp"
+
+	// crc32(4) + magic(4)
+	manifestHeaderSize = 8
+	manifestMagic      = "ZKVM"
+)
+
+// Manifest 记录每种数据类型当前有效的 dbfile，按加载顺序排列，最后一个是活跃文件
+// file id 只用来区分文件，reclaim 生成的新文件使用新的 id，但是在之后归档的文件之前加载
+// 不在 manifest 中的 dbfile 是中断的 reclaim 留下的，启动时删除
+// 每次修改都先写入临时文件，fsync 之后再 rename 覆盖，崩溃时 manifest 要么是旧的要么是新的
+type Manifest struct {
+	mu     sync.Mutex
+	path   string
+	opts   *Options
+	files  map[uint16][]uint32
+	nextId map[uint16]uint32 // 下一个可以使用的 file id
+}
+
+// 返回 manifest 的路径
+func ManifestPath(path string) string {
+	return path + PathSeparator + ManifestFileName
+}
+
+func newManifest(path string, files map[uint16][]uint32, opts *Options) *Manifest {
+	m := &Manifest{
+		path:   path,
+		opts:   opts,
+		files:  files,
+		nextId: make(map[uint16]uint32),
+	}
+	for typ, ids := range files {
+		for _, id := range ids {
+			if id >= m.nextId[typ] {
+				m.nextId[typ] = id + 1
+			}
+		}
+	}
+	return m
+}
+
+// 读取目录中的 manifest，不存在时返回的 error 满足 os.IsNotExist
+func LoadManifest(path string, opts *Options) (*Manifest, error) {
+	buf, err := opts.fileSystem().ReadFile(ManifestPath(path))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < manifestHeaderSize || string(buf[4:8]) != manifestMagic ||
+		crc32.ChecksumIEEE(buf[4:]) != binary.BigEndian.Uint32(buf[0:4]) {
+		return nil, dberror.ErrInvalidManifest
+	}
+
+	files := make(map[uint16][]uint32)
+	buf = buf[manifestHeaderSize:]
+	for typ := uint16(0); typ < uint16(len(DBFileSuffixName)); typ++ {
+		if len(buf) < 4 {
+			return nil, dberror.ErrInvalidManifest
+		}
+		n := int(binary.BigEndian.Uint32(buf[0:4]))
+		buf = buf[4:]
+		if len(buf) < n*4 {
+			return nil, dberror.ErrInvalidManifest
+		}
+		for i := 0; i < n; i++ {
+			files[typ] = append(files[typ], binary.BigEndian.Uint32(buf[i*4:]))
+		}
+		buf = buf[n*4:]
+	}
+	return newManifest(path, files, opts), nil
+}
+
+func (m *Manifest) encode() []byte {
+	buf := make([]byte, manifestHeaderSize)
+	copy(buf[4:8], manifestMagic)
+	for typ := uint16(0); typ < uint16(len(DBFileSuffixName)); typ++ {
+		ids := m.files[typ]
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(ids)))
+		for _, id := range ids {
+			buf = binary.BigEndian.AppendUint32(buf, id)
+		}
+	}
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// 写入临时文件，fsync 之后 rename 覆盖旧的 manifest 并 fsync 目录，调用者持有 m.mu
+func (m *Manifest) save() error {
+	fs := m.opts.fileSystem()
+	path := ManifestPath(m.path)
+	tmpPath := path + manifestTmpSuffix
+	file, err := fs.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePerPm)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteAt(m.encode(), 0); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = fs.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	// rename 需要 fsync 目录才能持久化，之后才能删除不在新 manifest 中的文件
+	return SyncDir(fs, m.path)
+}
+
+// SaveManifest 在 path 中保存只包含 files 的 manifest，用于生成备份
+func SaveManifest(path string, files map[uint16][]uint32, opts *Options) error {
+	return newManifest(path, files, opts).save()
+}
+
+// FileIds 返回一种数据类型的所有 dbfile 的 id，按加载顺序排列，最后一个是活跃文件
+func (m *Manifest) FileIds(typ uint16) []uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]uint32(nil), m.files[typ]...)
+}
+
+// 活跃文件的 id
+func (m *Manifest) ActiveFileId(typ uint16) uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := m.files[typ]
+	if len(ids) == 0 {
+		return 0
+	}
+	return ids[len(ids)-1]
+}
+
+// NextFileId 分配一个没有使用过的 file id，在 AddFile 或 ReplaceFiles 之前创建的文件不会被加载
+func (m *Manifest) NextFileId(typ uint16) uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := m.nextId[typ]
+	m.nextId[typ]++
+	return id
+}
+
+// AddFile 将新的活跃文件加到末尾并持久化
+func (m *Manifest) AddFile(typ uint16, id uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old := m.files[typ]
+	m.files[typ] = append(append([]uint32(nil), old...), id)
+	if err := m.save(); err != nil {
+		m.files[typ] = old
+		return err
+	}
+	return nil
+}
+
+// ReplaceFiles 使用 newIds 替换 oldIds 并持久化，newIds 放在第一个被替换的文件的位置
+// 持久化成功之后，重启时只会加载新的文件
+func (m *Manifest) ReplaceFiles(typ uint16, oldIds, newIds []uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	replaced := make(map[uint32]struct{}, len(oldIds))
+	for _, id := range oldIds {
+		replaced[id] = struct{}{}
+	}
+	old := m.files[typ]
+	files := make([]uint32, 0, len(old)-len(oldIds)+len(newIds))
+	inserted := false
+	for _, id := range old {
+		if _, ok := replaced[id]; !ok {
+			files = append(files, id)
+			continue
+		}
+		if !inserted {
+			files = append(files, newIds...)
+			inserted = true
+		}
+	}
+	if !inserted {
+		files = append(append([]uint32(nil), newIds...), files...)
+	}
+
+	m.files[typ] = files
+	if err := m.save(); err != nil {
+		m.files[typ] = old
+		return err
+	}
+	return nil
+}
+
+func (m *Manifest) contains(typ uint16, id uint32) bool {
+	for _, fileId := range m.files[typ] {
+		if fileId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// 删除不在 manifest 中的 dbfile 和 hint 文件，以及没有 rename 的临时文件
+// 它们是 reclaim 或者创建活跃文件时崩溃留下的
+func (m *Manifest) removeUnlisted() error {
+	fs := m.opts.fileSystem()
+	names, err := fs.ReadDir(m.path)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		var remove bool
+		if tmpName := strings.TrimSuffix(name, manifestTmpSuffix); tmpName != name {
+			_, _, isHint := parseFileName(tmpName)
+			remove = isHint || tmpName == ManifestFileName
+		} else if typ, id, ok := parseFileName(name); ok {
+			remove = !m.contains(typ, id)
+		}
+		if !remove {
+			continue
+		}
+		if err = fs.Remove(m.path + PathSeparator + name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// 解析 dbfile 或者 hint 文件的文件名，返回数据类型和 file id
+func parseFileName(name string) (uint16, uint32, bool) {
+	parts := strings.Split(name, ".")
+	if len(parts) != 3 || (parts[1] != "data" && parts[1] != "hint") {
+		return 0, 0, false
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	for typ, suffix := range DBFileSuffixName {
+		if parts[2] == suffix {
+			return uint16(typ), uint32(id), true
+		}
+	}
+	return 0, 0, false
+}
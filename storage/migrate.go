@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// 迁移时使用的临时文件后缀，写完后 rename 覆盖原文件
+	migrateTmpSuffix = ".migrate"
+)
+
+// MigrateResult 一个 dbfile 的迁移结果
+type MigrateResult struct {
+	Path      string
+	Entries   int   // 迁移的 entry 数量
+	Discarded int64 // 文件末尾不完整而被丢弃的字节数
+}
+
+// 将旧版本（没有文件头，或者 entry 中没有单独的过期时间）的 dbfile 重写为当前版本的格式
+// 文件已经是当前版本时返回 nil
+// 新文件先写入临时文件，fsync 后再 rename 覆盖原文件，旧的 hint 文件和该类型的 checkpoint 会被删除
+// opts 需要和打开 db 时使用的相同，加密的 entry 需要密钥才能读取，value 在 blob 文件中的 entry 只复制 BlobPointer
+func MigrateFile(path string, fileId uint32, typ uint16, opts *Options) (*MigrateResult, error) {
+	df, err := NewDBFile(path, fileId, typ, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer df.File.Close()
+	if df.Version() == FileVersionCurrent {
+		return nil, nil
+	}
+
+	fs := opts.fileSystem()
+	filepath := df.File.Name()
+	tmpPath := filepath + migrateTmpSuffix
+	tmpFile, err := fs.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, FilePerPm)
+	if err != nil {
+		return nil, err
+	}
+	newDf := &DBFile{Id: fileId, Type: typ, Path: path, File: tmpFile, opts: opts}
+	if err = newDf.loadHeader(false); err != nil {
+		tmpFile.Close()
+		return nil, err
+	}
+
+	result := &MigrateResult{Path: filepath}
+	offset := df.DataOffset()
+	for {
+		e, err := df.ReadUnresolved(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			// 末尾没有写完整的 entry 直接丢弃
+			if err == io.ErrUnexpectedEOF {
+				result.Discarded = df.Offset - offset
+				break
+			}
+			tmpFile.Close()
+			fs.Remove(tmpPath)
+			return nil, fmt.Errorf("%s at offset %d: %w", filepath, offset, err)
+		}
+		offset += int64(e.Size())
+
+		if err = newDf.Write(e); err != nil {
+			tmpFile.Close()
+			fs.Remove(tmpPath)
+			return nil, err
+		}
+		result.Entries++
+	}
+
+	// 新分离到 blob 文件的 value 需要先于 dbfile 持久化
+	if err = opts.blobs().Sync(); err != nil {
+		newDf.Close(false)
+		fs.Remove(tmpPath)
+		return nil, err
+	}
+	if err = newDf.Close(true); err != nil {
+		return nil, err
+	}
+	if err = fs.Rename(tmpPath, filepath); err != nil {
+		return nil, err
+	}
+	// entry 的位置已经改变，旧的 hint 文件不再可用
+	if err = RemoveHintFile(path, fileId, typ, opts); err != nil {
+		return nil, err
+	}
+	// checkpoint 中记录的位置也不再可用
+	if err = RemoveCheckpoint(path, typ, opts); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
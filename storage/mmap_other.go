@@ -0,0 +1,21 @@
+//go:build !unix
+
+package storage
+
+import (
+	"io"
+	"os"
+)
+
+// 不支持 mmap 的平台将整个文件读入内存
+func mmap(file *os.File, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := file.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+import "sync/atomic"
+
+type (
+	// Options dbfile 写入时使用的选项，为 nil 时使用默认值
+	Options struct {
+		// value 的压缩方式
+		Codec Codec
+
+		// value 大于等于该值时才会压缩
+		CompressThreshold uint32
+
+		// 加密使用的密钥，为 nil 时不加密
+		Keyring *Keyring
+
+		// 文件的读写方式，为 nil 时使用操作系统的文件
+		FS FileSystem
+
+		// 保存大 value 的 blob 文件，为 nil 时 value 总是写在 dbfile 中
+		Blobs *BlobStore
+
+		// 写入的统计信息，可以为 nil
+		Stats *Stats
+	}
+
+	// Stats dbfile 写入的统计信息
+	Stats struct {
+		rawValueBytes    uint64 // 压缩前 value 的总大小
+		storedValueBytes uint64 // 实际写入的 value 的总大小
+	}
+)
+
+// 记录一次 value 的写入
+func (s *Stats) addValue(raw, stored int) {
+	if s == nil {
+		return
+	}
+	atomic.AddUint64(&s.rawValueBytes, uint64(raw))
+	atomic.AddUint64(&s.storedValueBytes, uint64(stored))
+}
+
+// 压缩前和实际写入的 value 的总大小
+func (s *Stats) ValueBytes() (raw, stored uint64) {
+	if s == nil {
+		return
+	}
+	return atomic.LoadUint64(&s.rawValueBytes), atomic.LoadUint64(&s.storedValueBytes)
+}
+
+func (o *Options) codec() (Codec, uint32) {
+	if o == nil {
+		return CodecNone, 0
+	}
+	return o.Codec, o.CompressThreshold
+}
+
+func (o *Options) keyring() *Keyring {
+	if o == nil {
+		return nil
+	}
+	return o.Keyring
+}
+
+func (o *Options) fileSystem() FileSystem {
+	if o == nil || o.FS == nil {
+		return OSFS{}
+	}
+	return o.FS
+}
+
+func (o *Options) stats() *Stats {
+	if o == nil {
+		return nil
+	}
+	return o.Stats
+}